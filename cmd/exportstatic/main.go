@@ -0,0 +1,124 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sivy/goldfrog/pkg/blog"
+)
+
+var version string // set in linker with ldflags -X main.version=
+
+var logger = logrus.New()
+
+func main() {
+	logger.SetLevel(logrus.DebugLevel)
+
+	var configDir string
+	var postsDir string
+	var templatesDir string
+	var staticDir string
+	var uploadsDir string
+	var dbFile string
+	var outDir string
+	var showVersionLong bool
+	var showVersion bool
+
+	userHomeDir, _ := os.UserHomeDir()
+	goldfrogHome, found := os.LookupEnv("BLOGHOME")
+	if !found {
+		goldfrogHome = filepath.Join(userHomeDir, "goldfrog")
+	}
+
+	flag.StringVar(
+		&configDir, "config_dir",
+		goldfrogHome,
+		"Location of config file")
+
+	flag.StringVar(
+		&postsDir, "posts_dir",
+		goldfrogHome+"/posts",
+		"Location of your posts (Jekyll-compatible markdown)")
+
+	flag.StringVar(
+		&templatesDir, "templates_dir",
+		goldfrogHome+"/templates",
+		"Location of blog templates")
+
+	flag.StringVar(
+		&staticDir, "static_dir",
+		goldfrogHome+"/static",
+		"Location of static resources to be copied to /static")
+
+	flag.StringVar(
+		&uploadsDir, "uploads_dir",
+		goldfrogHome+"/uploads",
+		"Location of uploaded files to be copied to /uploads")
+
+	flag.StringVar(
+		&dbFile, "db",
+		goldfrogHome+"/blog.db",
+		"File path to sqlite db for indexed content")
+
+	flag.StringVar(
+		&outDir, "out_dir",
+		goldfrogHome+"/public",
+		"Directory to write the static site to")
+
+	flag.BoolVar(
+		&showVersion, "version", false,
+		"Print the version")
+
+	flag.BoolVar(
+		&showVersionLong, "version-long", false,
+		"Print the version (version + git sha1)")
+
+	flag.Parse()
+
+	if showVersionLong {
+		fmt.Println(version)
+		return
+	}
+
+	if showVersion {
+		tag := strings.Split(version, "-")[0]
+		fmt.Println(tag)
+		return
+	}
+
+	config := blog.LoadConfig(configDir)
+	config.Version = version
+
+	if config.PostsDir == "" && postsDir != "" {
+		config.PostsDir = postsDir
+	}
+	if config.TemplatesDir == "" && templatesDir != "" {
+		config.TemplatesDir = templatesDir
+	}
+	if config.StaticDir == "" && staticDir != "" {
+		config.StaticDir = staticDir
+	}
+	if config.UploadsDir == "" && uploadsDir != "" {
+		config.UploadsDir = uploadsDir
+	}
+
+	db, err := blog.GetDb(dbFile)
+	if err != nil {
+		logger.Fatalf("Could not get db connection: %v", err)
+	}
+
+	_, err = blog.LoadTemplates(config.TemplatesDir, false)
+	if err != nil {
+		logger.Warnf("Some templates could not be loaded: %v", err)
+	}
+
+	logger.Infof("Exporting %s to %s", dbFile, outDir)
+	err = blog.ExportStatic(config, db, outDir)
+	if err != nil {
+		logger.Fatal(err)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
@@ -26,7 +27,9 @@ func main() {
 	var templatesDir string
 	var staticDir string
 	var uploadsDir string
+	var originalsDir string
 	var dbFile string
+	var devMode bool
 	var showVersionLong bool
 	var showVersion bool
 
@@ -61,11 +64,20 @@ func main() {
 		goldfrogHome+"/uploads",
 		"Location of directory to store uploaded files to be served at /uploads")
 
+	flag.StringVar(
+		&originalsDir, "originals_dir",
+		goldfrogHome+"/originals",
+		"Location of directory to keep uploaded images as they were sent")
+
 	flag.StringVar(
 		&dbFile, "db",
 		goldfrogHome+"/blog.db",
 		"File path to sqlite db for indexed content")
 
+	flag.BoolVar(
+		&devMode, "dev", false,
+		"Reload templates when they change")
+
 	flag.BoolVar(&showVersionLong, "version-long", false, "")
 	flag.BoolVar(&showVersion, "version", false, "")
 	flag.Parse()
@@ -100,6 +112,12 @@ func main() {
 	if config.UploadsDir == "" && uploadsDir != "" {
 		config.UploadsDir = uploadsDir
 	}
+	if config.OriginalsDir == "" && originalsDir != "" {
+		config.OriginalsDir = originalsDir
+	}
+	if devMode {
+		config.DevMode = true
+	}
 
 	logger.Debug(postsDir)
 	fmt.Println(config.PostsDir)
@@ -137,6 +155,21 @@ func runServer(
 		PostsDirectory: config.PostsDir,
 	}
 
+	err = blog.MigrateDb(db)
+	if err != nil {
+		logger.Fatalf("Could not update db: %v", err)
+	}
+
+	_, err = blog.LoadTemplates(config.TemplatesDir, config.DevMode)
+	if err != nil {
+		logger.Warnf("Some templates could not be loaded: %v", err)
+	}
+
+	err = blog.StartSyndicationWorker(config, db, &repo, time.Minute)
+	if err != nil {
+		logger.Errorf("Could not start syndication retries: %v", err)
+	}
+
 	r := chi.NewRouter()
 
 	r.Use(
@@ -146,34 +179,7 @@ func runServer(
 		middleware.Recoverer,
 	)
 
-	r.Route("/", func(r chi.Router) {
-		r.Mount("/", blog.CreateIndexFunc(config, db))
-		// redirect for old permalinks
-		r.Mount("/{year}/{month}/{dayOrSlug}", blog.CreateDailyPostsFunc(config, db))
-		r.Mount("/{year}/{month}/{day}/{slug}", blog.CreatePostPageFunc(
-			config, db))
-		r.Mount("/archive", blog.CreateArchiveYearMonthFunc(config, db))
-		r.Mount("/archive/{year}/{month}", blog.CreateArchivePageFunc(config, db))
-		r.Mount("/tag/{tag}", blog.CreateTagPageFunc(config, db))
-		r.Mount("/feed.xml", blog.CreateRssFunc(config, db))
-		r.Mount("/feed_daily.xml", blog.CreateDailyRssFunc(config, db))
-		r.Mount("/search", blog.CreateSearchPageFunc(config, db))
-
-		r.Mount("/new", blog.CreateNewPostFunc(config, db, &repo))
-		r.Mount(
-			"/edit/{postID}",
-			blog.CreateEditPostFunc(config, db, &repo))
-		r.Mount(
-			"/edit",
-			blog.CreateEditPostFunc(config, db, &repo))
-		r.Mount("/delete", blog.CreateDeletePostFunc(config, db, &repo))
-
-		r.Mount("/signin", blog.CreateSigninPageFunc(config, dbFile))
-		r.Mount("/signout", blog.CreateSignoutPageFunc(config, dbFile))
-
-		blog.FileServer(r, "/static", http.Dir(config.StaticDir))
-		blog.FileServer(r, "/uploads", http.Dir(config.UploadsDir))
-	})
+	r.Mount("/", blog.Routes(config, db, &repo, dbFile))
 
 	loc := fmt.Sprintf(fmt.Sprintf(
 		"%s:%s", config.Server.Location,
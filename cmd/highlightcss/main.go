@@ -0,0 +1,102 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sivy/goldfrog/pkg/blog"
+	"github.com/sivy/goldfrog/pkg/render"
+)
+
+var version string // set in linker with ldflags -X main.version=
+
+var logger = logrus.New()
+
+/*
+highlightcss writes the stylesheet for highlighted code blocks into the
+static dir, for the theme given or the one in the config.
+*/
+func main() {
+	var configDir string
+	var staticDir string
+	var theme string
+	var fileName string
+	var listThemes bool
+	var showVersion bool
+
+	userHomeDir, _ := os.UserHomeDir()
+	goldfrogHome, found := os.LookupEnv("BLOGHOME")
+	if !found {
+		goldfrogHome = filepath.Join(userHomeDir, "goldfrog")
+	}
+
+	flag.StringVar(
+		&configDir, "config_dir",
+		goldfrogHome,
+		"Location of config file")
+
+	flag.StringVar(
+		&staticDir, "static_dir",
+		goldfrogHome+"/static",
+		"Location of static resources served at /static")
+
+	flag.StringVar(
+		&theme, "theme", "",
+		"Chroma style to use (default markdown.highlighttheme, or "+render.DefaultTheme+")")
+
+	flag.StringVar(
+		&fileName, "out", "highlight.css",
+		"Name of the stylesheet in the static dir")
+
+	flag.BoolVar(
+		&listThemes, "list", false,
+		"List the themes")
+
+	flag.BoolVar(
+		&showVersion, "version", false,
+		"Print the version")
+
+	flag.Parse()
+
+	if showVersion {
+		fmt.Println(strings.Split(version, "-")[0])
+		return
+	}
+
+	if listThemes {
+		fmt.Println(strings.Join(render.Themes(), "\n"))
+		return
+	}
+
+	config := blog.LoadConfig(configDir)
+	if config.StaticDir == "" && staticDir != "" {
+		config.StaticDir = staticDir
+	}
+	if theme == "" {
+		theme = config.Markdown.HighlightTheme
+	}
+	if theme == "" {
+		theme = render.DefaultTheme
+	}
+
+	path := filepath.Join(config.StaticDir, fileName)
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		logger.Fatalf("Could not create %s: %v", filepath.Dir(path), err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		logger.Fatalf("Could not create %s: %v", path, err)
+	}
+	defer f.Close()
+
+	err = render.WriteStylesheet(f, theme)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	logger.Infof("Wrote the %s theme to %s", theme, path)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sivy/goldfrog/pkg/blog"
+)
+
+var version string // set in linker with ldflags -X main.version=
+
+var logger = logrus.New()
+
+/*
+linklint lists the wiki links to posts that don't exist, from the link
+graph the indexer keeps, and exits with status 1 if there are any.
+*/
+func main() {
+	var dbFile string
+	var showVersionLong bool
+	var showVersion bool
+
+	userHomeDir, _ := os.UserHomeDir()
+	goldfrogHome, found := os.LookupEnv("BLOGHOME")
+	if !found {
+		goldfrogHome = filepath.Join(userHomeDir, "goldfrog")
+	}
+
+	flag.StringVar(
+		&dbFile, "db",
+		goldfrogHome+"/blog.db",
+		"File path to sqlite db for indexed content")
+
+	flag.BoolVar(&showVersionLong, "version-long", false, "")
+	flag.BoolVar(&showVersion, "version", false, "")
+
+	flag.Parse()
+
+	if showVersionLong {
+		fmt.Println(version)
+		return
+	}
+
+	if showVersion {
+		tag := strings.Split(version, "-")[0]
+		fmt.Println(tag)
+		return
+	}
+
+	db, err := blog.GetDb(dbFile)
+	if err != nil {
+		logger.Fatalf("Could not get db connection: %v", err)
+	}
+
+	links, err := blog.GetBrokenLinks(db)
+	if err != nil {
+		logger.Fatalf("Could not check links (has the indexer run?): %v", err)
+	}
+
+	for _, link := range links {
+		fmt.Printf("%s: [[%s]]\n", link.PostSlug, link.Target)
+	}
+	if len(links) > 0 {
+		logger.Errorf("%d broken links", len(links))
+		os.Exit(1)
+	}
+}
@@ -4,6 +4,7 @@ go 1.13
 
 require (
 	github.com/PuerkitoBio/goquery v1.5.1
+	github.com/alecthomas/chroma v0.10.0
 	github.com/araddon/dateparse v0.0.0-20190622164848-0fb0a474d195
 	github.com/dghubble/oauth1 v0.6.0
 	github.com/fsnotify/fsnotify v1.4.7
@@ -14,11 +15,13 @@ require (
 	github.com/mattn/go-mastodon v0.0.4
 	github.com/mattn/go-sqlite3 v2.0.3+incompatible
 	github.com/microcosm-cc/bluemonday v1.0.2
+	github.com/mitchellh/mapstructure v1.1.2
 	github.com/opentracing/opentracing-go v1.1.0
+	github.com/rivo/uniseg v0.4.7
 	github.com/sirupsen/logrus v1.4.2
 	github.com/sivy/go-twitter v0.0.0-20200228143626-89362039a5e4
 	github.com/spf13/viper v1.6.2
-	github.com/stretchr/testify v1.5.1
+	github.com/stretchr/testify v1.7.0
 	github.com/tomnomnom/linkheader v0.0.0-20180905144013-02ca5825eb80
 	gopkg.in/yaml.v2 v2.2.8
 )
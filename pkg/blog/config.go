@@ -3,6 +3,7 @@ package blog
 import (
 	"bytes"
 
+	"github.com/sivy/goldfrog/pkg/syndication"
 	"github.com/spf13/viper"
 )
 
@@ -39,9 +40,61 @@ type Config struct {
 	TemplatesDir string `json:"templatesdir" yaml:"templatesdir"`
 	StaticDir    string `json:"staticdir" yaml:"staticdir"`
 	UploadsDir   string `json:"uploadsdir" yaml:"uploadsdir"`
+	// OriginalsDir keeps uploaded images as they were sent, it defaults
+	// to "originals" next to UploadsDir
+	OriginalsDir string `json:"originalsdir" yaml:"originalsdir"`
+
+	// DevMode reloads templates when they change on disk
+	DevMode bool `json:"devmode" yaml:"devmode"`
+
+	Markdown struct {
+		// Extensions lists the markdown extensions to use, out of
+		// footnotes, tables, tasklists, definitionlists, smartquotes,
+		// headinganchors, highlighting, math and diagrams; all of them
+		// when it's empty
+		Extensions []string `yaml:"extensions"`
+		// HighlightTheme is the chroma style the highlightcss command
+		// writes the stylesheet for highlighted code with
+		HighlightTheme string `yaml:"highlighttheme"`
+		// LinkCards renders urls on a line of their own as cards with
+		// a preview of the linked page
+		LinkCards bool `yaml:"linkcards"`
+		// DiagramsDir keeps the SVG drawn for diagrams when posts are
+		// saved, it defaults to "diagrams" next to UploadsDir
+		DiagramsDir string `yaml:"diagramsdir"`
+		// DiagramTools overrides the command drawing a kind of diagram
+		// (dot, mermaid), which reads the diagram on stdin and writes
+		// SVG to stdout
+		DiagramTools map[string]string `yaml:"diagramtools"`
+	} `yaml:"markdown"`
 
 	WebMentionEnabled bool `json:"webmentionenabled" yaml:"webmentionenabled"`
 
+	Feeds struct {
+		// number of posts in feeds, defaults to 10
+		Items int `yaml:"items"`
+		// per-slice overrides for Items
+		TagItems    int `yaml:"tagitems"`
+		AuthorItems int `yaml:"authoritems"`
+		KindItems   int `yaml:"kinditems"`
+		SeriesItems int `yaml:"seriesitems"`
+		// number of days in daily feeds, defaults to 10
+		DailyDays int `yaml:"dailydays"`
+	} `yaml:"feeds"`
+
+	WebSub struct {
+		// Hub is an external hub to advertise and ping on publish,
+		// it takes precedence over the builtin hub
+		Hub string `yaml:"hub"`
+		// BuiltinHub runs goldfrog's own hub at /websub
+		BuiltinHub bool `yaml:"builtinhub"`
+	} `yaml:"websub"`
+
+	Cache struct {
+		// max-age in seconds for pages and feeds served to readers
+		MaxAge int `yaml:"maxage"`
+	} `yaml:"cache"`
+
 	Signin struct {
 		Username string `yaml:"username"`
 		Password string `yaml:"password"`
@@ -52,6 +105,16 @@ type Config struct {
 		Port     string `yaml:"port"`
 	} `yaml:"server"`
 
+	// Syndication lists the places posts can be sent to, see
+	// SyndicationTargets; the twitter and mastodon sections below
+	// are still read as targets named "twitter" and "mastodon"
+	Syndication []syndication.Target `yaml:"syndication"`
+
+	Micropub struct {
+		// Token authorizes Micropub clients, sent as a bearer token
+		Token string `yaml:"token"`
+	} `yaml:"micropub"`
+
 	Twitter struct {
 		ClientKey    string `yaml:"clientkey"`
 		ClientSecret string `yaml:"clientsecret"`
@@ -59,6 +122,7 @@ type Config struct {
 		AccessSecret string `yaml:"accessecret"`
 		UserID       string `yaml:"userid"`
 		LinkFormat   string `yaml:"linkformat"`
+		Template     string `yaml:"template"`
 	} `yaml:"twitter"`
 
 	Mastodon struct {
@@ -67,6 +131,12 @@ type Config struct {
 		ClientSecret string `yaml:"clientsecret"`
 		AccessToken  string `yaml:"accesstoken"`
 		LinkFormat   string `yaml:"linkformat"`
+		Template     string `yaml:"template"`
+
+		Visibility     string `yaml:"visibility"`
+		ContentWarning string `yaml:"contentwarning"`
+		Language       string `yaml:"language"`
+		Thread         bool   `yaml:"thread"`
 	} `yaml:"mastodon"`
 }
 
@@ -0,0 +1,44 @@
+package blog
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/sivy/goldfrog/pkg/render"
+)
+
+func (config Config) diagramsDir() string {
+	if config.Markdown.DiagramsDir != "" {
+		return config.Markdown.DiagramsDir
+	}
+	if config.UploadsDir == "" {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(filepath.Clean(config.UploadsDir)), "diagrams")
+}
+
+// newDiagramCache sets up the diagrams cache, nil if there's no dir for it
+func newDiagramCache(config Config) *render.DiagramCache {
+	dir := config.diagramsDir()
+	if dir == "" {
+		return nil
+	}
+	cache := render.NewDiagramCache(dir)
+	for kind, command := range config.Markdown.DiagramTools {
+		cache.Tools[strings.ToLower(kind)] = strings.Fields(command)
+	}
+	return cache
+}
+
+/*
+prerenderDiagrams draws the diagrams in post's body that haven't been
+drawn yet, so pages only ever read them from the cache, and drops its
+old rendering (which has them as code).
+*/
+func prerenderDiagrams(post *Post) {
+	err := markdownRenderer.Prerender(post.Body)
+	if err != nil {
+		logger.Warnf("Could not draw the diagrams in %s: %v", post.Slug, err)
+	}
+	renderedMarkdown.Forget(post.Body)
+}
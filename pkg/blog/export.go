@@ -0,0 +1,305 @@
+package blog
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	exportPageSize int = 20
+)
+
+/*
+StaticExporter renders every page goldfrogd serves into a directory
+that can be uploaded to a plain static host. Pages are requested from
+the same handlers goldfrogd uses (ReadOnlyRoutes), so they come out
+exactly as they are served, minus anything only the owner sees.
+*/
+type StaticExporter struct {
+	Config    Config
+	OutputDir string
+
+	db        *sql.DB
+	handler   http.Handler
+	redirects map[string]string
+	failures  []string
+}
+
+func NewStaticExporter(
+	config Config, db *sql.DB, outputDir string) *StaticExporter {
+	return &StaticExporter{
+		Config:    config,
+		OutputDir: outputDir,
+		db:        db,
+		handler:   ReadOnlyRoutes(config, db),
+		redirects: make(map[string]string),
+	}
+}
+
+// ExportStatic renders the blog in db into outputDir
+func ExportStatic(
+	config Config, db *sql.DB, outputDir string) error {
+	return NewStaticExporter(config, db, outputDir).Export()
+}
+
+func (e *StaticExporter) Export() error {
+	logger.Infof("Exporting static site to %s", e.OutputDir)
+
+	err := os.MkdirAll(e.OutputDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	paths := e.Paths()
+	for _, path := range paths {
+		e.exportPath(path)
+	}
+	logger.Infof("Exported %d pages", len(paths)-len(e.failures))
+
+	err = e.writeRedirects()
+	if err != nil {
+		return err
+	}
+
+	for dir, target := range map[string]string{
+		e.Config.StaticDir:  "static",
+		e.Config.UploadsDir: "uploads",
+	} {
+		if dir == "" {
+			continue
+		}
+		err := copyDir(dir, filepath.Join(e.OutputDir, target))
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(e.failures) > 0 {
+		return errors.New(fmt.Sprintf(
+			"%d pages could not be exported: %s",
+			len(e.failures), strings.Join(e.failures, ", ")))
+	}
+	return nil
+}
+
+/*
+Paths lists the url of every page to export: the index pages, post
+permalinks, daily pages, the archive, tag and series pages and all the
+feeds. It also records redirects for the old /{year}/{month}/{slug}
+permalinks.
+*/
+func (e *StaticExporter) Paths() []string {
+	posts := GetPosts(e.db, GetPostOpts{Limit: -1})
+
+	paths := []string{"/"}
+	for page := 1; page*exportPageSize < len(posts); page++ {
+		paths = append(paths, fmt.Sprintf("/?page=%d", page))
+	}
+
+	paths = append(paths,
+		"/feed.xml", "/feed_daily.xml",
+		"/archive",
+	)
+	for _, kind := range PostKinds {
+		paths = append(paths,
+			kindPath(kind)+"/feed.xml", kindPath(kind)+"/feed_daily.xml")
+	}
+
+	if author := e.Config.Blog.Author.Name; author != "" {
+		paths = append(paths,
+			"/author/"+url.PathEscape(author)+"/feed.xml",
+			"/author/"+url.PathEscape(author)+"/feed_daily.xml")
+	}
+
+	days := make(map[string]bool)
+	for _, post := range posts {
+		// posts with a title have their own page
+		if post.Title != "" {
+			paths = append(paths, post.PermaLink())
+		}
+
+		day := post.PostDate.Format("/2006/01/02")
+		if !days[day] {
+			days[day] = true
+			paths = append(paths, day)
+		}
+
+		oldLink := fmt.Sprintf("%s/%s", post.PostDate.Format("/2006/01"), post.Slug)
+		e.redirects[oldLink] = post.PermaLink()
+	}
+
+	for _, entry := range GetArchiveYearMonths(e.db) {
+		paths = append(paths, fmt.Sprintf("/archive/%s/%s", entry.Year, entry.Month))
+	}
+
+	for _, tc := range GetTagCounts(e.db) {
+		tag := url.PathEscape(tc.Tag)
+		paths = append(paths,
+			"/tag/"+tag,
+			"/tag/"+tag+"/feed.xml",
+			"/tag/"+tag+"/feed_daily.xml")
+	}
+
+	for _, name := range GetSeriesNames(e.db) {
+		link := seriesPath(name)
+		paths = append(paths, link, link+"/feed.xml", link+"/feed_daily.xml")
+	}
+
+	return paths
+}
+
+func (e *StaticExporter) exportPath(path string) {
+	req := httptest.NewRequest("GET", path, nil)
+	rr := httptest.NewRecorder()
+	e.handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		logger.Errorf("Could not export %s: %d", path, rr.Code)
+		e.failures = append(e.failures, path)
+		return
+	}
+
+	content := rr.Body.Bytes()
+	if strings.HasPrefix(rr.Header().Get("Content-Type"), "text/html") {
+		content = e.rewritePageLinks(content)
+	}
+
+	err := e.writeFile(path, content)
+	if err != nil {
+		logger.Errorf("Could not write %s: %v", path, err)
+		e.failures = append(e.failures, path)
+	}
+}
+
+/*
+rewritePageLinks points the templates' /?page={n} links (relative or
+on the blog url) at the /page/{n}/ files outputFile writes them to.
+*/
+func (e *StaticExporter) rewritePageLinks(content []byte) []byte {
+	base := regexp.QuoteMeta(strings.TrimRight(e.Config.Blog.Url, "/"))
+	pageLink := regexp.MustCompile(
+		`(href=["'])(?:` + base + `)?/?\?page=(\d+)(["'])`)
+
+	return pageLink.ReplaceAllFunc(content, func(m []byte) []byte {
+		parts := pageLink.FindSubmatch(m)
+		link := "/"
+		if page := string(parts[2]); strings.TrimLeft(page, "0") != "" {
+			link = "/page/" + page + "/"
+		}
+		return []byte(string(parts[1]) + link + string(parts[3]))
+	})
+}
+
+/*
+outputFile maps a url to a file: feeds keep their names, pages
+become {path}/index.html and index pagination goes to /page/{n}/.
+*/
+func (e *StaticExporter) outputFile(path string) string {
+	u, _ := url.Parse(path)
+	p, _ := url.PathUnescape(u.Path)
+
+	if page := u.Query().Get("page"); page != "" {
+		p = "/page/" + page
+	}
+
+	if strings.HasSuffix(p, ".xml") {
+		return filepath.Join(e.OutputDir, filepath.FromSlash(p))
+	}
+	return filepath.Join(e.OutputDir, filepath.FromSlash(p), "index.html")
+}
+
+func (e *StaticExporter) writeFile(path string, content []byte) error {
+	file := e.outputFile(path)
+	logger.Debugf("Write %s -> %s", path, file)
+
+	err := os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, content, 0644)
+}
+
+/*
+writeRedirects writes a meta refresh page for every old permalink,
+plus a _redirects file for hosts that can do real redirects.
+*/
+func (e *StaticExporter) writeRedirects() error {
+	var lines []string
+
+	for from, to := range e.redirects {
+		target := html.EscapeString(to)
+		page := fmt.Sprintf(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Redirecting...</title>
+<link rel="canonical" href="%s">
+<meta http-equiv="refresh" content="0; url=%s">
+</head>
+<body><a href="%s">%s</a></body>
+</html>
+`, target, target, target, target)
+
+		err := e.writeFile(from, []byte(page))
+		if err != nil {
+			return err
+		}
+		lines = append(lines, fmt.Sprintf("%s %s 301", from, to))
+	}
+
+	return ioutil.WriteFile(
+		filepath.Join(e.OutputDir, "_redirects"),
+		[]byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
+
+func copyDir(src string, dst string) error {
+	if _, err := os.Stat(src); os.IsNotExist(err) {
+		logger.Warnf("Not copying %s, it does not exist", src)
+		return nil
+	}
+	logger.Infof("Copying %s to %s", src, dst)
+
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		return copyFile(path, target)
+	})
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, in)
+	return err
+}
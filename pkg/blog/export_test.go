@@ -0,0 +1,81 @@
+package blog
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportPaths(t *testing.T) {
+	initDb(testDb)
+	db, _ := GetDb(testDb)
+	defer func() {
+		os.Remove(testDb)
+	}()
+
+	date := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+	article := NewPost(PostOpts{
+		Title: "an article", Slug: "an-article", Tags: []string{"golang"},
+		PostDate: date, FrontMatter: map[string]string{"series": "Go Tour"}})
+	note := NewPost(PostOpts{Slug: "txt-1234567", PostDate: date})
+	CreatePost(db, &article)
+	CreatePost(db, &note)
+
+	var config Config
+	config.WebSub.BuiltinHub = true
+	exporter := NewStaticExporter(config, db, "/out")
+	paths := exporter.Paths()
+
+	// only the read-only pages, no hub or forms
+	var table string
+	err := db.QueryRow(`SELECT name FROM sqlite_master
+		WHERE name = 'websub_subscriptions'`).Scan(&table)
+	assert.Equal(t, sql.ErrNoRows, err)
+
+	assert.Contains(t, paths, "/")
+	assert.Contains(t, paths, "/feed.xml")
+	assert.Contains(t, paths, "/2020/03/01/an-article")
+	assert.Contains(t, paths, "/2020/03/01")
+	assert.Contains(t, paths, "/archive/2020/03")
+	assert.Contains(t, paths, "/tag/golang")
+	assert.Contains(t, paths, "/tag/golang/feed.xml")
+	assert.Contains(t, paths, "/series/Go%20Tour")
+	assert.Contains(t, paths, "/series/Go%20Tour/feed.xml")
+	// notes live on their daily page
+	assert.NotContains(t, paths, "/2020/03/01/txt-1234567")
+
+	assert.Equal(t, "/2020/03/01/an-article", exporter.redirects["/2020/03/an-article"])
+	assert.Equal(t, "/2020/03/01/#txt-1234567", exporter.redirects["/2020/03/txt-1234567"])
+}
+
+func TestExportOutputFile(t *testing.T) {
+	exporter := &StaticExporter{OutputDir: "/out"}
+
+	assert.Equal(t, filepath.FromSlash("/out/index.html"), exporter.outputFile("/"))
+	assert.Equal(t, filepath.FromSlash("/out/page/2/index.html"), exporter.outputFile("/?page=2"))
+	assert.Equal(t, filepath.FromSlash("/out/feed.xml"), exporter.outputFile("/feed.xml"))
+	assert.Equal(t, filepath.FromSlash("/out/tag/go/feed.xml"), exporter.outputFile("/tag/go/feed.xml"))
+	assert.Equal(t,
+		filepath.FromSlash("/out/author/Steve Ivy/feed.xml"),
+		exporter.outputFile("/author/Steve%20Ivy/feed.xml"))
+	assert.Equal(t,
+		filepath.FromSlash("/out/2020/03/01/an-article/index.html"),
+		exporter.outputFile("/2020/03/01/an-article"))
+}
+
+func TestExportPageLinks(t *testing.T) {
+	var config Config
+	config.Blog.Url = "https://blog.example/"
+	exporter := &StaticExporter{Config: config, OutputDir: "/out"}
+
+	html := `<a href="/?page=2">older</a> <a href='?page=0'>newer</a>
+<a href="https://blog.example/?page=1">1</a> <a href="/tag/go?page=2">tag</a>`
+	assert.Equal(t,
+		`<a href="/page/2/">older</a> <a href='/'>newer</a>
+<a href="/page/1/">1</a> <a href="/tag/go?page=2">tag</a>`,
+		string(exporter.rewritePageLinks([]byte(html))))
+}
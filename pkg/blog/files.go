@@ -83,9 +83,19 @@ func (repo *FilePostsRepo) DeletePostFile(post *Post) error {
 	return nil
 }
 
+/*
+GetFrontMatterMedia reads the `media` list of a post's front matter,
+which GetFrontMatter leaves out.
+*/
+func GetFrontMatterMedia(frontmatter string) []Media {
+	media, _ := frontMatterMedia(frontmatter)
+	return media
+}
+
 /*
 GetFrontMatter does a simple key: value parse on the
-"yaml" at the front of a post.
+"yaml" at the front of a post. Values that aren't scalars, like the
+media list, are left out.
 */
 func GetFrontMatter(frontmatter string) map[string]string {
 	requiredKeys := []string{
@@ -96,6 +106,7 @@ func GetFrontMatter(frontmatter string) map[string]string {
 
 	var fm = make(map[string]string)
 
+	// lists and maps don't fit in fm, yaml skips them and carries on
 	yaml.Unmarshal([]byte(frontmatter), &fm)
 
 	// for _, line := range strings.Split(frontmatter, "\n") {
@@ -151,6 +162,7 @@ func ParseFile(path string) (Post, error) {
 	frontMatter := GetFrontMatter(frontMatterStr)
 
 	post.FrontMatter = frontMatter
+	post.Media = GetFrontMatterMedia(frontMatterStr)
 	// logger.Debug(frontMatter)
 	body := fileParts[1]
 	// logger.Debug(body)
@@ -189,8 +201,7 @@ func ParseFile(path string) (Post, error) {
 	}
 
 	// add post hashtags, cause that's cool
-	processedBody := fmt.Sprintf("%s", markDowner(post.Body))
-	hashtags := GetHashTags(processedBody)
+	hashtags := markdownRenderer.Hashtags(post.Body)
 
 	fmt.Printf("Found hashtags: %v", hashtags)
 	for _, t := range hashtags {
@@ -6,6 +6,7 @@ package blog
 import (
 	"encoding/base64"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -36,6 +37,17 @@ func GetFlash(w http.ResponseWriter, r *http.Request, name string) (string, erro
 	return value, nil
 }
 
+// joinFlash puts several messages in one flash
+func joinFlash(messages ...string) string {
+	var parts []string
+	for _, m := range messages {
+		if m != "" {
+			parts = append(parts, m)
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
 // -------------------------
 
 func encode(src []byte) string {
@@ -11,21 +11,50 @@ import (
 	"strings"
 	tmplText "text/template"
 
-	"github.com/gomarkdown/markdown"
-	"github.com/gomarkdown/markdown/parser"
 	"github.com/leekchan/gtf"
-	"github.com/microcosm-cc/bluemonday"
+	"github.com/sivy/goldfrog/pkg/render"
 	"github.com/sivy/goldfrog/pkg/syndication"
 )
 
+// markdownRenderer renders post bodies, set up from the config by initMarkdown
+var markdownRenderer = newMarkdownRenderer(render.DefaultExtensions)
+
+func newMarkdownRenderer(extensions render.Extensions) *render.Renderer {
+	return render.New(extensions).
+		Transform(
+			render.LinkWikiLinks(resolveWikiLink),
+			render.LinkHashtags(tagURL)).
+		Hook(renderLinkCard)
+}
+
+func initMarkdown(config Config) {
+	extensions, err := render.ParseExtensions(config.Markdown.Extensions)
+	if err != nil {
+		logger.Errorf("Could not set up markdown: %v", err)
+	}
+	renderer := newMarkdownRenderer(extensions)
+	if config.TemplatesDir != "" {
+		err = renderer.Shortcodes.Load(filepath.Join(config.TemplatesDir, "shortcodes"))
+		if err != nil {
+			logger.Errorf("Could not load shortcodes: %v", err)
+		}
+	}
+	renderer.DiagramCache = newDiagramCache(config)
+	markdownRenderer = renderer
+	renderedMarkdown.Reset()
+}
+
+func tagURL(tag string) string {
+	return "/tag/" + strings.ToLower(tag)
+}
+
 func markDowner(args ...interface{}) template.HTML {
-	extensions := parser.CommonExtensions | parser.HeadingIDs
-	parser := parser.NewWithExtensions(extensions)
 	content := fmt.Sprintf("%s", args...)
-	s := markdown.ToHTML(
-		[]byte(content), parser, nil)
+	return renderedMarkdown.Get(content)
+}
 
-	return template.HTML(s)
+func renderMarkdown(content string) template.HTML {
+	return markdownRenderer.HTML(content)
 }
 
 func excerpter(args ...interface{}) template.HTML {
@@ -39,11 +68,24 @@ func htmlEscaper(args ...interface{}) string {
 	return s
 }
 
+/*
+hashtagger links hashtags in html. The markdown renderer links them
+already, so this leaves links (and code) alone.
+*/
 func hashtagger(args ...interface{}) template.HTML {
 	s := fmt.Sprintf("%s", args...)
 	re := regexp.MustCompile(`([\s\>])#([[:alnum:]]+)\b`)
-	s = re.ReplaceAllString(s, "$1<a href=\"/tag/$2\">#$2</a>")
-	return template.HTML(s)
+	links := regexp.MustCompile(`(?is)<a\b.*?</a>|<code\b.*?</code>`)
+
+	var out strings.Builder
+	last := 0
+	for _, loc := range links.FindAllStringIndex(s, -1) {
+		out.WriteString(re.ReplaceAllString(s[last:loc[0]], "$1<a href=\"/tag/$2\">#$2</a>"))
+		out.WriteString(s[loc[0]:loc[1]])
+		last = loc[1]
+	}
+	out.WriteString(re.ReplaceAllString(s[last:], "$1<a href=\"/tag/$2\">#$2</a>"))
+	return template.HTML(out.String())
 }
 
 func tweetLinker(args ...interface{}) template.HTML {
@@ -78,17 +120,45 @@ func tootLinker(args ...interface{}) template.HTML {
 // 	}
 // }
 
-func getTemplate(templatesDir string, name string) (*template.Template, error) {
-	t := template.New("").Funcs(template.FuncMap{
-		"markdown":  markDowner,
-		"excerpt":   excerpter,
-		"escape":    htmlEscaper,
-		"hashtags":  hashtagger,
-		"striphtml": stripHTML,
-		"tweetlink": tweetLinker,
-		"tootlink":  tootLinker,
+func templateFuncs() map[string]interface{} {
+	return map[string]interface{}{
+		"markdown":     markDowner,
+		"excerpt":      excerpter,
+		"escape":       htmlEscaper,
+		"hashtags":     hashtagger,
+		"striphtml":    stripHTML,
+		"tweetlink":    tweetLinker,
+		"tootlink":     tootLinker,
+		"gallery":      galleryer,
+		"img":          imager,
+		"hentry":       hentryer,
+		"replycontext": replyContexter,
 		// "isOwner": makeIsOwner(isOwner)
-	}).Funcs(gtf.GtfFuncMap)
+	}
+}
+
+/*
+getTemplate returns the parsed page template `name` along with the
+base templates, from the registry loaded with LoadTemplates if there
+is one for templatesDir.
+*/
+func getTemplate(templatesDir string, name string) (*template.Template, error) {
+	if registry := getRegistry(templatesDir); registry != nil {
+		return registry.Template(name)
+	}
+	return parseTemplate(templatesDir, name)
+}
+
+func getTextTemplate(templatesDir string, name string) (*tmplText.Template, error) {
+	if registry := getRegistry(templatesDir); registry != nil {
+		return registry.TextTemplate(name)
+	}
+	return parseTextTemplate(templatesDir, name)
+}
+
+func parseTemplate(templatesDir string, name string) (*template.Template, error) {
+	t := template.New("").Funcs(
+		template.FuncMap(templateFuncs())).Funcs(gtf.GtfFuncMap)
 
 	t, err := t.ParseGlob(filepath.Join(templatesDir, "base/*.html"))
 	if err != nil {
@@ -105,17 +175,9 @@ func getTemplate(templatesDir string, name string) (*template.Template, error) {
 	return t, nil
 }
 
-func getTextTemplate(templatesDir string, name string) (*tmplText.Template, error) {
-	t := tmplText.New("").Funcs(tmplText.FuncMap{
-		"markdown":  markDowner,
-		"excerpt":   excerpter,
-		"escape":    htmlEscaper,
-		"hashtags":  hashtagger,
-		"striphtml": stripHTML,
-		"tweetlink": tweetLinker,
-		"tootlink":  tootLinker,
-		// "isOwner": makeIsOwner(isOwner)
-	}).Funcs(tmplText.FuncMap{
+func parseTextTemplate(templatesDir string, name string) (*tmplText.Template, error) {
+	t := tmplText.New("").Funcs(
+		tmplText.FuncMap(templateFuncs())).Funcs(tmplText.FuncMap{
 		"join":    gtf.GtfFuncMap["join"],
 		"default": gtf.GtfFuncMap["default"],
 		"replace": gtf.GtfFuncMap["replace"],
@@ -178,7 +240,7 @@ func checkIsOwner(config Config, r *http.Request) bool {
 
 func updateTags(body string, tags []string) []string {
 	logger.Debugf("Start tags: %q", tags)
-	hashtags := GetHashTags(body)
+	hashtags := markdownRenderer.Hashtags(body)
 	logger.Debugf("Found hashtags: %v", hashtags)
 	for _, t := range hashtags {
 		if !tagInTags(t, tags) {
@@ -223,13 +285,5 @@ func getPaginationOpts(r *http.Request, opts *GetPostOpts) {
 
 func stripHTML(args ...interface{}) string {
 	content := fmt.Sprintf("%s", args...)
-	extensions := parser.CommonExtensions
-	parser := parser.NewWithExtensions(extensions)
-
-	s := markdown.ToHTML([]byte(content), parser, nil)
-
-	p := bluemonday.StrictPolicy()
-	plaintext := p.Sanitize(string(s))
-
-	return plaintext
+	return markdownRenderer.Text(content)
 }
@@ -0,0 +1,505 @@
+package blog
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/araddon/dateparse"
+	"github.com/go-chi/chi"
+)
+
+const (
+	APIPREFIX string = "/api/v1"
+
+	apiDefaultLimit int = 20
+	apiMaxLimit     int = 100
+)
+
+/*
+The JSON API, mounted at /api/v1:
+
+	GET    /posts               list posts; filters: tag, kind, since,
+	                            until, q, limit, cursor
+	POST   /posts               create a post
+	GET    /posts/{postID}      get a post
+	PUT    /posts/{postID}      update a post, and the copies already
+	                            syndicated unless skip_propagation;
+	                            syndicate skips targets it's already
+	                            on unless force
+	DELETE /posts/{postID}      delete a post, and its syndicated
+	                            copies unless ?skip_propagation=true
+	GET    /posts/slug/{slug}   get a post by slug
+	GET    /archive             post and note counts by month
+	GET    /tags                tags with their post counts
+
+Writes need the owner's signin cookie or HTTP Basic auth with the
+signin username and password. Errors come back as
+{"error": {"status": 404, "message": "..."}}.
+*/
+func APIRoutes(config Config, db *sql.DB, repo PostsRepo) chi.Router {
+	r := chi.NewRouter()
+
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusNotFound, "Not found")
+	})
+	r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	})
+
+	r.Get("/posts", CreateAPIListPostsFunc(config, db))
+	r.Get("/posts/slug/{slug}", CreateAPIGetPostFunc(config, db))
+	r.Get("/posts/{postID}", CreateAPIGetPostFunc(config, db))
+	r.Get("/archive", CreateAPIArchiveFunc(config, db))
+	r.Get("/tags", CreateAPITagsFunc(config, db))
+
+	r.Group(func(r chi.Router) {
+		r.Use(requireAPIOwner(config))
+		r.Post("/posts", CreateAPICreatePostFunc(config, db, repo))
+		r.Put("/posts/{postID}", CreateAPIUpdatePostFunc(config, db, repo))
+		r.Delete("/posts/{postID}", CreateAPIDeletePostFunc(config, db, repo))
+	})
+
+	return r
+}
+
+// apiPost is a Post as the API returns it
+type apiPost struct {
+	*Post
+	Kind string `json:"kind"`
+	URL  string `json:"url"`
+}
+
+func newAPIPost(config Config, post *Post) apiPost {
+	return apiPost{
+		Post: post,
+		Kind: post.Kind(),
+		URL:  config.Blog.Url + post.PermaLink(),
+	}
+}
+
+/*
+apiPostInput is the body of create and update requests. On update it
+starts out filled from the stored post, so fields left out of the
+request keep their values; front matter keys are merged.
+*/
+type apiPostInput struct {
+	Title       string            `json:"title"`
+	Slug        string            `json:"slug"`
+	Date        string            `json:"date"`
+	Tags        []string          `json:"tags"`
+	Body        string            `json:"body"`
+	FrontMatter map[string]string `json:"frontmatter"`
+	// Media replaces the post's images (by url, see Media)
+	Media []Media `json:"media"`
+	// Syndicate lists the syndication targets to send the post to
+	Syndicate []string `json:"syndicate"`
+	// SkipPropagation leaves copies already syndicated alone on update
+	SkipPropagation bool `json:"skip_propagation"`
+	// Force sends an update to Syndicate targets it was already sent to
+	Force bool `json:"force"`
+}
+
+type apiError struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+func writeAPIJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		logger.Errorf("Could not write json response: %v", err)
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeAPIJSON(w, status, map[string]apiError{
+		"error": apiError{Status: status, Message: message},
+	})
+}
+
+// checkAPIOwner accepts the signin cookie or Basic auth credentials
+func checkAPIOwner(config Config, r *http.Request) bool {
+	if checkIsOwner(config, r) {
+		return true
+	}
+
+	username, password, ok := r.BasicAuth()
+	if !ok || config.Signin.Username == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare(
+		[]byte(hashAccount(username, password)),
+		[]byte(hashAccount(config.Signin.Username, config.Signin.Password))) == 1
+}
+
+func requireAPIOwner(config Config) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !checkAPIOwner(config, r) {
+				w.Header().Set("WWW-Authenticate", `Basic realm="goldfrog"`)
+				writeAPIError(w, http.StatusUnauthorized, "Authentication required")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+/*
+Cursors are opaque to clients: they encode the date and id of the last
+post of a page, and the next page starts after it.
+*/
+func encodeCursor(post *Post) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(
+		"%s|%d", post.PostDate.UTC().Format(time.RFC3339), post.ID)))
+}
+
+func decodeCursor(cursor string) (time.Time, int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	parts := strings.SplitN(string(b), "|", 2)
+	if len(parts) != 2 {
+		return time.Time{}, 0, fmt.Errorf("malformed cursor")
+	}
+	date, err := time.Parse(time.RFC3339, parts[0])
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	return date, id, nil
+}
+
+// apiListOpts reads the list filters from the query string
+func apiListOpts(r *http.Request, tz *time.Location) (GetPostOpts, error) {
+	q := r.URL.Query()
+
+	opts := GetPostOpts{
+		Tag:   q.Get("tag"),
+		Kind:  q.Get("kind"),
+		Limit: apiDefaultLimit,
+	}
+
+	if opts.Kind != "" && !validKind(opts.Kind) {
+		return opts, fmt.Errorf(
+			"kind must be one of %s", strings.Join(PostKinds, ", "))
+	}
+
+	if search := q.Get("q"); search != "" {
+		opts.Title = search
+		opts.Body = search
+	}
+
+	for param, date := range map[string]*time.Time{
+		"since": &opts.Since,
+		"until": &opts.Until,
+	} {
+		value := q.Get(param)
+		if value == "" {
+			continue
+		}
+		t, err := dateparse.ParseIn(value, tz)
+		if err != nil {
+			return opts, fmt.Errorf("Could not parse %s: %q", param, value)
+		}
+		*date = t
+	}
+
+	if limit := q.Get("limit"); limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil || l < 1 {
+			return opts, fmt.Errorf("limit must be a positive number")
+		}
+		if l > apiMaxLimit {
+			l = apiMaxLimit
+		}
+		opts.Limit = l
+	}
+
+	if cursor := q.Get("cursor"); cursor != "" {
+		var err error
+		opts.BeforeDate, opts.BeforeID, err = decodeCursor(cursor)
+		if err != nil {
+			return opts, fmt.Errorf("Invalid cursor")
+		}
+	}
+
+	return opts, nil
+}
+
+func CreateAPIListPostsFunc(config Config, db *sql.DB) http.HandlerFunc {
+	author_tz, _ := time.LoadLocation(config.Blog.Author.TimeZone)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		opts, err := apiListOpts(r, author_tz)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		// fetch one extra post to know whether there is a next page
+		limit := opts.Limit
+		opts.Limit = limit + 1
+		posts := GetPosts(db, opts)
+
+		var nextCursor string
+		if len(posts) > limit {
+			posts = posts[:limit]
+			nextCursor = encodeCursor(posts[limit-1])
+		}
+
+		result := make([]apiPost, 0, len(posts))
+		for _, post := range posts {
+			result = append(result, newAPIPost(config, post))
+		}
+
+		writeAPIJSON(w, http.StatusOK, struct {
+			Posts      []apiPost `json:"posts"`
+			NextCursor string    `json:"next_cursor,omitempty"`
+		}{
+			Posts:      result,
+			NextCursor: nextCursor,
+		})
+	}
+}
+
+// apiGetPost loads the post named by the postID or slug url param
+func apiGetPost(w http.ResponseWriter, r *http.Request, db *sql.DB) (*Post, bool) {
+	var post *Post
+	var err error
+
+	if slug := chi.URLParam(r, "slug"); slug != "" {
+		post, err = GetPostBySlug(db, slug)
+	} else {
+		postID := chi.URLParam(r, "postID")
+		if _, convErr := strconv.Atoi(postID); convErr != nil {
+			writeAPIError(w, http.StatusNotFound, "Post not found")
+			return nil, false
+		}
+		post, err = GetPost(db, postID)
+	}
+
+	if err == sql.ErrNoRows {
+		writeAPIError(w, http.StatusNotFound, "Post not found")
+		return nil, false
+	}
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return nil, false
+	}
+	return post, true
+}
+
+func CreateAPIGetPostFunc(config Config, db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		post, ok := apiGetPost(w, r, db)
+		if !ok {
+			return
+		}
+		writeAPIJSON(w, http.StatusOK, newAPIPost(config, post))
+	}
+}
+
+func CreateAPIArchiveFunc(config Config, db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		archive := GetArchiveYearMonths(db)
+		if archive == nil {
+			archive = []ArchiveEntry{}
+		}
+		writeAPIJSON(w, http.StatusOK, struct {
+			Archive []ArchiveEntry `json:"archive"`
+		}{archive})
+	}
+}
+
+func CreateAPITagsFunc(config Config, db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tags := GetTagCounts(db)
+		if tags == nil {
+			tags = []TagCount{}
+		}
+		writeAPIJSON(w, http.StatusOK, struct {
+			Tags []TagCount `json:"tags"`
+		}{tags})
+	}
+}
+
+func decodeAPIPostInput(r *http.Request, input *apiPostInput) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(input)
+	if err != nil {
+		return fmt.Errorf("Invalid post: %v", err)
+	}
+	return nil
+}
+
+func CreateAPICreatePostFunc(
+	config Config, db *sql.DB, repo PostsRepo) http.HandlerFunc {
+	author_tz, _ := time.LoadLocation(config.Blog.Author.TimeZone)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input apiPostInput
+		err := decodeAPIPostInput(r, &input)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		includeHooks, err := syndicationHooks(config, input.Syndicate)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		body := strings.TrimSpace(strings.Replace(input.Body, "\r\n", "\n", -1))
+		if body == "" {
+			writeAPIError(w, http.StatusBadRequest, "A post needs a body")
+			return
+		}
+
+		slug := makeSlug(input.Title, input.Slug, body)
+		if _, err := GetPostBySlug(db, slug); err == nil {
+			writeAPIError(w, http.StatusConflict, fmt.Sprintf(
+				"A post with slug %q already exists", slug))
+			return
+		}
+
+		post := NewPost(PostOpts{
+			Title:    input.Title,
+			Tags:     input.Tags,
+			Body:     body,
+			Slug:     slug,
+			PostDate: parsePostDate(input.Date, author_tz),
+			Media:    input.Media,
+		})
+		for k, v := range input.FrontMatter {
+			post.FrontMatter[k] = v
+		}
+		post.Tags = updateTags(post.Body, post.Tags)
+
+		newPost, err := storePost(db, repo, &post, true)
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		_, err = syndicatePost(
+			config, db, repo, newPost, includeHooks)
+		if err != nil {
+			logger.Warn(err)
+		}
+
+		pingHub(config, newPost)
+
+		w.Header().Set("Location", fmt.Sprintf(
+			"%s/posts/%d", APIPREFIX, newPost.ID))
+		writeAPIJSON(w, http.StatusCreated, newAPIPost(config, newPost))
+	}
+}
+
+func CreateAPIUpdatePostFunc(
+	config Config, db *sql.DB, repo PostsRepo) http.HandlerFunc {
+	author_tz, _ := time.LoadLocation(config.Blog.Author.TimeZone)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		post, ok := apiGetPost(w, r, db)
+		if !ok {
+			return
+		}
+		oldTags := post.Tags
+
+		input := apiPostInput{
+			Title:       post.Title,
+			Slug:        post.Slug,
+			Date:        post.PostDate.In(author_tz).Format(time.RFC3339),
+			Tags:        post.Tags,
+			Body:        post.Body,
+			FrontMatter: post.FrontMatter,
+			Media:       post.Media,
+		}
+		err := decodeAPIPostInput(r, &input)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		includeHooks, err := syndicationHooks(config, input.Syndicate)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if input.Slug != post.Slug {
+			writeAPIError(w, http.StatusBadRequest, "The slug of a post can't be changed")
+			return
+		}
+
+		post.Title = input.Title
+		post.Tags = input.Tags
+		post.Body = strings.TrimSpace(strings.Replace(input.Body, "\r\n", "\n", -1))
+		post.PostDate = parsePostDate(input.Date, author_tz)
+		if input.FrontMatter != nil {
+			post.FrontMatter = input.FrontMatter
+		}
+		post.Media = input.Media
+
+		post.Tags = updateTags(post.Body, post.Tags)
+
+		updatePost, err := storePost(db, repo, post, false)
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if !input.SkipPropagation {
+			propagateUpdate(config, updatePost)
+		}
+
+		_, err = syndicatePost(
+			config, db, repo, updatePost,
+			unsentHooks(updatePost, includeHooks, input.Force))
+		if err != nil {
+			logger.Warn(err)
+		}
+
+		pingHub(config, updatePost, oldTags...)
+
+		writeAPIJSON(w, http.StatusOK, newAPIPost(config, updatePost))
+	}
+}
+
+func CreateAPIDeletePostFunc(
+	config Config, db *sql.DB, repo PostsRepo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		post, ok := apiGetPost(w, r, db)
+		if !ok {
+			return
+		}
+
+		err := removePost(db, repo, post)
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if r.URL.Query().Get("skip_propagation") != "true" {
+			propagateDelete(config, post)
+		}
+
+		pingHub(config, post)
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,157 @@
+package blog
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func apiTestSetup(t *testing.T) (Config, *sql.DB, http.Handler) {
+	var config Config
+	config.Blog.Url = "http://blog.example"
+	config.Signin.Username = "me"
+	config.Signin.Password = "pw"
+
+	err := initDb(testDb)
+	assert.Nil(t, err)
+	db, err := GetDb(testDb)
+	assert.Nil(t, err)
+
+	return config, db, APIRoutes(config, db, &NullPostsRepo{})
+}
+
+func apiRequest(
+	handler http.Handler, method string, path string, body string,
+	auth bool) *httptest.ResponseRecorder {
+
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if auth {
+		req.SetBasicAuth("me", "pw")
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestAPICreateGetUpdateDelete(t *testing.T) {
+	_, _, handler := apiTestSetup(t)
+	defer os.Remove(testDb)
+
+	postJSON := `{"title": "API Post", "body": "Hello #api", "date": "2020-01-02T03:04:05Z"}`
+
+	rr := apiRequest(handler, "POST", "/posts", postJSON, false)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	var apiErr map[string]apiError
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &apiErr))
+	assert.Equal(t, http.StatusUnauthorized, apiErr["error"].Status)
+
+	rr = apiRequest(handler, "POST", "/posts", postJSON, true)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+
+	var created struct {
+		ID   int      `json:"post_id"`
+		Slug string   `json:"slug"`
+		Kind string   `json:"kind"`
+		URL  string   `json:"url"`
+		Tags []string `json:"tags"`
+		Body string   `json:"body"`
+	}
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &created))
+	assert.Equal(t, "api-post", created.Slug)
+	assert.Equal(t, KINDARTICLE, created.Kind)
+	assert.Equal(t, "http://blog.example/2020/01/02/api-post", created.URL)
+	assert.Contains(t, created.Tags, "api")
+	assert.Equal(t, "/api/v1/posts/1", rr.Header().Get("Location"))
+
+	rr = apiRequest(handler, "POST", "/posts", postJSON, true)
+	assert.Equal(t, http.StatusConflict, rr.Code)
+
+	rr = apiRequest(handler, "GET", "/posts/slug/api-post", "", false)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"title":"API Post"`)
+
+	rr = apiRequest(handler, "PUT", "/posts/1", `{"body": "Changed"}`, true)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &created))
+	assert.Equal(t, "Changed", created.Body)
+	assert.Equal(t, "api-post", created.Slug)
+
+	rr = apiRequest(handler, "PUT", "/posts/1", `{"nope": 1}`, true)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = apiRequest(handler, "DELETE", "/posts/1", "", true)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+
+	rr = apiRequest(handler, "GET", "/posts/1", "", false)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Equal(t, "application/json; charset=utf-8", rr.Header().Get("Content-Type"))
+}
+
+func TestAPIListPostsPagination(t *testing.T) {
+	_, db, handler := apiTestSetup(t)
+	defer os.Remove(testDb)
+
+	for _, opts := range []PostOpts{
+		{Title: "One", Slug: "one", Tags: []string{"go"}},
+		{Title: "Two", Slug: "two", Tags: []string{"go"}},
+		{Slug: "three", Body: "a note", Tags: []string{"go"}},
+		{Title: "Four", Slug: "four"},
+	} {
+		post := NewPost(opts)
+		assert.Nil(t, CreatePost(db, &post))
+	}
+
+	type listResult struct {
+		Posts []struct {
+			Slug string `json:"slug"`
+		} `json:"posts"`
+		NextCursor string `json:"next_cursor"`
+	}
+
+	var slugs []string
+	path := "/posts?tag=go&limit=2"
+	for pages := 0; pages < 5; pages++ {
+		rr := apiRequest(handler, "GET", path, "", false)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var result listResult
+		assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &result))
+		for _, p := range result.Posts {
+			slugs = append(slugs, p.Slug)
+		}
+		if result.NextCursor == "" {
+			break
+		}
+		path = "/posts?tag=go&limit=2&cursor=" + result.NextCursor
+	}
+	assert.ElementsMatch(t, []string{"one", "two", "three"}, slugs)
+
+	rr := apiRequest(handler, "GET", "/posts?kind=note", "", false)
+	var result listResult
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &result))
+	assert.Equal(t, 1, len(result.Posts))
+	assert.Equal(t, "three", result.Posts[0].Slug)
+
+	rr = apiRequest(handler, "GET", "/posts?kind=bogus", "", false)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = apiRequest(handler, "GET", "/posts?cursor=bogus", "", false)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = apiRequest(handler, "GET", "/tags", "", false)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `{"tag":"go","count":3}`)
+
+	rr = apiRequest(handler, "GET", "/archive", "", false)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"count_posts":3`)
+}
@@ -3,16 +3,11 @@ package blog
 import (
 	"database/sql"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"net/http"
 	"net/url"
-	"os"
-	"path/filepath"
 	"strings"
 	"time"
 
-	"github.com/araddon/dateparse"
 	"github.com/go-chi/chi"
 	"github.com/sivy/goldfrog/pkg/syndication"
 )
@@ -40,6 +35,9 @@ func CreateNewPostFunc(
 			slug := r.FormValue("slug")
 			tagStr := r.FormValue("tags")
 			tags := splitTags(tagStr)
+			// so a bookmarklet can start a reply with /new?in-reply-to=...
+			frontMatter := make(map[string]string)
+			kindFrontMatter(r.URL.Query(), frontMatter)
 
 			flash, _ := GetFlash(w, r, "flash")
 
@@ -52,13 +50,16 @@ func CreateNewPostFunc(
 				TextHeight         int
 				ShowSlug           bool
 				Flash              string
+				SyndicationTargets []SyndicationTarget
+				TootOptions        []TootOptions
 			}{
 				Config: config,
 				Post: NewPost(PostOpts{
-					Title: title,
-					Body:  body,
-					Slug:  slug,
-					Tags:  tags,
+					Title:       title,
+					Body:        body,
+					Slug:        slug,
+					Tags:        tags,
+					FrontMatter: frontMatter,
 				}),
 				PostDateInTimeZone: time.Now().In(author_tz),
 				FormAction:         "/new",
@@ -66,56 +67,14 @@ func CreateNewPostFunc(
 				TextHeight:         20,
 				ShowSlug:           true,
 				Flash:              flash,
+				SyndicationTargets: postSyndicationTargets(config, db, nil),
+				TootOptions:        postTootOptions(config, nil),
 			})
 			return
 		}
 
 		r.ParseMultipartForm(32 << 20)
-		file, handler, err := r.FormFile("postimage")
-		var hasImage bool
-		var imageUrl string
-		var imagePath string
-		var mediaBytes []byte
-		var mediaType string
-
-		if err == nil {
-			// there's an image
-			hasImage = true
-			defer file.Close()
-			logger.Infof("File upload in progress...")
-
-			imagePath = filepath.Join(config.UploadsDir, handler.Filename)
-			logger.Debugf("Writing uploaded file: %s", imagePath)
-			f, err := os.OpenFile(
-				imagePath, os.O_WRONLY|os.O_CREATE, 0777)
-
-			if err != nil {
-				logger.Error(err)
-				hasImage = false
-			} else {
-				defer f.Close()
-				io.Copy(f, file)
-				imageUrl = strings.Join([]string{
-					config.Blog.Url, "uploads", handler.Filename}, "/")
-			}
-
-			mediaBytes, err = ioutil.ReadFile(imagePath)
-			if err != nil {
-				logger.Error(err)
-				hasImage = false
-			} else {
-				ext := filepath.Ext(imagePath)
-				logger.Debug(mediaType)
-				mediaType = "tweet_image"
-				if ext == ".gif" {
-					mediaType = "tweet_gif"
-				}
-
-				logger.Debug(mediaType)
-			}
-			// get bytes
-			// file
-		}
+		media := saveUploads(config, db, r)
 
 		title := r.PostFormValue("title")
 		tags := r.PostFormValue("tags")
@@ -123,54 +82,33 @@ func CreateNewPostFunc(
 		slug := r.PostFormValue("slug")
 		date := r.PostFormValue("postdate")
 
-		if title == "" {
-			slug = MakeNoteSlug(body)
-		}
+		frontMatter := make(map[string]string)
+		kindFrontMatter(r.PostForm, frontMatter)
 
-		if slug == "" {
-			slug = MakePostSlug(title)
-		}
+		// likes and reposts needn't say anything, their slugs come from the target
+		slug = makeSlug(title, slug, body+kindTarget(frontMatter))
 
 		body = strings.Replace(body, "\r\n", "\n", -1)
-
-		if hasImage {
-			body = strings.Replace(
-				body, "[image]",
-				fmt.Sprintf("![%s](%s)", imageUrl, imageUrl),
-				-1)
-		}
-
-		var postDate time.Time
-		if date != "" {
-			// the date is provided in the author's timezone
-			postDate, err = dateparse.ParseIn(date, author_tz)
-			if err != nil {
-				logger.Warnf("Could not parse date: %s", date)
-				date = ""
-			}
-			// then convert to UTC for storage
-			postDate = postDate.UTC()
-		}
-		if date == "" {
-			// server is already in UTC
-			postDate = time.Now()
-		}
+		body = placeImages(body, media)
 
 		post := NewPost(PostOpts{
-			Title:    title,
-			Tags:     splitTags(tags),
-			Body:     body,
-			Slug:     slug,
-			PostDate: postDate,
+			Title:       title,
+			Tags:        splitTags(tags),
+			Body:        body,
+			Slug:        slug,
+			PostDate:    parsePostDate(date, author_tz),
+			Media:       media,
+			FrontMatter: frontMatter,
 		})
 
+		tootFormOptions(config, r, post.FrontMatter)
+
 		logger.Debug(post)
 		post.Tags = updateTags(post.Body, post.Tags)
 
-		err = repo.SavePostFile(&post)
+		updatePost, err := storePost(db, repo, &post, true)
 		if err != nil {
-			logger.Errorf("Could not save post: %v", err)
-			SetFlash(w, "flash", fmt.Sprintf("Could not save post: %v", err))
+			SetFlash(w, "flash", err.Error())
 
 			values := url.Values{
 				"title": []string{title},
@@ -178,23 +116,8 @@ func CreateNewPostFunc(
 				"body":  []string{body},
 				"tags":  []string{tags},
 			}
-			http.Redirect(
-				w, r,
-				fmt.Sprintf("/new?%s", values.Encode()),
-				http.StatusSeeOther)
-			// redirect(w, config.TemplatesDir, "/")
-			return
-		}
-
-		err = CreatePost(db, &post)
-		if err != nil {
-			logger.Errorf("Could not create post file: %v", err)
-			SetFlash(w, "flash", fmt.Sprintf("Could not save post file: %v", err))
-			values := url.Values{
-				"title": []string{title},
-				"slug":  []string{slug},
-				"body":  []string{body},
-				"tags":  []string{tags},
+			for k, v := range frontMatter {
+				values.Set(k, v)
 			}
 			http.Redirect(
 				w, r,
@@ -204,69 +127,17 @@ func CreateNewPostFunc(
 			return
 		}
 
-		updatePost, err := GetPostBySlug(db, post.Slug)
-
-		if err != nil {
-			logger.Errorf("Post saved but syndication process could not run: %v", err)
-			SetFlash(w, "flash", fmt.Sprintf("Post saved but syndication process could not run: %v", err))
-			http.Redirect(w, r, "/", http.StatusFound)
-		}
-
-		includeHooks := make(map[string]bool)
-		synOpts := syndication.SyndicateConfig{}
-
-		if r.PostFormValue("twitter") == "on" {
-			includeHooks["twitter"] = true
-			synOpts.Twitter = config.Twitter
-		}
-
-		if r.PostFormValue("mastodon") == "on" {
-			includeHooks["mastodon"] = true
-			synOpts.Mastodon = config.Mastodon
-		}
-
-		if config.WebMentionEnabled {
-			includeHooks["webmention"] = true
-			synOpts.WebMention = syndication.WebmentionOpts{}
-		}
-
-		// don't depend on updating a reference to a Post
-		postData := syndication.PostData{
-			Title:       updatePost.Title,
-			Slug:        updatePost.Slug,
-			PostDate:    updatePost.PostDate,
-			Tags:        updatePost.Tags,
-			Body:        updatePost.Body,
-			FrontMatter: updatePost.FrontMatter,
-			PermaLink:   config.Blog.Url + updatePost.PermaLink(),
-		}
-		if len(mediaBytes) > 0 {
-			postData.MediaContent = mediaBytes
-			postData.MediaType = mediaType
-		}
-		syndicationMeta := syndication.Syndicate(synOpts, includeHooks, postData)
-
-		logger.Debugf("new meta after hooks: %v", syndicationMeta)
-
-		for k, v := range syndicationMeta {
-			updatePost.FrontMatter[k] = v
-		}
-
-		err = SavePost(db, updatePost)
+		results, err := syndicatePost(
+			config, db, repo, updatePost,
+			syndicationFormHooks(config, r))
+		broken := brokenLinksSummary(brokenWikiLinks(db, updatePost.Body))
 		if err != nil {
-			logger.Error(err)
-			SetFlash(w, "flash", fmt.Sprintf(
-				"Your post was saved, but some syndication links might be missing (%v)",
-				err))
+			SetFlash(w, "flash", joinFlash(err.Error(), broken))
+		} else if summary := joinFlash(syndicationSummary(results), broken); summary != "" {
+			SetFlash(w, "flash", summary)
 		}
 
-		err = repo.SavePostFile(updatePost)
-		if err != nil {
-			logger.Error(err)
-			SetFlash(w, "flash", fmt.Sprintf(
-				"Your post was saved, but some syndication links might be missing on disk (%v)",
-				err))
-		}
+		pingHub(config, updatePost)
 
 		http.Redirect(w, r, "/", http.StatusFound)
 		// redirect(w, config.TemplatesDir, "/")
@@ -314,6 +185,9 @@ func CreateEditPostFunc(
 					ShowSlug           bool
 					ShowExpand         bool
 					Flash              string
+					BrokenLinks        []string
+					SyndicationTargets []SyndicationTarget
+					TootOptions        []TootOptions
 				}{
 					Config:             config,
 					Post:               post,
@@ -324,6 +198,8 @@ func CreateEditPostFunc(
 					ShowSlug:           true,
 					ShowExpand:         false,
 					Flash:              flash,
+					SyndicationTargets: postSyndicationTargets(config, db, post),
+					TootOptions:        postTootOptions(config, post),
 				})
 			}
 			logger.Debugf("Found post %s", post.Title)
@@ -347,6 +223,9 @@ func CreateEditPostFunc(
 				ShowSlug           bool
 				ShowExpand         bool
 				Flash              string
+				BrokenLinks        []string
+				SyndicationTargets []SyndicationTarget
+				TootOptions        []TootOptions
 			}{
 				Config:             config,
 				Post:               post,
@@ -357,6 +236,9 @@ func CreateEditPostFunc(
 				ShowSlug:           true,
 				ShowExpand:         false,
 				Flash:              flash,
+				BrokenLinks:        brokenWikiLinks(db, post.Body),
+				SyndicationTargets: postSyndicationTargets(config, db, post),
+				TootOptions:        postTootOptions(config, post),
 			})
 			return
 		}
@@ -367,36 +249,10 @@ func CreateEditPostFunc(
 		if err != nil {
 			logger.Error(err)
 		}
+		oldTags := post.Tags
 
 		r.ParseMultipartForm(32 << 20)
-		file, handler, err := r.FormFile("postimage")
-		var hasImage bool
-		var imageUrl string
-		var imagePath string
-
-		if err == nil {
-			// there's an image
-			hasImage = true
-			defer file.Close()
-			logger.Infof("File upload in progress...")
-
-			imagePath = filepath.Join(config.UploadsDir, handler.Filename)
-			logger.Debugf("Writing uploaded file: %s", imagePath)
-
-			f, err := os.OpenFile(
-				imagePath, os.O_WRONLY|os.O_CREATE, 0777)
-			if err != nil {
-				logger.Error(err)
-				hasImage = false
-			} else {
-				defer f.Close()
-				io.Copy(f, file)
-				imageUrl = strings.Join([]string{
-					config.Blog.Url, "uploads", handler.Filename}, "/")
-			}
-		} else {
-			logger.Error(err)
-		}
+		media := saveUploads(config, db, r)
 
 		title := r.PostFormValue("title")
 		tags := r.PostFormValue("tags")
@@ -408,116 +264,53 @@ func CreateEditPostFunc(
 		frontMatterYaml := GetFrontMatter(frontMatterString)
 
 		body = strings.Replace(body, "\r\n", "\n", -1)
-
-		if hasImage {
-			body = strings.Replace(
-				body, "[image]",
-				fmt.Sprintf("![%s](%s)", imageUrl, imageUrl),
-				-1)
-		}
+		body = placeImages(body, media)
 
 		post.Title = title
 		post.Tags = splitTags(tags)
 		post.Body = strings.TrimSpace(body)
 		post.FrontMatter = frontMatterYaml
+		// the media list can be edited with the rest of the front matter
+		if metaMedia, ok := frontMatterMedia(frontMatterString); ok {
+			post.Media = metaMedia
+		}
+		post.Media = append(post.Media, media...)
+		tootFormOptions(config, r, post.FrontMatter)
 
-		var postDate time.Time
 		logger.Infof("Edit post posted date: %v", date)
-		if date != "" {
-			// the date is provided in the author's timezone
-			postDate, err = dateparse.ParseIn(date, author_tz)
-			logger.Infof("postDate: %v", postDate)
-			if err != nil {
-				logger.Warnf("Could not parse date: %s", date)
-				date = ""
-			}
-			// then convert to UTC for storage
-			postDate = postDate.UTC()
-			logger.Infof("postDate UTC: %v", postDate)
-		}
-		if date == "" {
-			// server is already in UTC
-			postDate = time.Now()
-		}
-		post.PostDate = postDate
+		post.PostDate = parsePostDate(date, author_tz)
 
-		processedBody := fmt.Sprintf("%s", markDowner(post.Body))
-		post.Tags = updateTags(processedBody, post.Tags)
+		post.Tags = updateTags(post.Body, post.Tags)
 
 		logger.Debug(post)
 
-		err = repo.SavePostFile(post)
+		updatePost, err := storePost(db, repo, post, false)
 		if err != nil {
-			logger.Errorf("Could not save post file: %v", err)
-		}
-
-		err = SavePost(db, post)
-		if err != nil {
-			logger.Errorf("Could not save post: %v", err)
-		}
-
-		updatePost, err := GetPostBySlug(db, post.Slug)
-
-		if err != nil {
-			logger.Errorf("Post saved but syndication process could not run: %v", err)
-			SetFlash(w, "flash", fmt.Sprintf("Post saved but syndication process could not run: %v", err))
-			http.Redirect(w, r, "/", http.StatusFound)
-		}
-
-		includeHooks := make(map[string]bool)
-		synOpts := syndication.SyndicateConfig{}
-
-		if r.PostFormValue("twitter") == "on" {
-			includeHooks["twitter"] = true
-			synOpts.Twitter = config.Twitter
-		}
-
-		if r.PostFormValue("mastodon") == "on" {
-			includeHooks["mastodon"] = true
-			synOpts.Mastodon = config.Mastodon
-		}
-
-		if config.WebMentionEnabled {
-			includeHooks["webmention"] = true
-			synOpts.WebMention = syndication.WebmentionOpts{}
-		}
-
-		// don't depend on updating a reference to a Post
-		postData := syndication.PostData{
-			Title:       updatePost.Title,
-			Slug:        updatePost.Slug,
-			Tags:        updatePost.Tags,
-			Body:        updatePost.Body,
-			FrontMatter: updatePost.FrontMatter,
-			PermaLink:   config.Blog.Url + updatePost.PermaLink(),
-		}
-		if !postDate.IsZero() {
-			postData.PostDate = postDate
+			SetFlash(w, "flash", err.Error())
+			http.Redirect(w, r, "/edit/"+postID, http.StatusSeeOther)
+			return
 		}
 
-		syndicationMeta := syndication.Syndicate(synOpts, includeHooks, postData)
-
-		logger.Debugf("new meta after hooks: %v", syndicationMeta)
-
-		for k, v := range syndicationMeta {
-			updatePost.FrontMatter[k] = v
+		// edit the copies already syndicated, unless the owner opted out
+		var results []syndication.Result
+		if r.PostFormValue("skip_propagation") != "on" {
+			results = propagateUpdate(config, updatePost)
 		}
 
-		err = SavePost(db, updatePost)
+		// targets it's already on were just edited, unless forced
+		sent, err := syndicatePost(
+			config, db, repo, updatePost,
+			unsentHooks(updatePost, syndicationFormHooks(config, r),
+				r.PostFormValue("force") == "on"))
+		results = append(results, sent...)
+		broken := brokenLinksSummary(brokenWikiLinks(db, updatePost.Body))
 		if err != nil {
-			logger.Error(err)
-			SetFlash(w, "flash", fmt.Sprintf(
-				"Your post was saved, but some syndication links might be missing (%v)",
-				err))
+			SetFlash(w, "flash", joinFlash(err.Error(), broken))
+		} else if summary := joinFlash(syndicationSummary(results), broken); summary != "" {
+			SetFlash(w, "flash", summary)
 		}
 
-		err = repo.SavePostFile(updatePost)
-		if err != nil {
-			logger.Error(err)
-			SetFlash(w, "flash", fmt.Sprintf(
-				"Your post was saved, but some syndication links might be missing on disk (%v)",
-				err))
-		}
+		pingHub(config, updatePost, oldTags...)
 
 		// redirect(w, config.TemplatesDir, post.PermaLink())
 		http.Redirect(w, r, post.PermaLink(), http.StatusFound)
@@ -541,22 +334,136 @@ func CreateDeletePostFunc(
 			logger.Errorf("Could not find post to delete: %v", err)
 			SetFlash(w, "flash", fmt.Sprintf("Could not find post to delete: %v", err))
 			http.Redirect(w, r, "/edit/"+postID, http.StatusSeeOther)
+
+			return
 		}
 		logger.Debugf("post: %s date: %s", post.Title, post.PostDate.Format(POSTTIMESTAMPFMT))
 
-		err = DeletePost(db, postID)
+		err = removePost(db, repo, post)
 		if err != nil {
-			logger.Errorf("Could not delete post: %v", err)
-			SetFlash(w, "flash", fmt.Sprintf("Could not delete post: %v", err))
+			SetFlash(w, "flash", err.Error())
 			http.Redirect(w, r, "/edit/"+postID, http.StatusSeeOther)
+			return
 		}
 
-		err = repo.DeletePostFile(post)
-		if err != nil {
-			logger.Errorf("Could not delete post file: %v", err)
+		if r.PostFormValue("skip_propagation") != "on" {
+			results := propagateDelete(config, post)
+			if summary := syndicationSummary(results); summary != "" {
+				SetFlash(w, "flash", summary)
+			}
 		}
 
+		pingHub(config, post)
+
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		// redirect(w, config.TemplatesDir, "/")
 	}
 }
+
+/*
+CreateSyndicatePostFunc handles the (re)send buttons of the edit page:
+it sends a post to a single target right away, queueing a retry if
+that fails. Targets the post was already sent to are skipped unless
+`force` is on.
+*/
+func CreateSyndicatePostFunc(
+	config Config, db *sql.DB, repo PostsRepo) http.HandlerFunc {
+	logger.Debug("Creating syndicate post handler")
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || !checkIsOwner(config, r) {
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+
+		postID := r.PostFormValue("postID")
+		target := r.PostFormValue("target")
+		force := r.PostFormValue("force") == "on"
+
+		post, err := GetPost(db, postID)
+		if err != nil {
+			logger.Errorf("Could not find post to syndicate: %v", err)
+			SetFlash(w, "flash", fmt.Sprintf("Could not find post to syndicate: %v", err))
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+
+		result, skipped, err := resyndicatePost(config, db, repo, post, target, force)
+		switch {
+		case skipped:
+			SetFlash(w, "flash", fmt.Sprintf(
+				"Already sent to %s, check \"force\" to send it again", target))
+		case err != nil && result.Target == "":
+			SetFlash(w, "flash", err.Error())
+		default:
+			if result.Status == syndication.StatusFailed {
+				qErr := queueSyndicationRetry(db, post.ID, target, force, result.Error)
+				if qErr != nil {
+					logger.Errorf("Could not queue retry for %s: %v", target, qErr)
+				}
+			}
+			summary := syndicationSummary([]syndication.Result{result})
+			if err != nil {
+				summary += fmt.Sprintf(" (%v)", err)
+			}
+			SetFlash(w, "flash", summary)
+		}
+
+		http.Redirect(w, r, "/edit/"+postID, http.StatusSeeOther)
+	}
+}
+
+/*
+CreateSyndicationPreviewFunc shows the owner what each syndication
+target would receive for the post being written: it takes the fields
+of the post forms (and postID, when editing) and returns the messages
+as JSON, without saving anything.
+*/
+func CreateSyndicationPreviewFunc(config Config, db *sql.DB) http.HandlerFunc {
+	logger.Debug("Creating syndication preview handler")
+
+	author_tz, _ := time.LoadLocation(config.Blog.Author.TimeZone)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			writeAPIError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		if !checkIsOwner(config, r) {
+			writeAPIError(w, http.StatusForbidden, "Forbidden")
+			return
+		}
+
+		title := r.PostFormValue("title")
+		body := strings.TrimSpace(
+			strings.Replace(r.PostFormValue("body"), "\r\n", "\n", -1))
+
+		post := NewPost(PostOpts{
+			Title:    title,
+			Tags:     splitTags(r.PostFormValue("tags")),
+			Body:     body,
+			Slug:     makeSlug(title, r.PostFormValue("slug"), body),
+			PostDate: parsePostDate(r.PostFormValue("postdate"), author_tz),
+		})
+		if postID := r.PostFormValue("postID"); postID != "" {
+			// an edit keeps its slug and date, and so its permalink
+			stored, err := GetPost(db, postID)
+			if err != nil {
+				writeAPIError(w, http.StatusNotFound, "Post not found")
+				return
+			}
+			post.Slug = stored.Slug
+			post.PostDate = stored.PostDate
+		}
+		post.Tags = updateTags(post.Body, post.Tags)
+
+		previews := syndication.PreviewPost(
+			config.SyndicationTargets(), makePostData(config, &post))
+		if previews == nil {
+			previews = make([]syndication.Preview, 0)
+		}
+		writeAPIJSON(w, http.StatusOK, map[string]interface{}{
+			"previews": previews,
+		})
+	}
+}
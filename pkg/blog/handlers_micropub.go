@@ -0,0 +1,277 @@
+package blog
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	MICROPUBPATH string = "/micropub"
+)
+
+/*
+A minimal Micropub (https://www.w3.org/TR/micropub/) endpoint: config
+and syndicate-to queries, and creating h-entry posts from form or JSON
+requests. Clients authenticate with the configured micropub token (or
+as the owner, like the API).
+*/
+func CreateMicropubFunc(
+	config Config, db *sql.DB, repo PostsRepo) http.HandlerFunc {
+	logger.Debug("Creating micropub handler")
+
+	author_tz, _ := time.LoadLocation(config.Blog.Author.TimeZone)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !checkMicropubAuth(config, r) {
+			writeMicropubError(w, http.StatusUnauthorized, "unauthorized",
+				"A valid access token is required")
+			return
+		}
+
+		switch r.Method {
+		case "GET":
+			handleMicropubQuery(config, w, r)
+		case "POST":
+			handleMicropubCreate(config, db, repo, author_tz, w, r)
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			writeMicropubError(w, http.StatusMethodNotAllowed, "invalid_request",
+				"Micropub accepts GET and POST")
+		}
+	}
+}
+
+func checkMicropubAuth(config Config, r *http.Request) bool {
+	if checkAPIOwner(config, r) {
+		return true
+	}
+	if config.Micropub.Token == "" {
+		return false
+	}
+
+	var token string
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	} else {
+		token = r.FormValue("access_token")
+	}
+	return subtle.ConstantTimeCompare(
+		[]byte(token), []byte(config.Micropub.Token)) == 1
+}
+
+// micropub errors have their own format
+func writeMicropubError(w http.ResponseWriter, status int, code string, description string) {
+	writeAPIJSON(w, status, map[string]string{
+		"error":             code,
+		"error_description": description,
+	})
+}
+
+type micropubTarget struct {
+	UID  string `json:"uid"`
+	Name string `json:"name"`
+}
+
+func handleMicropubQuery(config Config, w http.ResponseWriter, r *http.Request) {
+	targets := make([]micropubTarget, 0)
+	for _, target := range postSyndicationTargets(config, nil, nil) {
+		targets = append(targets, micropubTarget{
+			UID:  target.Name,
+			Name: target.Label,
+		})
+	}
+
+	switch q := r.URL.Query().Get("q"); q {
+	case "config", "syndicate-to":
+		writeAPIJSON(w, http.StatusOK, map[string]interface{}{
+			"syndicate-to": targets,
+		})
+	default:
+		writeMicropubError(w, http.StatusBadRequest, "invalid_request",
+			fmt.Sprintf("Unsupported query: %q", q))
+	}
+}
+
+/*
+micropubEntry is the part of an h-entry goldfrog understands, read
+from either a form or a JSON request.
+*/
+type micropubEntry struct {
+	Type        string
+	Name        string
+	Content     string
+	Categories  []string
+	Published   string
+	Slug        string
+	SyndicateTo []string
+	Photos      []Media
+	// Responses has the kind properties, like in-reply-to
+	Responses url.Values
+}
+
+func readMicropubEntry(r *http.Request) (micropubEntry, error) {
+	var entry micropubEntry
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var body struct {
+			Type        []string                 `json:"type"`
+			Action      string                   `json:"action"`
+			Properties  map[string][]interface{} `json:"properties"`
+			SyndicateTo []string                 `json:"mp-syndicate-to"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			return entry, fmt.Errorf("Invalid JSON: %v", err)
+		}
+		if body.Action != "" && body.Action != "create" {
+			return entry, fmt.Errorf("Unsupported action: %q", body.Action)
+		}
+		if len(body.Type) > 0 {
+			entry.Type = strings.TrimPrefix(body.Type[0], "h-")
+		}
+
+		first := func(name string) string {
+			values := body.Properties[name]
+			if len(values) == 0 {
+				return ""
+			}
+			switch v := values[0].(type) {
+			case string:
+				return v
+			case map[string]interface{}:
+				// content may come as {"html": "..."}
+				if html, ok := v["html"].(string); ok {
+					return html
+				}
+			}
+			return ""
+		}
+		all := func(name string) []string {
+			var values []string
+			for _, v := range body.Properties[name] {
+				if s, ok := v.(string); ok {
+					values = append(values, s)
+				}
+			}
+			return values
+		}
+
+		entry.Name = first("name")
+		entry.Content = first("content")
+		entry.Published = first("published")
+		entry.Slug = first("mp-slug")
+		entry.Categories = all("category")
+		entry.Responses = url.Values{}
+		for _, kp := range kindProperties {
+			if target := first(kp.Property); target != "" {
+				entry.Responses.Set(kp.Property, target)
+			}
+		}
+		for _, v := range body.Properties["photo"] {
+			// photos are urls, or {"value": url, "alt": text}
+			switch photo := v.(type) {
+			case string:
+				entry.Photos = append(entry.Photos, Media{URL: photo})
+			case map[string]interface{}:
+				url, _ := photo["value"].(string)
+				alt, _ := photo["alt"].(string)
+				if url != "" {
+					entry.Photos = append(entry.Photos, Media{URL: url, Alt: alt})
+				}
+			}
+		}
+		entry.SyndicateTo = append(body.SyndicateTo, all("mp-syndicate-to")...)
+		return entry, nil
+	}
+
+	r.ParseMultipartForm(32 << 20)
+	if action := r.PostFormValue("action"); action != "" && action != "create" {
+		return entry, fmt.Errorf("Unsupported action: %q", action)
+	}
+	entry.Type = r.PostFormValue("h")
+	entry.Name = r.PostFormValue("name")
+	entry.Content = r.PostFormValue("content")
+	entry.Published = r.PostFormValue("published")
+	entry.Slug = r.PostFormValue("mp-slug")
+	entry.Categories = append(r.PostForm["category"], r.PostForm["category[]"]...)
+	entry.Responses = r.PostForm
+	entry.SyndicateTo = append(
+		r.PostForm["mp-syndicate-to"], r.PostForm["mp-syndicate-to[]"]...)
+	for _, url := range append(r.PostForm["photo"], r.PostForm["photo[]"]...) {
+		entry.Photos = append(entry.Photos, Media{URL: url})
+	}
+	return entry, nil
+}
+
+func handleMicropubCreate(
+	config Config, db *sql.DB, repo PostsRepo, tz *time.Location,
+	w http.ResponseWriter, r *http.Request) {
+
+	entry, err := readMicropubEntry(r)
+	if err != nil {
+		writeMicropubError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	if entry.Type != "" && entry.Type != "entry" {
+		writeMicropubError(w, http.StatusBadRequest, "invalid_request",
+			fmt.Sprintf("Unsupported type: %q", entry.Type))
+		return
+	}
+
+	frontMatter := make(map[string]string)
+	kindFrontMatter(entry.Responses, frontMatter)
+
+	body := strings.TrimSpace(strings.Replace(entry.Content, "\r\n", "\n", -1))
+	// likes, reposts and bookmarks can do without content
+	if body == "" && kindTarget(frontMatter) == "" {
+		writeMicropubError(w, http.StatusBadRequest, "invalid_request",
+			"content is required")
+		return
+	}
+
+	includeHooks, err := syndicationHooks(config, entry.SyndicateTo)
+	if err != nil {
+		writeMicropubError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	slug := makeSlug(entry.Name, entry.Slug, body+kindTarget(frontMatter))
+	if _, err := GetPostBySlug(db, slug); err == nil {
+		writeMicropubError(w, http.StatusConflict, "invalid_request",
+			fmt.Sprintf("A post with slug %q already exists", slug))
+		return
+	}
+
+	post := NewPost(PostOpts{
+		Title:       entry.Name,
+		Tags:        entry.Categories,
+		Body:        body,
+		Slug:        slug,
+		PostDate:    parsePostDate(entry.Published, tz),
+		Media:       entry.Photos,
+		FrontMatter: frontMatter,
+	})
+	post.Tags = updateTags(post.Body, post.Tags)
+
+	newPost, err := storePost(db, repo, &post, true)
+	if err != nil {
+		writeMicropubError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	_, err = syndicatePost(config, db, repo, newPost, includeHooks)
+	if err != nil {
+		logger.Warn(err)
+	}
+
+	pingHub(config, newPost)
+
+	w.Header().Set("Location", config.Blog.Url+newPost.PermaLink())
+	w.WriteHeader(http.StatusCreated)
+}
@@ -0,0 +1,100 @@
+package blog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/sivy/goldfrog/pkg/syndication"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMicropub(t *testing.T) {
+	var config Config
+	config.Blog.Url = "http://blog.example"
+	config.Micropub.Token = "t0ken"
+	config.Syndication = []syndication.Target{
+		{Name: "mastodon-work", Type: "mastodon", Label: "Work"},
+	}
+
+	err := initDb(testDb)
+	assert.Nil(t, err)
+	defer os.Remove(testDb)
+	db, err := GetDb(testDb)
+	assert.Nil(t, err)
+
+	handler := CreateMicropubFunc(config, db, &NullPostsRepo{})
+
+	req := httptest.NewRequest("GET", "/micropub?q=config", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	req = httptest.NewRequest("GET", "/micropub?q=syndicate-to", nil)
+	req.Header.Set("Authorization", "Bearer t0ken")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `{"uid":"mastodon-work","name":"Work"}`)
+
+	req = httptest.NewRequest("POST", "/micropub", strings.NewReader(url.Values{
+		"h":          {"entry"},
+		"name":       {"Micropub Post"},
+		"content":    {"Hello"},
+		"category[]": {"indieweb"},
+		"published":  {"2020-03-04T05:06:07Z"},
+	}.Encode()))
+	req.Header.Set("Authorization", "Bearer t0ken")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.Equal(t,
+		"http://blog.example/2020/03/04/micropub-post",
+		rr.Header().Get("Location"))
+
+	post, err := GetPostBySlug(db, "micropub-post")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"indieweb"}, post.Tags)
+
+	// the same slug again doesn't overwrite the post
+	req = httptest.NewRequest("POST", "/micropub", strings.NewReader(url.Values{
+		"h":       {"entry"},
+		"name":    {"Micropub Post"},
+		"content": {"Overwritten"},
+	}.Encode()))
+	req.Header.Set("Authorization", "Bearer t0ken")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"error":"invalid_request"`)
+	post, _ = GetPostBySlug(db, "micropub-post")
+	assert.Equal(t, "Hello", post.Body)
+
+	// a like needs no content
+	req = httptest.NewRequest("POST", "/micropub", strings.NewReader(
+		`{"type": ["h-entry"], "properties": {"like-of": ["https://a.example/"]}}`))
+	req.Header.Set("Authorization", "Bearer t0ken")
+	req.Header.Set("Content-Type", "application/json")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	likes := GetPosts(db, GetPostOpts{Kind: KINDLIKE})
+	if assert.Equal(t, 1, len(likes)) {
+		assert.Equal(t, "https://a.example/", likes[0].KindTarget())
+	}
+
+	req = httptest.NewRequest("POST", "/micropub", strings.NewReader(
+		`{"type": ["h-entry"], "properties": {"content": ["Note"]},
+		  "mp-syndicate-to": ["elsewhere"]}`))
+	req.Header.Set("Authorization", "Bearer t0ken")
+	req.Header.Set("Content-Type", "application/json")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"error":"invalid_request"`)
+}
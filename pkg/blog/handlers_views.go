@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"regexp"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi"
@@ -31,6 +32,9 @@ func CreateIndexFunc(config Config, db *sql.DB) http.HandlerFunc {
 
 		postOpts := GetPostOpts{}
 		getPaginationOpts(r, &postOpts)
+		if kind := r.URL.Query().Get("kind"); validKind(kind) {
+			postOpts.Kind = kind
+		}
 
 		isOwner := checkIsOwner(config, r)
 
@@ -52,6 +56,12 @@ func CreateIndexFunc(config Config, db *sql.DB) http.HandlerFunc {
 
 		logger.Debugf("Found %d posts", len(posts))
 
+		validator := postsValidator(
+			config, db, fmt.Sprintf("index?%s", r.URL.RawQuery), posts)
+		if checkNotModified(w, r, config, validator, isOwner) {
+			return
+		}
+
 		t, err := getTemplate(config.TemplatesDir, "index.html")
 
 		if err != nil {
@@ -74,6 +84,7 @@ func CreateIndexFunc(config Config, db *sql.DB) http.HandlerFunc {
 			ShowSlug   bool
 			ShowExpand bool
 			Flash      string
+			Kind       string
 		}{
 			Posts:      posts,
 			Post:       post,
@@ -84,6 +95,7 @@ func CreateIndexFunc(config Config, db *sql.DB) http.HandlerFunc {
 			TextHeight: 10,
 			ShowExpand: true,
 			Flash:      flash,
+			Kind:       postOpts.Kind,
 		})
 
 		if err != nil {
@@ -92,17 +104,123 @@ func CreateIndexFunc(config Config, db *sql.DB) http.HandlerFunc {
 	}
 }
 
+/*
+feedSlice describes which posts go into a feed. Query builds the post
+options from the request; Title and Link are handed to the feed
+templates so they can describe the slice.
+*/
+type feedSlice struct {
+	Name  string
+	Items int
+	Query func(r *http.Request) (opts GetPostOpts, title string, link string)
+}
+
+func allPostsSlice(config Config) feedSlice {
+	return feedSlice{
+		Name:  "index",
+		Items: config.Feeds.Items,
+		Query: func(r *http.Request) (GetPostOpts, string, string) {
+			return GetPostOpts{}, "", "/"
+		},
+	}
+}
+
+func tagSlice(config Config) feedSlice {
+	return feedSlice{
+		Name:  "tag",
+		Items: config.Feeds.TagItems,
+		Query: func(r *http.Request) (GetPostOpts, string, string) {
+			tag := chi.URLParam(r, "tag")
+			return GetPostOpts{Tag: tag},
+				fmt.Sprintf("Posts tagged with '%s'", tag),
+				fmt.Sprintf("/tag/%s", tag)
+		},
+	}
+}
+
+func authorSlice(config Config) feedSlice {
+	return feedSlice{
+		Name:  "author",
+		Items: config.Feeds.AuthorItems,
+		Query: func(r *http.Request) (GetPostOpts, string, string) {
+			author := chi.URLParam(r, "author")
+			return GetPostOpts{
+					Author: author,
+					DefaultAuthor: strings.EqualFold(
+						author, config.Blog.Author.Name),
+				},
+				fmt.Sprintf("Posts by %s", author),
+				"/"
+		},
+	}
+}
+
+func kindSlice(config Config, kind string) feedSlice {
+	return feedSlice{
+		Name:  kind,
+		Items: config.Feeds.KindItems,
+		Query: func(r *http.Request) (GetPostOpts, string, string) {
+			return GetPostOpts{Kind: kind}, kindTitles[kind], "/?kind=" + kind
+		},
+	}
+}
+
+func feedItems(config Config, slice feedSlice) int {
+	if slice.Items > 0 {
+		return slice.Items
+	}
+	if config.Feeds.Items > 0 {
+		return config.Feeds.Items
+	}
+	return 10
+}
+
+func feedDays(config Config) int {
+	if config.Feeds.DailyDays > 0 {
+		return config.Feeds.DailyDays
+	}
+	return 10
+}
+
 // CreateRssFunc
 func CreateRssFunc(config Config, db *sql.DB) http.HandlerFunc {
 	logger.Debug("Creating rss handler")
+	return createRssFunc(config, db, allPostsSlice(config))
+}
+
+// CreateTagRssFunc serves the posts for the `tag` url param
+func CreateTagRssFunc(config Config, db *sql.DB) http.HandlerFunc {
+	logger.Debug("Creating tag rss handler")
+	return createRssFunc(config, db, tagSlice(config))
+}
+
+// CreateAuthorRssFunc serves the posts for the `author` url param
+func CreateAuthorRssFunc(config Config, db *sql.DB) http.HandlerFunc {
+	logger.Debug("Creating author rss handler")
+	return createRssFunc(config, db, authorSlice(config))
+}
+
+// CreateKindRssFunc serves only the posts of one of PostKinds
+func CreateKindRssFunc(config Config, db *sql.DB, kind string) http.HandlerFunc {
+	logger.Debugf("Creating %s rss handler", kind)
+	return createRssFunc(config, db, kindSlice(config, kind))
+}
+
+func createRssFunc(config Config, db *sql.DB, slice feedSlice) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		logger.Info("Serving index RSS...")
+		logger.Infof("Serving %s RSS...", slice.Name)
 
-		postOpts := GetPostOpts{Limit: 10}
+		postOpts, title, link := slice.Query(r)
+		postOpts.Limit = feedItems(config, slice)
 		posts := GetPosts(db, postOpts)
 
 		logger.Debugf("Found %d posts", len(posts))
 
+		validator := postsValidator(config, db, r.URL.Path, posts)
+		if checkNotModified(w, r, config, validator, false) {
+			return
+		}
+
 		t, err := getTemplate(config.TemplatesDir, "base/rss.xml")
 
 		if err != nil {
@@ -120,10 +238,14 @@ func CreateRssFunc(config Config, db *sql.DB) http.HandlerFunc {
 		err = t.ExecuteTemplate(w, "rss", struct {
 			Posts  []*Post
 			Config Config
+			Title  string
+			Link   string
 			Flash  string
 		}{
 			Posts:  posts,
 			Config: config,
+			Title:  title,
+			Link:   link,
 			Flash:  flash,
 		})
 
@@ -136,10 +258,32 @@ func CreateRssFunc(config Config, db *sql.DB) http.HandlerFunc {
 // CreateDailyRssFunc
 func CreateDailyRssFunc(config Config, db *sql.DB) http.HandlerFunc {
 	logger.Debug("Creating rss handler")
+	return createDailyRssFunc(config, db, allPostsSlice(config))
+}
+
+// CreateTagDailyRssFunc
+func CreateTagDailyRssFunc(config Config, db *sql.DB) http.HandlerFunc {
+	logger.Debug("Creating tag daily rss handler")
+	return createDailyRssFunc(config, db, tagSlice(config))
+}
+
+// CreateAuthorDailyRssFunc
+func CreateAuthorDailyRssFunc(config Config, db *sql.DB) http.HandlerFunc {
+	logger.Debug("Creating author daily rss handler")
+	return createDailyRssFunc(config, db, authorSlice(config))
+}
+
+// CreateKindDailyRssFunc
+func CreateKindDailyRssFunc(config Config, db *sql.DB, kind string) http.HandlerFunc {
+	logger.Debugf("Creating %s daily rss handler", kind)
+	return createDailyRssFunc(config, db, kindSlice(config, kind))
+}
+
+func createDailyRssFunc(config Config, db *sql.DB, slice feedSlice) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		logger.Info("Serving daily RSS...")
+		logger.Infof("Serving %s daily RSS...", slice.Name)
 
-		dates := GetLastNDays(10)
+		dates := GetLastNDays(feedDays(config))
 		logger.Info(dates)
 
 		type DayData struct {
@@ -148,12 +292,20 @@ func CreateDailyRssFunc(config Config, db *sql.DB) http.HandlerFunc {
 		}
 
 		days := make([]DayData, 0)
+		postOpts, title, link := slice.Query(r)
+		postOpts.Limit = -1
+
+		var allPosts []*Post
 
 		for _, d := range dates {
-			posts := GetArchiveDayPosts(
-				db, fmt.Sprintf("%d", d.Year()),
-				fmt.Sprintf("%02d", d.Month()),
-				fmt.Sprintf("%02d", d.Day()))
+			// posts are stored in UTC
+			dayOpts := postOpts
+			dayOpts.Since = time.Date(
+				d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, time.UTC)
+			dayOpts.Until = dayOpts.Since.AddDate(0, 0, 1)
+
+			posts := GetPosts(db, dayOpts)
+			allPosts = append(allPosts, posts...)
 
 			logger.Debugf("Found %d posts", len(posts))
 
@@ -167,6 +319,13 @@ func CreateDailyRssFunc(config Config, db *sql.DB) http.HandlerFunc {
 			})
 		}
 
+		// the days in the feed move on even when no posts change
+		validator := postsValidator(config, db, fmt.Sprintf(
+			"%s %s", r.URL.Path, dates[0].Format(POSTDATEFMT)), allPosts)
+		if checkNotModified(w, r, config, validator, false) {
+			return
+		}
+
 		t, err := getTextTemplate(config.TemplatesDir, "base/rss_daily.xml")
 
 		if err != nil {
@@ -182,9 +341,13 @@ func CreateDailyRssFunc(config Config, db *sql.DB) http.HandlerFunc {
 		err = t.ExecuteTemplate(w, "rss_daily", struct {
 			Days   []DayData
 			Config Config
+			Title  string
+			Link   string
 		}{
 			Days:   days,
 			Config: config,
+			Title:  title,
+			Link:   link,
 		})
 
 		if err != nil {
@@ -223,6 +386,18 @@ func CreatePostPageFunc(config Config, db *sql.DB) http.HandlerFunc {
 			return
 		}
 
+		backlinks := GetBacklinks(db, post.Slug)
+		series := getSeriesNav(db, post)
+
+		related := append([]*Post{post}, backlinks...)
+		if series != nil {
+			related = append(related, series.Posts...)
+		}
+		validator := postsValidator(config, db, r.URL.Path, related)
+		if checkNotModified(w, r, config, validator, isOwner) {
+			return
+		}
+
 		t, err := getTemplate(config.TemplatesDir, "post_detail.html")
 
 		if err != nil {
@@ -236,15 +411,19 @@ func CreatePostPageFunc(config Config, db *sql.DB) http.HandlerFunc {
 		flash, _ := GetFlash(w, r, "flash")
 
 		err = t.ExecuteTemplate(w, "base", struct {
-			Post    *Post
-			Config  Config
-			IsOwner bool
-			Flash   string
+			Post      *Post
+			Backlinks []*Post
+			Series    *SeriesNav
+			Config    Config
+			IsOwner   bool
+			Flash     string
 		}{
-			Post:    post,
-			Config:  config,
-			IsOwner: isOwner,
-			Flash:   flash,
+			Post:      post,
+			Backlinks: backlinks,
+			Series:    series,
+			Config:    config,
+			IsOwner:   isOwner,
+			Flash:     flash,
 		})
 
 		if err != nil {
@@ -316,6 +495,11 @@ func CreateDailyPostsFunc(config Config, db *sql.DB) http.HandlerFunc {
 		}
 		logger.Debugf("Found %d posts", len(posts))
 
+		validator := postsValidator(config, db, r.URL.Path, posts)
+		if checkNotModified(w, r, config, validator, isOwner) {
+			return
+		}
+
 		t, err := getTemplate(config.TemplatesDir, "dailydigest.html")
 
 		if err != nil {
@@ -405,6 +589,12 @@ func CreateArchivePageFunc(config Config, db *sql.DB) http.HandlerFunc {
 		for _, p := range posts {
 			p.User = user
 		}
+
+		validator := postsValidator(config, db, r.URL.Path, posts)
+		if checkNotModified(w, r, config, validator, isOwner) {
+			return
+		}
+
 		t, err := getTemplate(config.TemplatesDir, "archive_posts.html")
 
 		if err != nil {
@@ -538,6 +728,11 @@ func CreateTagPageFunc(config Config, db *sql.DB) http.HandlerFunc {
 			p.User = user
 		}
 
+		validator := postsValidator(config, db, r.URL.Path, posts)
+		if checkNotModified(w, r, config, validator, isOwner) {
+			return
+		}
+
 		t, err := getTemplate(config.TemplatesDir, "post_list.html")
 
 		if err != nil {
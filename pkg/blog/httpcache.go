@@ -0,0 +1,125 @@
+package blog
+
+import (
+	"crypto/sha1"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultCacheMaxAge int = 60
+)
+
+/*
+cacheValidator holds the values a conditional GET is checked
+against: an ETag built from the revisions of the posts on a page
+and the time of the last change to the blog's posts.
+*/
+type cacheValidator struct {
+	ETag         string
+	LastModified time.Time
+}
+
+/*
+postsValidator builds the validator for a page showing posts. The
+variant distinguishes different renderings of the same posts (the
+page url, whether the owner is looking, ...).
+*/
+func postsValidator(config Config, db *sql.DB, variant string, posts []*Post) cacheValidator {
+	var v cacheValidator
+
+	h := sha1.New()
+	fmt.Fprintf(h, "%s\n%s\n", config.Version, variant)
+
+	for _, p := range posts {
+		if p == nil {
+			continue
+		}
+		fmt.Fprintf(h, "%d:%s\n", p.ID, p.Revision())
+	}
+
+	v.ETag = fmt.Sprintf(`W/"%x"`, h.Sum(nil))
+	if db != nil {
+		v.LastModified = lastChange(db).UTC().Truncate(time.Second)
+	}
+	return v
+}
+
+/*
+checkNotModified sets the caching headers for a response and, if the
+request's If-None-Match or If-Modified-Since headers show the client
+already has this version, writes a 304 and returns true. Handlers
+should stop without rendering when it does.
+*/
+func checkNotModified(
+	w http.ResponseWriter, r *http.Request, config Config,
+	v cacheValidator, isOwner bool) bool {
+
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return false
+	}
+
+	// a pending flash message is only shown once, never from a cache
+	if _, err := r.Cookie("flash"); err == nil {
+		w.Header().Set("Cache-Control", "no-cache")
+		return false
+	}
+
+	maxAge := config.Cache.MaxAge
+	if maxAge <= 0 {
+		maxAge = defaultCacheMaxAge
+	}
+
+	// the owner sees edit controls, so the owner's copy is a
+	// different representation of the page
+	etag := v.ETag
+	if isOwner {
+		etag = strings.TrimSuffix(etag, `"`) + `-owner"`
+	}
+
+	w.Header().Set("ETag", etag)
+	if !v.LastModified.IsZero() {
+		w.Header().Set("Last-Modified", v.LastModified.Format(http.TimeFormat))
+	}
+	w.Header().Add("Vary", "Cookie")
+	if isOwner {
+		w.Header().Set("Cache-Control", "private, no-cache")
+	} else {
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", maxAge))
+	}
+
+	notModified := false
+
+	// If-None-Match wins over If-Modified-Since (RFC 7232 section 6)
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		notModified = etagMatches(inm, etag)
+	} else if ims := r.Header.Get("If-Modified-Since"); ims != "" && !isOwner && !v.LastModified.IsZero() {
+		since, err := http.ParseTime(ims)
+		if err == nil && !v.LastModified.After(since) {
+			notModified = true
+		}
+	}
+
+	if notModified {
+		logger.Debugf("Not modified: %s", r.URL.Path)
+		w.WriteHeader(http.StatusNotModified)
+	}
+	return notModified
+}
+
+// etagMatches does the weak comparison of an If-None-Match header
+func etagMatches(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
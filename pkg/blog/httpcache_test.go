@@ -0,0 +1,113 @@
+package blog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPostsValidator(t *testing.T) {
+	date := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+	p1 := NewPost(PostOpts{Title: "one", Slug: "one", PostDate: date})
+	p2 := NewPost(PostOpts{Title: "two", Slug: "two", PostDate: date.AddDate(0, 0, -1)})
+
+	v := postsValidator(CONFIG, nil, "/", []*Post{&p1, &p2})
+	assert.NotEmpty(t, v.ETag)
+
+	// the same posts give the same etag
+	v2 := postsValidator(CONFIG, nil, "/", []*Post{&p1, &p2})
+	assert.Equal(t, v.ETag, v2.ETag)
+
+	// an edit gives a new one
+	p2.Body = "edited"
+	v3 := postsValidator(CONFIG, nil, "/", []*Post{&p1, &p2})
+	assert.NotEqual(t, v.ETag, v3.ETag)
+
+	v4 := postsValidator(CONFIG, nil, "/feed.xml", []*Post{&p1, &p2})
+	assert.NotEqual(t, v3.ETag, v4.ETag)
+}
+
+func TestLastChange(t *testing.T) {
+	// its own db, other tests' background writes would move it on
+	dbFile := "../../tests/data/httpcache_test.db"
+	assert.Nil(t, initDb(dbFile))
+	defer os.Remove(dbFile)
+	db, _ := GetDb(dbFile)
+
+	assert.True(t, lastChange(db).IsZero())
+
+	// an old post is new to the blog
+	p := NewPost(PostOpts{Title: "old", Slug: "old",
+		PostDate: time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC)})
+	assert.Nil(t, CreatePost(db, &p))
+	created := lastChange(db)
+	assert.WithinDuration(t, time.Now(), created, 2*time.Second)
+
+	setUpdated := func(when time.Time) {
+		_, err := db.Exec(`UPDATE posts SET updated = ?`, when.Format(time.RFC3339))
+		assert.Nil(t, err)
+	}
+
+	// edits and deletions move it on
+	setUpdated(created.Add(-time.Hour))
+	post, _ := GetPostBySlug(db, "old")
+	assert.Nil(t, SavePost(db, post))
+	assert.True(t, lastChange(db).After(created.Add(-time.Hour)))
+
+	setUpdated(created.Add(-time.Hour))
+	assert.Nil(t, DeletePost(db, "1"))
+	assert.WithinDuration(t, time.Now(), lastChange(db), 2*time.Second)
+
+	v := postsValidator(CONFIG, db, "/", nil)
+	assert.Equal(t, lastChange(db).UTC(), v.LastModified)
+}
+
+func TestCheckNotModified(t *testing.T) {
+	date := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+	p := NewPost(PostOpts{Title: "one", Slug: "one", PostDate: date})
+	v := postsValidator(CONFIG, nil, "/", []*Post{&p})
+	v.LastModified = date
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	rr := httptest.NewRecorder()
+	assert.False(t, checkNotModified(rr, req, CONFIG, v, false))
+	assert.Equal(t, v.ETag, rr.Header().Get("ETag"))
+	assert.Equal(t, date.Format(http.TimeFormat), rr.Header().Get("Last-Modified"))
+	assert.Contains(t, rr.Header().Get("Cache-Control"), "public")
+
+	req.Header.Set("If-None-Match", v.ETag)
+	rr = httptest.NewRecorder()
+	assert.True(t, checkNotModified(rr, req, CONFIG, v, false))
+	assert.Equal(t, http.StatusNotModified, rr.Code)
+
+	// the owner's copy of the page is different
+	rr = httptest.NewRecorder()
+	assert.False(t, checkNotModified(rr, req, CONFIG, v, true))
+	assert.Contains(t, rr.Header().Get("Cache-Control"), "private")
+
+	req, _ = http.NewRequest("GET", "/", nil)
+	req.Header.Set("If-Modified-Since", date.Format(http.TimeFormat))
+	rr = httptest.NewRecorder()
+	assert.True(t, checkNotModified(rr, req, CONFIG, v, false))
+
+	req.Header.Set("If-Modified-Since", date.AddDate(0, 0, -1).Format(http.TimeFormat))
+	rr = httptest.NewRecorder()
+	assert.False(t, checkNotModified(rr, req, CONFIG, v, false))
+
+	// If-None-Match wins
+	req.Header.Set("If-Modified-Since", date.Format(http.TimeFormat))
+	req.Header.Set("If-None-Match", `W/"other"`)
+	rr = httptest.NewRecorder()
+	assert.False(t, checkNotModified(rr, req, CONFIG, v, false))
+
+	// flash messages are never served from a cache
+	req, _ = http.NewRequest("GET", "/", nil)
+	req.Header.Set("If-None-Match", v.ETag)
+	req.AddCookie(&http.Cookie{Name: "flash", Value: "aGk="})
+	rr = httptest.NewRecorder()
+	assert.False(t, checkNotModified(rr, req, CONFIG, v, false))
+}
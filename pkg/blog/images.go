@@ -0,0 +1,365 @@
+package blog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"html/template"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-chi/chi"
+)
+
+/*
+Uploaded JPEGs and PNGs go through processImage: the file served at
+/uploads is re-encoded (which drops EXIF, GPS and other metadata),
+turned upright and at most the largest of imageSizes wide, next to it
+are the smaller imageSizes and a square thumbnail. The upload as it
+was sent is kept in the originals dir, which only the owner can see at
+/originals. GIFs are published as they are; anything else is refused,
+since we couldn't strip its metadata.
+*/
+
+var imageSizes = []int{480, 960, 1920}
+
+const (
+	thumbnailSize = 160
+	jpegQuality   = 85
+	ORIGINALSPATH = "/originals"
+	// images are decoded whole, this bounds the memory that takes
+	maxImagePixels = 50 * 1000 * 1000
+)
+
+// originalsDir is where uploads are kept untouched
+func (config Config) originalsDir() string {
+	if config.OriginalsDir != "" {
+		return config.OriginalsDir
+	}
+	return filepath.Join(filepath.Dir(filepath.Clean(config.UploadsDir)), "originals")
+}
+
+/*
+processImage writes the public versions of the uploaded image content
+as name in the uploads dir, returning it as Media. GIFs, which may be
+animated and carry no EXIF, are written as they are. Other formats,
+images we can't decode and images over maxImagePixels are refused.
+*/
+func processImage(config Config, name string, content []byte) (Media, error) {
+	url := strings.Join([]string{config.Blog.Url, "uploads", name}, "/")
+	m := Media{URL: url, Type: http.DetectContentType(content)}
+
+	switch m.Type {
+	case "image/jpeg", "image/png", "image/gif":
+	default:
+		return m, fmt.Errorf(
+			"%s is %s, only JPEG, PNG and GIF images can be uploaded", name, m.Type)
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		return m, fmt.Errorf("could not read %s: %v", name, err)
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > maxImagePixels {
+		return m, fmt.Errorf("%s is too large (%dx%d)", name, cfg.Width, cfg.Height)
+	}
+	m.Width, m.Height = cfg.Width, cfg.Height
+
+	if m.Type == "image/gif" {
+		return m, ioutil.WriteFile(filepath.Join(config.UploadsDir, name), content, 0644)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		return m, fmt.Errorf("could not decode %s: %v", name, err)
+	}
+
+	err = os.MkdirAll(config.originalsDir(), 0755)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(config.originalsDir(), name), content, 0600)
+	}
+	if err != nil {
+		return m, fmt.Errorf("could not keep original: %v", err)
+	}
+	m.Original = ORIGINALSPATH + "/" + name
+
+	rgba := orient(img, exifOrientation(content))
+	width := rgba.Rect.Dx()
+
+	largest := imageSizes[len(imageSizes)-1]
+	if width > largest {
+		rgba = scale(rgba, largest, rgba.Rect.Dy()*largest/width)
+		width = largest
+	}
+	m.Width, m.Height = width, rgba.Rect.Dy()
+
+	err = writeImage(config, name, m.Type, rgba)
+	if err != nil {
+		return m, err
+	}
+	for _, size := range imageSizes {
+		if size >= width {
+			break
+		}
+		sized := scale(rgba, size, m.Height*size/width)
+		err = writeImage(config, variantName(name, fmt.Sprint(size)), m.Type, sized)
+		if err != nil {
+			return m, err
+		}
+		m.Sizes = append(m.Sizes, size)
+	}
+
+	err = writeImage(config, variantName(name, "thumb"), m.Type, thumbnail(rgba))
+	if err != nil {
+		return m, err
+	}
+	m.Thumbnail = variantName(url, "thumb")
+
+	return m, nil
+}
+
+// variantName adds suffix to the name (or url) before its extension
+func variantName(name string, suffix string) string {
+	ext := filepath.Ext(name)
+	return strings.TrimSuffix(name, ext) + "-" + suffix + ext
+}
+
+func writeImage(config Config, name string, contentType string, img image.Image) error {
+	var buf bytes.Buffer
+	var err error
+	if contentType == "image/png" {
+		err = png.Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality})
+	}
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(config.UploadsDir, name), buf.Bytes(), 0644)
+}
+
+/*
+exifOrientation finds the orientation tag (1-8, see orient) in the
+EXIF of a JPEG, it's 1 if there isn't one.
+*/
+func exifOrientation(content []byte) int {
+	if len(content) < 4 || content[0] != 0xff || content[1] != 0xd8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(content) && content[i] == 0xff; {
+		marker := content[i+1]
+		length := int(binary.BigEndian.Uint16(content[i+2:]))
+		if marker == 0xda || i+2+length > len(content) {
+			// image data starts, no more metadata
+			break
+		}
+		segment := content[i+4 : i+2+length]
+		if marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return tiffOrientation(segment[6:])
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for n := 0; n < entries; n++ {
+		entry := ifd + 2 + n*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			orientation := int(order.Uint16(tiff[entry+8:]))
+			if orientation < 1 || orientation > 8 {
+				return 1
+			}
+			return orientation
+		}
+	}
+	return 1
+}
+
+/*
+orient copies img into an RGBA turned the right way up for its EXIF
+orientation: 2 is mirrored, 3 upside down, 4 flipped, 5 transposed, 6
+needs turning clockwise, 7 is transversed and 8 needs turning
+anticlockwise.
+*/
+func orient(img image.Image, orientation int) *image.RGBA {
+	b := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Rect, img, b.Min, draw.Src)
+	if orientation <= 1 || orientation > 8 {
+		return src
+	}
+
+	w, h := b.Dx(), b.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	if orientation >= 5 {
+		dst = image.NewRGBA(image.Rect(0, 0, h, w))
+	}
+	for y := 0; y < dst.Rect.Dy(); y++ {
+		for x := 0; x < dst.Rect.Dx(); x++ {
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4],
+				src.Pix[src.PixOffset(sx, sy):src.PixOffset(sx, sy)+4])
+		}
+	}
+	return dst
+}
+
+/*
+scale resizes src to width x height, each pixel the average of the
+ones it covers, which is good enough for making images smaller.
+*/
+func scale(src *image.RGBA, width int, height int) *image.RGBA {
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		y0, y1 := y*sh/height, (y+1)*sh/height
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < width; x++ {
+			x0, x1 := x*sw/width, (x+1)*sw/width
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				i := src.PixOffset(src.Rect.Min.X+x0, src.Rect.Min.Y+sy)
+				for sx := x0; sx < x1; sx++ {
+					sum[0] += int(src.Pix[i])
+					sum[1] += int(src.Pix[i+1])
+					sum[2] += int(src.Pix[i+2])
+					sum[3] += int(src.Pix[i+3])
+					i += 4
+				}
+			}
+			n := (y1 - y0) * (x1 - x0)
+			i := dst.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				dst.Pix[i+c] = uint8(sum[c] / n)
+			}
+		}
+	}
+	return dst
+}
+
+// thumbnail crops the middle square of img and scales it down
+func thumbnail(img *image.RGBA) *image.RGBA {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	side := w
+	if h < side {
+		side = h
+	}
+	x0, y0 := (w-side)/2, (h-side)/2
+	square := img.SubImage(image.Rect(x0, y0, x0+side, y0+side)).(*image.RGBA)
+
+	size := thumbnailSize
+	if side < size {
+		size = side
+	}
+	return scale(square, size, size)
+}
+
+/*
+imager renders an img for m, with a srcset of its sizes so browsers
+can pick the smallest that will do. sizes is the img's sizes
+attribute, defaulting to the full width of the viewport.
+*/
+func imager(m Media, sizes ...string) template.HTML {
+	attrs := []string{
+		fmt.Sprintf(`src="%s"`, template.HTMLEscapeString(m.URL)),
+	}
+	if len(m.Sizes) > 0 && m.Width > 0 {
+		var srcset []string
+		for _, size := range m.Sizes {
+			srcset = append(srcset, fmt.Sprintf("%s %dw",
+				variantName(m.URL, fmt.Sprint(size)), size))
+		}
+		srcset = append(srcset, fmt.Sprintf("%s %dw", m.URL, m.Width))
+
+		sizesAttr := "100vw"
+		if len(sizes) > 0 && sizes[0] != "" {
+			sizesAttr = sizes[0]
+		}
+		attrs = append(attrs,
+			fmt.Sprintf(`srcset="%s"`, template.HTMLEscapeString(strings.Join(srcset, ", "))),
+			fmt.Sprintf(`sizes="%s"`, template.HTMLEscapeString(sizesAttr)))
+	}
+	if m.Width > 0 && m.Height > 0 {
+		attrs = append(attrs, fmt.Sprintf(`width="%d" height="%d"`, m.Width, m.Height))
+	}
+	attrs = append(attrs,
+		fmt.Sprintf(`alt="%s"`, template.HTMLEscapeString(m.Alt)),
+		`loading="lazy"`)
+
+	return template.HTML("<img " + strings.Join(attrs, " ") + ">")
+}
+
+// CreateOriginalFunc serves the owner the uploads as they were sent
+func CreateOriginalFunc(config Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !checkIsOwner(config, r) {
+			http.NotFound(w, r)
+			return
+		}
+		name := filepath.Base(filepath.Clean("/" + chi.URLParam(r, "name")))
+		if name == "/" {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeFile(w, r, filepath.Join(config.originalsDir(), name))
+	}
+}
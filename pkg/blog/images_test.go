@@ -0,0 +1,164 @@
+package blog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/assert"
+)
+
+// a jpeg of width x height with an EXIF orientation
+func testJPEG(t *testing.T, width int, height int, orientation byte) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		img.Set(x, 0, color.RGBA{255, 0, 0, 255})
+	}
+	var buf bytes.Buffer
+	assert.Nil(t, jpeg.Encode(&buf, img, nil))
+	content := buf.Bytes()
+
+	exif := []byte("Exif\x00\x00MM\x00\x2a\x00\x00\x00\x08" +
+		"\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01\x00" + string(orientation) + "\x00\x00" +
+		"\x00\x00\x00\x00GPS")
+	segment := append([]byte{0xff, 0xe1, 0, byte(len(exif) + 2)}, exif...)
+	return append(append(content[:2:2], segment...), content[2:]...)
+}
+
+func TestExifOrientation(t *testing.T) {
+	assert.Equal(t, 6, exifOrientation(testJPEG(t, 4, 2, 6)))
+	assert.Equal(t, 1, exifOrientation([]byte("\x89PNG")))
+
+	// red along the top, turned clockwise it's down the right
+	img := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	img.Set(0, 0, color.RGBA{255, 0, 0, 255})
+	turned := orient(img, 6)
+	assert.Equal(t, image.Rect(0, 0, 2, 4), turned.Rect)
+	assert.Equal(t, color.RGBA{255, 0, 0, 255}, turned.At(1, 0))
+	assert.Equal(t, color.RGBA{}, turned.At(0, 0))
+}
+
+func TestProcessImage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goldfrog-images")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	var config Config
+	config.Blog.Url = "https://example.com"
+	config.UploadsDir = filepath.Join(dir, "uploads")
+	os.Mkdir(config.UploadsDir, 0755)
+
+	content := testJPEG(t, 2400, 1000, 6)
+	m, err := processImage(config, "photo.jpg", content)
+	assert.Nil(t, err)
+
+	// turned upright
+	assert.Equal(t, 1000, m.Width)
+	assert.Equal(t, 2400, m.Height)
+	assert.Equal(t, []int{480, 960}, m.Sizes)
+	assert.Equal(t, "https://example.com/uploads/photo-thumb.jpg", m.Thumbnail)
+	assert.Equal(t, "/originals/photo.jpg", m.Original)
+
+	public, _ := ioutil.ReadFile(filepath.Join(config.UploadsDir, "photo.jpg"))
+	assert.False(t, bytes.Contains(public, []byte("Exif")))
+	original, _ := ioutil.ReadFile(filepath.Join(dir, "originals", "photo.jpg"))
+	assert.Equal(t, content, original)
+
+	for name, width := range map[string]int{"photo-480.jpg": 480, "photo-thumb.jpg": 160} {
+		f, err := os.Open(filepath.Join(config.UploadsDir, name))
+		if assert.Nil(t, err) {
+			cfg, err := jpeg.DecodeConfig(f)
+			f.Close()
+			assert.Nil(t, err)
+			assert.Equal(t, width, cfg.Width)
+		}
+	}
+
+	// left alone
+	var gifBuf bytes.Buffer
+	assert.Nil(t, gif.Encode(&gifBuf, image.NewPaletted(
+		image.Rect(0, 0, 3, 2), color.Palette{color.Black}), nil))
+	m, err = processImage(config, "anim.gif", gifBuf.Bytes())
+	assert.Nil(t, err)
+	assert.Equal(t, "", m.Original)
+	assert.Equal(t, 0, len(m.Sizes))
+	assert.Equal(t, 3, m.Width)
+
+	// formats we can't strip the metadata of
+	_, err = processImage(config, "phone.webp", []byte("RIFF\x00\x00\x00\x00WEBPVP8 "))
+	assert.NotNil(t, err)
+	_, err = processImage(config, "broken.jpg", []byte("\xff\xd8\xff not really"))
+	assert.NotNil(t, err)
+	for _, name := range []string{"phone.webp", "broken.jpg"} {
+		_, err := os.Stat(filepath.Join(config.UploadsDir, name))
+		assert.True(t, os.IsNotExist(err), name)
+	}
+
+	// a small file claiming to be huge isn't decoded
+	var pngBuf bytes.Buffer
+	assert.Nil(t, png.Encode(&pngBuf, image.NewRGBA(image.Rect(0, 0, 1, 1))))
+	huge := pngBuf.Bytes()
+	binary.BigEndian.PutUint32(huge[16:], 100000)
+	binary.BigEndian.PutUint32(huge[20:], 100000)
+	binary.BigEndian.PutUint32(huge[29:], crc32.ChecksumIEEE(huge[12:29]))
+	_, err = processImage(config, "huge.png", huge)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "too large")
+	}
+}
+
+func TestImager(t *testing.T) {
+	m := Media{
+		URL:    "/uploads/a.jpg",
+		Alt:    "A <cat>",
+		Width:  1200,
+		Height: 800,
+		Sizes:  []int{480, 960},
+	}
+	assert.Equal(t,
+		`<img src="/uploads/a.jpg" `+
+			`srcset="/uploads/a-480.jpg 480w, /uploads/a-960.jpg 960w, /uploads/a.jpg 1200w" `+
+			`sizes="50vw" width="1200" height="800" alt="A &lt;cat&gt;" loading="lazy">`,
+		string(imager(m, "50vw")))
+
+	assert.Equal(t,
+		`<img src="/uploads/b.png" alt="" loading="lazy">`,
+		string(imager(Media{URL: "/uploads/b.png"})))
+}
+
+func TestOriginals(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goldfrog-originals")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "photo.jpg"), []byte("original"), 0600)
+
+	var config Config
+	config.OriginalsDir = dir
+	config.Signin.Username = "me"
+	config.Signin.Password = "pw"
+	r := chi.NewRouter()
+	r.Get(ORIGINALSPATH+"/{name}", CreateOriginalFunc(config))
+
+	req := httptest.NewRequest("GET", "/originals/photo.jpg", nil)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	req.AddCookie(&http.Cookie{Name: "goldfrog", Value: hashAccount("me", "pw")})
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "original", rr.Body.String())
+}
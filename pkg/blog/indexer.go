@@ -28,6 +28,18 @@ func IndexPosts(postsDir string, dbFile string, verbose bool) {
 		return
 	}
 
+	err = MigrateDb(db)
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+
+	err = initPostLinks(db)
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+
 	repo := FilePostsRepo{
 		PostsDirectory: postsDir,
 	}
@@ -67,16 +79,28 @@ func IndexFile(file string, db *sql.DB, verbose bool) int {
 
 	var sql = `
 		INSERT INTO posts (
-			slug, title, tags, postdate, frontmatter, body, format
+			slug, title, tags, postdate, frontmatter, body, format, kind,
+			series, series_order, author, updated
 		) VALUES (
-			?, ?, ?, ?, ?, ?, 'markdown'
+			?, ?, ?, ?, ?, ?, 'markdown', ?, ?, ?, ?, ?
 		) ON CONFLICT(slug) DO UPDATE
 		SET
 			title=excluded.title,
 			tags=excluded.tags,
 			postdate=excluded.postdate,
 			frontmatter=excluded.frontmatter,
-			body=excluded.body;
+			body=excluded.body,
+			kind=excluded.kind,
+			series=excluded.series,
+			series_order=excluded.series_order,
+			author=excluded.author,
+			updated=excluded.updated
+		-- reindexing an unchanged file isn't an update
+		WHERE title != excluded.title
+			OR tags != excluded.tags
+			OR postdate != excluded.postdate
+			OR frontmatter != excluded.frontmatter
+			OR body != excluded.body;
 	`
 	logger.Infof("Insert/Update post %s", post.Slug)
 
@@ -89,6 +113,11 @@ func IndexFile(file string, db *sql.DB, verbose bool) int {
 		post.PostDate.Format(time.RFC3339),
 		fmStr,
 		post.Body,
+		post.Kind(),
+		post.Series(),
+		post.SeriesOrder(),
+		post.Author(),
+		time.Now().UTC().Format(time.RFC3339),
 	)
 
 	if err != nil {
@@ -110,6 +139,13 @@ func IndexFile(file string, db *sql.DB, verbose bool) int {
 		return 0
 	}
 
+	if stored, err := GetPostBySlug(db, post.Slug); err == nil {
+		err = indexPostLinks(db, stored)
+		if err != nil {
+			logger.Errorf("Could not index links of %s: %v", post.Slug, err)
+		}
+	}
+
 	rowCount, _ := res.RowsAffected()
 	lastId, _ := res.LastInsertId()
 
@@ -0,0 +1,167 @@
+package blog
+
+import (
+	"fmt"
+	"html/template"
+	"net/url"
+	"strings"
+)
+
+/*
+Besides articles and notes, a post can respond to a page elsewhere: its
+front matter names the page under the microformats2 property for the
+response, one of kindProperties, like
+
+	in-reply-to: https://example.com/their-post
+
+Photo posts have a `photo` entry instead, the url of the photo, or
+"true" when the photos are the post's media. The kind is also kept in
+the posts table, so the index and feeds can filter on it.
+*/
+
+var kindProperties = []struct {
+	Kind     string
+	Property string
+}{
+	{KINDREPLY, "in-reply-to"},
+	{KINDREPOST, "repost-of"},
+	{KINDLIKE, "like-of"},
+	{KINDBOOKMARK, "bookmark-of"},
+}
+
+// PostKinds are all the kinds of post, each has its feeds
+var PostKinds = []string{
+	KINDARTICLE, KINDNOTE, KINDREPLY, KINDLIKE, KINDBOOKMARK, KINDREPOST, KINDPHOTO,
+}
+
+var kindTitles = map[string]string{
+	KINDARTICLE:  "Articles",
+	KINDNOTE:     "Notes",
+	KINDREPLY:    "Replies",
+	KINDLIKE:     "Likes",
+	KINDBOOKMARK: "Bookmarks",
+	KINDREPOST:   "Reposts",
+	KINDPHOTO:    "Photos",
+}
+
+func validKind(kind string) bool {
+	_, ok := kindTitles[kind]
+	return ok
+}
+
+// kindPath is where the feeds of kind are, like /articles
+func kindPath(kind string) string {
+	return "/" + strings.ToLower(kindTitles[kind])
+}
+
+/*
+postKind works out the kind of a post: responses first, then photos,
+then KINDARTICLE when it has a title and KINDNOTE when it doesn't.
+*/
+func postKind(title string, frontMatter map[string]string) string {
+	for _, kp := range kindProperties {
+		if strings.TrimSpace(frontMatter[kp.Property]) != "" {
+			return kp.Kind
+		}
+	}
+	if strings.TrimSpace(frontMatter["photo"]) != "" {
+		return KINDPHOTO
+	}
+	if title == "" {
+		return KINDNOTE
+	}
+	return KINDARTICLE
+}
+
+// KindTarget is the url of the page the post responds to, if any
+func (post *Post) KindTarget() string {
+	return kindTarget(post.FrontMatter)
+}
+
+func kindTarget(frontMatter map[string]string) string {
+	for _, kp := range kindProperties {
+		if target := strings.TrimSpace(frontMatter[kp.Property]); target != "" {
+			return target
+		}
+	}
+	return ""
+}
+
+// Photo is the url of a photo post's photo
+func (post *Post) Photo() string {
+	if post.Kind() != KINDPHOTO {
+		return ""
+	}
+	photo := strings.TrimSpace(post.FrontMatter["photo"])
+	if strings.HasPrefix(photo, "http") || strings.HasPrefix(photo, "/") {
+		return photo
+	}
+	if len(post.Media) > 0 {
+		return post.Media[0].URL
+	}
+	return ""
+}
+
+// kindFrontMatter copies the kind properties found in values to frontMatter
+func kindFrontMatter(values url.Values, frontMatter map[string]string) {
+	for _, kp := range kindProperties {
+		if v := strings.TrimSpace(values.Get(kp.Property)); v != "" {
+			frontMatter[kp.Property] = v
+		}
+	}
+	if v := strings.TrimSpace(values.Get("photo")); v != "" {
+		frontMatter["photo"] = v
+	}
+}
+
+var kindVerbs = map[string]string{
+	KINDREPLY:    "In reply to",
+	KINDREPOST:   "Reposted",
+	KINDLIKE:     "Liked",
+	KINDBOOKMARK: "Bookmarked",
+}
+
+/*
+hentryer renders the h-entry properties of the post's kind: the link to
+the page it responds to (as u-in-reply-to, u-like-of and so on) or the
+photo of a photo post.
+*/
+func hentryer(post *Post) template.HTML {
+	if target := post.KindTarget(); target != "" {
+		kind := post.Kind()
+		var property string
+		for _, kp := range kindProperties {
+			if kp.Kind == kind {
+				property = kp.Property
+			}
+		}
+		// only http(s) targets are linked, anything else is shown as text
+		link := httpURL(target)
+		if link == "" {
+			return template.HTML(fmt.Sprintf(
+				`<p class="kind-%s">%s <span class="p-%s">%s</span></p>`,
+				kind, kindVerbs[kind], property, template.HTMLEscapeString(target)))
+		}
+		u, _ := url.Parse(link)
+		label := strings.TrimSuffix(u.Host+u.Path, "/")
+		return template.HTML(fmt.Sprintf(
+			`<p class="kind-%s">%s <a class="u-%s" href="%s">%s</a></p>`,
+			kind, kindVerbs[kind], property,
+			template.HTMLEscapeString(link), template.HTMLEscapeString(label)))
+	}
+
+	// photos from the post's media are left to the gallery
+	photo := post.Photo()
+	if photo != "" && photo == strings.TrimSpace(post.FrontMatter["photo"]) &&
+		!strings.Contains(post.Body, photo) {
+		alt := ""
+		for _, m := range post.Media {
+			if m.URL == photo {
+				alt = m.Alt
+			}
+		}
+		return template.HTML(fmt.Sprintf(`<img class="u-photo" src="%s" alt="%s">`,
+			template.HTMLEscapeString(photo), template.HTMLEscapeString(alt)))
+	}
+	return ""
+}
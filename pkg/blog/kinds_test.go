@@ -0,0 +1,100 @@
+package blog
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPostKind(t *testing.T) {
+	assert.Equal(t, KINDNOTE, postKind("", nil))
+	assert.Equal(t, KINDARTICLE, postKind("Title", map[string]string{}))
+	assert.Equal(t, KINDREPLY, postKind("Title", map[string]string{
+		"in-reply-to": "https://a.example/post",
+		"like-of":     "https://a.example/post",
+	}))
+	assert.Equal(t, KINDLIKE, postKind("", map[string]string{"like-of": "https://a.example/"}))
+	assert.Equal(t, KINDPHOTO, postKind("", map[string]string{"photo": "true"}))
+
+	post := NewPost(PostOpts{FrontMatter: map[string]string{
+		"bookmark-of": "https://a.example/some/page/",
+	}})
+	assert.Equal(t, KINDBOOKMARK, post.Kind())
+	assert.Equal(t, "https://a.example/some/page/", post.KindTarget())
+	assert.Equal(t,
+		`<p class="kind-bookmark">Bookmarked <a class="u-bookmark-of" `+
+			`href="https://a.example/some/page/">a.example/some/page</a></p>`,
+		string(hentryer(&post)))
+
+	// anything but an http(s) url is shown, not linked
+	post.FrontMatter["bookmark-of"] = "javascript:alert(1)"
+	assert.Equal(t,
+		`<p class="kind-bookmark">Bookmarked <span class="p-bookmark-of">javascript:alert(1)</span></p>`,
+		string(hentryer(&post)))
+
+	photo := NewPost(PostOpts{
+		FrontMatter: map[string]string{"photo": "true"},
+		Media:       []Media{{URL: "/uploads/a.jpg"}},
+	})
+	assert.Equal(t, "/uploads/a.jpg", photo.Photo())
+	// shown by the gallery
+	assert.Equal(t, "", string(hentryer(&photo)))
+	photo.FrontMatter["photo"] = "https://photos.example/a.jpg"
+	assert.Contains(t, string(hentryer(&photo)), `class="u-photo" src="https://photos.example/a.jpg"`)
+
+	assert.Equal(t, "/replies", kindPath(KINDREPLY))
+	assert.False(t, validKind("essay"))
+}
+
+func TestKindFilter(t *testing.T) {
+	os.Remove(testDb)
+	db, _ := GetDb(testDb)
+	defer os.Remove(testDb)
+
+	// a posts table from before there were kinds
+	_, err := db.Exec(`CREATE TABLE posts (
+		id integer primary key,
+		title varchar(1024) default "",
+		slug varchar(256) unique,
+		postdate varchar(25),
+		tags varchar(1024),
+		frontmatter text default "",
+		body text default "",
+		format varchar(15));
+	INSERT INTO posts (title, slug, postdate, tags, frontmatter, body)
+	VALUES ("", "old-like", "2020-01-01T00:00:00Z", "",
+		"like-of: https://a.example/", ""),
+		("Old", "old", "2020-01-01T00:00:00Z", "", "", "");`)
+	assert.Nil(t, err)
+	assert.Nil(t, MigrateDb(db))
+	// again is fine
+	assert.Nil(t, MigrateDb(db))
+
+	reply := NewPost(PostOpts{
+		Slug:        "reply",
+		Body:        "I agree",
+		FrontMatter: map[string]string{"in-reply-to": "https://a.example/"},
+	})
+	assert.Nil(t, CreatePost(db, &reply))
+	note := NewPost(PostOpts{Slug: "note", Body: "Just saying"})
+	assert.Nil(t, CreatePost(db, &note))
+
+	slugs := func(kind string) []string {
+		var slugs []string
+		for _, post := range GetPosts(db, GetPostOpts{Kind: kind}) {
+			slugs = append(slugs, post.Slug)
+		}
+		return slugs
+	}
+	assert.Equal(t, []string{"old-like"}, slugs(KINDLIKE))
+	assert.Equal(t, []string{"old"}, slugs(KINDARTICLE))
+	assert.Equal(t, []string{"reply"}, slugs(KINDREPLY))
+	assert.Equal(t, []string{"note"}, slugs(KINDNOTE))
+
+	// a note becomes a reply when edited
+	saved, _ := GetPostBySlug(db, "note")
+	saved.FrontMatter["in-reply-to"] = "https://b.example/"
+	assert.Nil(t, SavePost(db, saved))
+	assert.ElementsMatch(t, []string{"reply", "note"}, slugs(KINDREPLY))
+}
@@ -0,0 +1,153 @@
+package blog
+
+import (
+	"database/sql"
+	"fmt"
+	"html/template"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/gomarkdown/markdown/ast"
+	"github.com/sivy/goldfrog/pkg/webmention"
+)
+
+/*
+With markdown.linkcards on, a url on a line of its own in a post body
+renders as a card with the linked page's title, description, image and
+site name. The pages are fetched when the post is saved and kept in the
+link_previews table; a url whose preview hasn't been fetched (yet)
+stays a plain link, so rendering never waits on the network.
+*/
+
+var linkPreviews *webmention.ContextCache
+
+func initLinkPreviews(config Config, db *sql.DB) {
+	if !config.Markdown.LinkCards {
+		linkPreviews = nil
+		return
+	}
+	cache, err := webmention.NewPreviewCache(db)
+	if err != nil {
+		logger.Errorf("Could not set up link previews: %v", err)
+		return
+	}
+	linkPreviews = cache
+}
+
+/*
+cardURL is the url a paragraph holds if that's all it holds, as the
+autolink of a bare url.
+*/
+func cardURL(node ast.Node) (string, bool) {
+	para, ok := node.(*ast.Paragraph)
+	if !ok {
+		return "", false
+	}
+	var link *ast.Link
+	for _, child := range para.Children {
+		switch c := child.(type) {
+		case *ast.Link:
+			if link != nil {
+				return "", false
+			}
+			link = c
+		case *ast.Text:
+			if strings.TrimSpace(string(c.Literal)) != "" {
+				return "", false
+			}
+		default:
+			return "", false
+		}
+	}
+	if link == nil || len(link.Children) != 1 {
+		return "", false
+	}
+	dest := string(link.Destination)
+	label, ok := link.Children[0].(*ast.Text)
+	if !ok || string(label.Literal) != dest {
+		return "", false
+	}
+	if !strings.HasPrefix(dest, "http://") && !strings.HasPrefix(dest, "https://") {
+		return "", false
+	}
+	return dest, true
+}
+
+// cardURLs are the urls in body that would render as cards
+func cardURLs(body string) []string {
+	doc := markdownRenderer.Parse(body)
+	var urls []string
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if u, ok := cardURL(node); ok && entering {
+			urls = append(urls, u)
+		}
+		return ast.GoToNext
+	})
+	return urls
+}
+
+/*
+fetchLinkPreviews fetches the previews of the cards in post's body in
+the background, and drops its rendering once they're in.
+*/
+func fetchLinkPreviews(post *Post) {
+	if linkPreviews == nil {
+		return
+	}
+	urls := cardURLs(post.Body)
+	if len(urls) == 0 {
+		return
+	}
+	cache := linkPreviews
+	go func() {
+		for _, u := range urls {
+			cache.Lookup(u)
+		}
+		renderedMarkdown.Forget(post.Body)
+	}()
+}
+
+// renderLinkCard is the markdown render hook for link cards
+func renderLinkCard(w io.Writer, node ast.Node, entering bool) (ast.WalkStatus, bool) {
+	if linkPreviews == nil {
+		return ast.GoToNext, false
+	}
+	target, ok := cardURL(node)
+	if !ok {
+		return ast.GoToNext, false
+	}
+	preview, ok := linkPreviews.Get(target)
+	if !ok || preview.Name == "" {
+		return ast.GoToNext, false
+	}
+	if entering {
+		io.WriteString(w, string(linkCarder(preview)))
+	}
+	return ast.SkipChildren, true
+}
+
+func linkCarder(preview *webmention.Context) template.HTML {
+	esc := template.HTMLEscapeString
+	site := preview.SiteName
+	if site == "" {
+		if u, err := url.Parse(preview.URL); err == nil {
+			site = strings.TrimPrefix(u.Host, "www.")
+		}
+	}
+
+	var parts []string
+	if preview.Image != "" {
+		parts = append(parts, fmt.Sprintf(
+			`<img class="u-photo" src="%s" alt="" loading="lazy">`, esc(preview.Image)))
+	}
+	parts = append(parts, fmt.Sprintf(`<span class="p-name">%s</span>`, esc(preview.Name)))
+	if preview.Summary != "" {
+		parts = append(parts, fmt.Sprintf(`<span class="p-summary">%s</span>`, esc(preview.Summary)))
+	}
+	parts = append(parts, fmt.Sprintf(`<span class="link-card-site">%s</span>`, esc(site)))
+
+	return template.HTML(fmt.Sprintf(
+		`<div class="link-card h-cite"><a class="u-url" href="%s">%s</a></div>`+"\n",
+		esc(preview.URL), strings.Join(parts, "")))
+}
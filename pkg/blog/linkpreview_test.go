@@ -0,0 +1,70 @@
+package blog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCardURLs(t *testing.T) {
+	urls := cardURLs(`Look at this:
+
+https://example.com/a
+
+And https://example.com/b in a sentence, [a link](https://example.com/c)
+and <https://example.com/d>
+
+[https://example.com/e](https://example.com/other)
+
+https://example.com/f
+`)
+	assert.Equal(t, []string{"https://example.com/a", "https://example.com/f"}, urls)
+}
+
+func TestLinkCards(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><head>
+			<meta property="og:title" content="Frogs &amp; Toads">
+			<meta property="og:description" content="All about them">
+			<meta property="og:image" content="/frog.jpg">
+			</head></html>`))
+	}))
+	defer server.Close()
+
+	assert.Nil(t, initDb(testDb))
+	defer os.Remove(testDb)
+	db, _ := GetDb(testDb)
+
+	var config Config
+	initLinkPreviews(config, db)
+	assert.Nil(t, linkPreviews)
+
+	config.Markdown.LinkCards = true
+	initLinkPreviews(config, db)
+	defer func() { linkPreviews = nil }()
+
+	target := server.URL + "/frogs"
+	post := NewPost(PostOpts{Body: "Read this\n\n" + target + "\n"})
+
+	// plain link until the preview is in
+	html := string(renderMarkdown(post.Body))
+	assert.Contains(t, html, `<p><a href="`+target+`">`+target+`</a></p>`)
+	assert.Equal(t, html, string(markDowner(post.Body)))
+
+	fetchLinkPreviews(&post)
+	for i := 0; i < 100 && !strings.Contains(html, "link-card"); i++ {
+		time.Sleep(10 * time.Millisecond)
+		html = string(markDowner(post.Body))
+	}
+	assert.Contains(t, html, `<div class="link-card h-cite"><a class="u-url" href="`+target+`">`)
+	assert.Contains(t, html, `<img class="u-photo" src="`+server.URL+`/frog.jpg" alt="" loading="lazy">`)
+	assert.Contains(t, html, `<span class="p-name">Frogs &amp; Toads</span>`)
+	assert.Contains(t, html, `<span class="p-summary">All about them</span>`)
+	assert.Contains(t, html, `<span class="link-card-site">127.0.0.1:`)
+	assert.False(t, strings.Contains(html, "<p><a"))
+}
@@ -2,6 +2,9 @@ package blog
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/gomarkdown/markdown"
@@ -48,3 +51,66 @@ ok?
 	output = fmt.Sprintf("%s", hashtagger(output))
 	assert.Contains(t, output, "<li>")
 }
+
+func TestMarkdownRenderer(t *testing.T) {
+	defer initMarkdown(Config{})
+
+	body := "A #Post about `#code`\n\n<script>alert(1)</script>\n\n- [x] done"
+	output := string(markDowner(body))
+	assert.Contains(t, output, `<a class="hashtag" href="/tag/post">#Post</a>`)
+	assert.NotContains(t, output, "<script>")
+	assert.Contains(t, output, `<input type="checkbox" checked="" disabled="">`)
+	// already linked, so left alone
+	assert.Equal(t, output, string(hashtagger(output)))
+
+	assert.Equal(t, []string{"existing", "post"}, updateTags(body, []string{"existing"}))
+
+	var config Config
+	config.Markdown.Extensions = []string{"tables"}
+	initMarkdown(config)
+	output = string(markDowner(body))
+	assert.Contains(t, output, "<li>[x] done</li>")
+}
+
+func TestMarkdownShortcodes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goldfrog-templates")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	defer initMarkdown(Config{})
+
+	os.Mkdir(filepath.Join(dir, "shortcodes"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "shortcodes", "aside.html"),
+		[]byte(`<aside>{{.Get 0}}</aside>`), 0644)
+
+	var config Config
+	config.TemplatesDir = dir
+	initMarkdown(config)
+
+	output := string(markDowner("{{< aside \"By the way\" >}}\n\n{{< youtube abc >}}"))
+	assert.Contains(t, output, "<aside>By the way</aside>")
+	assert.Contains(t, output, `<div class="embed embed-youtube">`)
+}
+
+func TestMarkdownDiagrams(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goldfrog-uploads")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	defer initMarkdown(Config{})
+
+	var config Config
+	assert.Equal(t, "", config.diagramsDir())
+	config.UploadsDir = filepath.Join(dir, "uploads")
+	assert.Equal(t, filepath.Join(dir, "diagrams"), config.diagramsDir())
+
+	config.Markdown.DiagramTools = map[string]string{
+		"Dot": `sed -e s/^/<svg>/ -e s/$/<\/svg>/`,
+	}
+	initMarkdown(config)
+
+	post := NewPost(PostOpts{Body: "```dot\ndigraph\n```"})
+	assert.NotContains(t, string(markDowner(post.Body)), "<svg>")
+
+	prerenderDiagrams(&post)
+	assert.Contains(t, string(markDowner(post.Body)),
+		`<figure class="diagram diagram-dot"><svg>digraph</svg></figure>`)
+}
@@ -0,0 +1,188 @@
+package blog
+
+import (
+	"database/sql"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sivy/goldfrog/pkg/syndication"
+	yaml "gopkg.in/yaml.v2"
+)
+
+/*
+Posts can have several images attached, kept as the `media` list of
+their front matter (see Media). The post forms upload them as
+`postimage` files, each described by the `postimage_alt` and
+`postimage_caption` fields in the same position; a `[image]` or
+`[image 2]` in the body is replaced by the first or second upload.
+Images not placed in the body are shown by the `gallery` template
+function.
+*/
+
+// frontMatterMedia reads the media list, telling whether there is one
+func frontMatterMedia(frontmatter string) ([]Media, bool) {
+	var fm struct {
+		Media *[]Media `yaml:"media"`
+	}
+	err := yaml.Unmarshal([]byte(frontmatter), &fm)
+	if err != nil {
+		logger.Debugf("Could not read media: %v", err)
+	}
+	if fm.Media == nil {
+		return nil, false
+	}
+	return *fm.Media, true
+}
+
+/*
+saveUploads writes the images uploaded with a post form to the
+uploads dir, records them in the media library and returns them as
+Media.
+*/
+func saveUploads(config Config, db *sql.DB, r *http.Request) []Media {
+	if r.MultipartForm == nil {
+		return nil
+	}
+	alts := r.MultipartForm.Value["postimage_alt"]
+	captions := r.MultipartForm.Value["postimage_caption"]
+
+	var media []Media
+	for i, header := range r.MultipartForm.File["postimage"] {
+		m, err := saveUpload(config, header)
+		if err != nil {
+			logger.Errorf("Could not save upload %s: %v", header.Filename, err)
+			continue
+		}
+		err = recordMedia(config, db, m, time.Now())
+		if err != nil {
+			logger.Errorf("Could not record upload %s: %v", header.Filename, err)
+		}
+
+		if i < len(alts) {
+			m.Alt = strings.TrimSpace(alts[i])
+		}
+		if i < len(captions) {
+			m.Caption = strings.TrimSpace(captions[i])
+		}
+		media = append(media, m)
+	}
+	return media
+}
+
+// saveUpload writes an uploaded file, see processImage
+func saveUpload(config Config, header *multipart.FileHeader) (Media, error) {
+	file, err := header.Open()
+	if err != nil {
+		return Media{}, err
+	}
+	defer file.Close()
+
+	content, err := ioutil.ReadAll(file)
+	if err != nil {
+		return Media{}, err
+	}
+
+	name := filepath.Base(header.Filename)
+	logger.Infof("Writing uploaded file: %s", filepath.Join(config.UploadsDir, name))
+	return processImage(config, name, content)
+}
+
+var imagePlaceholderRegex = regexp.MustCompile(`\[image(?: (\d+))?\]`)
+
+/*
+placeImages replaces `[image]` and `[image N]` in body with the first
+and Nth of media, as markdown images.
+*/
+func placeImages(body string, media []Media) string {
+	return imagePlaceholderRegex.ReplaceAllStringFunc(body, func(placeholder string) string {
+		n := 1
+		if m := imagePlaceholderRegex.FindStringSubmatch(placeholder); m[1] != "" {
+			n, _ = strconv.Atoi(m[1])
+		}
+		if n < 1 || n > len(media) {
+			return placeholder
+		}
+		alt := strings.NewReplacer("[", "", "]", "").Replace(media[n-1].Alt)
+		return fmt.Sprintf("![%s](%s)", alt, media[n-1].URL)
+	})
+}
+
+/*
+uploadPath is where the file for an uploads url is, or "" if url
+isn't one of ours.
+*/
+func uploadPath(config Config, url string) string {
+	for _, prefix := range []string{config.Blog.Url + "/uploads/", "/uploads/"} {
+		if strings.HasPrefix(url, prefix) {
+			name := filepath.Clean("/" + strings.TrimPrefix(url, prefix))
+			return filepath.Join(config.UploadsDir, name)
+		}
+	}
+	return ""
+}
+
+/*
+postMedia loads the post's uploaded images for the syndicators; images
+hosted elsewhere are left out.
+*/
+func postMedia(config Config, post *Post) []syndication.Media {
+	var media []syndication.Media
+	for _, m := range post.Media {
+		path := uploadPath(config, m.URL)
+		if path == "" {
+			continue
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			logger.Errorf("Could not read %s: %v", path, err)
+			continue
+		}
+
+		contentType := m.Type
+		if contentType == "" {
+			contentType = http.DetectContentType(content)
+		}
+		media = append(media, syndication.Media{
+			Content: content,
+			Type:    contentType,
+			Alt:     m.Alt,
+			Caption: m.Caption,
+		})
+	}
+	return media
+}
+
+/*
+galleryer renders the media of a post that isn't already placed in
+its body, as figures with their captions.
+*/
+func galleryer(post *Post) template.HTML {
+	var figures []string
+	for _, m := range post.Media {
+		if strings.Contains(post.Body, m.URL) {
+			continue
+		}
+		figure := fmt.Sprintf(`<img src="%s" alt="%s" loading="lazy">`,
+			template.HTMLEscapeString(m.URL), template.HTMLEscapeString(m.Alt))
+		if m.Caption != "" {
+			figure += fmt.Sprintf("<figcaption>%s</figcaption>",
+				template.HTMLEscapeString(m.Caption))
+		}
+		figures = append(figures, "<figure>"+figure+"</figure>")
+	}
+	if len(figures) == 0 {
+		return ""
+	}
+
+	return template.HTML(fmt.Sprintf(
+		`<div class="gallery gallery-%d">%s</div>`,
+		len(figures), strings.Join(figures, "")))
+}
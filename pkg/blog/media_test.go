@@ -0,0 +1,118 @@
+package blog
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPostMediaFrontMatter(t *testing.T) {
+	p := NewPost(PostOpts{
+		Title: "Photos",
+		Slug:  "photos",
+		Media: []Media{
+			{URL: "/uploads/a.png", Alt: "A cat", Caption: "Our cat"},
+			{URL: "/uploads/b.png", Alt: "A dog"},
+		},
+		FrontMatter: map[string]string{"mastodon_id": "1"},
+	})
+
+	fm := p.FrontMatterYAML()
+	assert.Contains(t, fm, "media:")
+	assert.Equal(t, "1", GetFrontMatter(fm)["mastodon_id"])
+	assert.Equal(t, p.Media, GetFrontMatterMedia(fm))
+
+	_, ok := frontMatterMedia("title: no media")
+	assert.False(t, ok)
+	media, ok := frontMatterMedia("media: []")
+	assert.True(t, ok)
+	assert.Equal(t, 0, len(media))
+}
+
+func TestPlaceImages(t *testing.T) {
+	media := []Media{
+		{URL: "/uploads/a.png", Alt: "A [cat]"},
+		{URL: "/uploads/b.png"},
+	}
+	assert.Equal(t,
+		"![A cat](/uploads/a.png) and ![](/uploads/b.png) but not [image 3]",
+		placeImages("[image] and [image 2] but not [image 3]", media))
+}
+
+func TestGallery(t *testing.T) {
+	post := NewPost(PostOpts{
+		Body: "Inline: ![](/uploads/a.png)",
+		Media: []Media{
+			{URL: "/uploads/a.png"},
+			{URL: "/uploads/b.png", Alt: `A "dog"`, Caption: "<Rex>"},
+		},
+	})
+	html := string(galleryer(&post))
+	assert.NotContains(t, html, "a.png")
+	assert.Contains(t, html, `<div class="gallery gallery-1">`)
+	assert.Contains(t, html, `alt="A &#34;dog&#34;"`)
+	assert.Contains(t, html, "<figcaption>&lt;Rex&gt;</figcaption>")
+
+	assert.Equal(t, "", string(galleryer(&Post{})))
+}
+
+func TestSaveUploads(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goldfrog-uploads")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.Nil(t, initDb(testDb))
+	defer os.Remove(testDb)
+	db, _ := GetDb(testDb)
+
+	var config Config
+	config.Blog.Url = "https://example.com"
+	config.UploadsDir = dir
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for i, name := range []string{"one.png", "two.png"} {
+		part, _ := mw.CreateFormFile("postimage", "../"+name)
+		png.Encode(part, image.NewGray(image.Rect(0, 0, i+1, 1)))
+	}
+	mw.WriteField("postimage_alt", "First")
+	mw.WriteField("postimage_alt", "Second")
+	mw.WriteField("postimage_caption", "Caption")
+	mw.Close()
+
+	req := httptest.NewRequest("POST", "/new", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.ParseMultipartForm(1 << 20)
+
+	media := saveUploads(config, db, req)
+	if assert.Equal(t, 2, len(media)) {
+		assert.Equal(t, "https://example.com/uploads/one.png", media[0].URL)
+		assert.Equal(t, "First", media[0].Alt)
+		assert.Equal(t, "Caption", media[0].Caption)
+		assert.Equal(t, "Second", media[1].Alt)
+		assert.Equal(t, "", media[1].Caption)
+	}
+	_, err = os.Stat(filepath.Join(dir, "two.png"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(GetMediaFiles(config, db)))
+
+	post := NewPost(PostOpts{Media: append(media,
+		Media{URL: "https://elsewhere.example/c.png"},
+		Media{URL: "/uploads/../../etc/passwd"})})
+	loaded := postMedia(config, &post)
+	if assert.Equal(t, 2, len(loaded)) {
+		assert.Equal(t, "image/png", loaded[0].Type)
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(loaded[1].Content))
+		assert.Nil(t, err)
+		assert.Equal(t, 2, cfg.Width)
+		assert.Equal(t, "Second", loaded[1].Alt)
+	}
+}
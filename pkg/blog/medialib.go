@@ -0,0 +1,459 @@
+package blog
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+The media library is the owner's view of the uploads, at /media. Files
+are recorded in the media table as they are uploaded, and when the
+library finds files in the uploads dir it doesn't know yet. The posts
+using a file are the ones that mention its url.
+*/
+
+const MEDIAPATH = "/media"
+
+type MediaFile struct {
+	Media
+	ID       int
+	Name     string
+	Size     int64
+	Uploaded time.Time
+
+	// Posts using the file, filled in for the library page
+	Posts []*Post
+}
+
+// Preview is the url of the smallest version of the file
+func (f MediaFile) Preview() string {
+	if f.Thumbnail != "" {
+		return f.Thumbnail
+	}
+	return f.URL
+}
+
+// Markdown is what to put in a post body to show the file
+func (f MediaFile) Markdown() string {
+	return fmt.Sprintf("![%s](%s)", f.Alt, f.URL)
+}
+
+func initMediaTable(db *sql.DB) error {
+	_, err := db.Exec(`
+	CREATE TABLE IF NOT EXISTS media (
+		id integer primary key,
+		name varchar(256) unique,
+		type varchar(64) default "",
+		size integer default 0,
+		width integer default 0,
+		height integer default 0,
+		sizes varchar(64) default "",
+		thumbnail varchar(1024) default "",
+		original varchar(1024) default "",
+		uploaded varchar(25));
+	`)
+	if err != nil {
+		logger.Errorf("Could not create media table: %v", err)
+	}
+	return err
+}
+
+// recordMedia adds (or updates) an upload in the media table
+func recordMedia(config Config, db *sql.DB, m Media, uploaded time.Time) error {
+	name := path.Base(m.URL)
+	var size int64
+	if info, err := os.Stat(filepath.Join(config.UploadsDir, name)); err == nil {
+		size = info.Size()
+	}
+
+	var sizes []string
+	for _, s := range m.Sizes {
+		sizes = append(sizes, strconv.Itoa(s))
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO media (
+			name, type, size, width, height, sizes, thumbnail, original,
+			uploaded
+		) VALUES (
+			?, ?, ?, ?, ?, ?, ?, ?, ?
+		) ON CONFLICT(name) DO UPDATE
+		SET
+			type=excluded.type,
+			size=excluded.size,
+			width=excluded.width,
+			height=excluded.height,
+			sizes=excluded.sizes,
+			thumbnail=excluded.thumbnail,
+			original=excluded.original,
+			uploaded=excluded.uploaded
+	`, name, m.Type, size, m.Width, m.Height, strings.Join(sizes, ","),
+		m.Thumbnail, m.Original, uploaded.UTC().Format(time.RFC3339))
+	return err
+}
+
+func queryMediaFiles(config Config, db *sql.DB, where string, args ...interface{}) ([]MediaFile, error) {
+	rows, err := db.Query(`
+		SELECT id, name, type, size, width, height, sizes, thumbnail,
+			original, uploaded
+		FROM media
+		WHERE `+where+`
+		ORDER BY datetime(uploaded) DESC, id DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []MediaFile
+	for rows.Next() {
+		var f MediaFile
+		var sizes, uploaded string
+		err := rows.Scan(
+			&f.ID, &f.Name, &f.Type, &f.Size, &f.Width, &f.Height, &sizes,
+			&f.Thumbnail, &f.Original, &uploaded)
+		if err != nil {
+			logger.Error(err)
+			continue
+		}
+		f.URL = strings.Join([]string{config.Blog.Url, "uploads", f.Name}, "/")
+		for _, s := range strings.Split(sizes, ",") {
+			if size, err := strconv.Atoi(s); err == nil {
+				f.Sizes = append(f.Sizes, size)
+			}
+		}
+		f.Uploaded, _ = time.Parse(time.RFC3339, uploaded)
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
+
+// GetMediaFiles lists the uploads, newest first
+func GetMediaFiles(config Config, db *sql.DB) []MediaFile {
+	files, err := queryMediaFiles(config, db, "1")
+	if err != nil {
+		logger.Errorf("Could not load media: %v", err)
+	}
+	return files
+}
+
+func getMediaFile(config Config, db *sql.DB, name string) (*MediaFile, error) {
+	files, err := queryMediaFiles(config, db, "name = ?", name)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("No such file: %s", name)
+	}
+	return &files[0], nil
+}
+
+var variantRegex = regexp.MustCompile(`^(.+)-(\d+|thumb)(\.[^.]*)$`)
+
+/*
+syncMedia records the files in the uploads dir that aren't in the
+media table (but not the sizes processImage made of them), and drops
+the ones that are gone.
+*/
+func syncMedia(config Config, db *sql.DB) {
+	infos, err := ioutil.ReadDir(config.UploadsDir)
+	if err != nil {
+		logger.Errorf("Could not list uploads: %v", err)
+		return
+	}
+	present := make(map[string]bool)
+	for _, info := range infos {
+		if !info.IsDir() {
+			present[info.Name()] = true
+		}
+	}
+
+	known := make(map[string]bool)
+	for _, f := range GetMediaFiles(config, db) {
+		known[f.Name] = true
+		if !present[f.Name] {
+			logger.Infof("Upload %s is gone, removing it from the library", f.Name)
+			db.Exec(`DELETE FROM media WHERE id = ?`, f.ID)
+		}
+	}
+
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || known[name] || strings.HasPrefix(name, ".") {
+			continue
+		}
+		if m := variantRegex.FindStringSubmatch(name); m != nil && present[m[1]+m[3]] {
+			continue
+		}
+
+		content, err := ioutil.ReadFile(filepath.Join(config.UploadsDir, name))
+		if err != nil {
+			logger.Errorf("Could not read upload %s: %v", name, err)
+			continue
+		}
+		url := strings.Join([]string{config.Blog.Url, "uploads", name}, "/")
+		m := Media{URL: url, Type: http.DetectContentType(content)}
+		err = recordMedia(config, db, m, info.ModTime())
+		if err != nil {
+			logger.Errorf("Could not record upload %s: %v", name, err)
+		}
+	}
+}
+
+// uploadRefRegex matches mentions of the upload (or original) name in posts
+func uploadRefRegex(name string) *regexp.Regexp {
+	return regexp.MustCompile(
+		`/(uploads|originals)/` + regexp.QuoteMeta(name) + `([^\w.-]|$)`)
+}
+
+// mediaReferences finds the posts using any of the upload names
+func mediaReferences(db *sql.DB, names ...string) []*Post {
+	var conds []string
+	var args []interface{}
+	var refs []*regexp.Regexp
+	for _, name := range names {
+		conds = append(conds, "body like ? OR frontmatter like ?")
+		args = append(args, "%/uploads/"+name+"%", "%/uploads/"+name+"%")
+		refs = append(refs, uploadRefRegex(name))
+	}
+	if len(conds) == 0 {
+		return nil
+	}
+
+	rows, err := db.Query(`
+		SELECT id, title, slug, postdate, tags, frontmatter, body
+		FROM posts
+		WHERE `+strings.Join(conds, " OR ")+`
+		ORDER BY datetime(postdate) DESC, id DESC`, args...)
+	if err != nil {
+		logger.Errorf("Could not find posts using %s: %v", names[0], err)
+		return nil
+	}
+	defer rows.Close()
+
+	var posts []*Post
+	for _, post := range rowsToPosts(rows) {
+		frontMatter := post.FrontMatterYAML()
+		for _, ref := range refs {
+			if ref.MatchString(post.Body) || ref.MatchString(frontMatter) {
+				posts = append(posts, post)
+				break
+			}
+		}
+	}
+	return posts
+}
+
+// variantNames are the names of the upload f and the sizes made of it
+func variantNames(f *MediaFile) []string {
+	names := []string{f.Name}
+	for _, size := range f.Sizes {
+		names = append(names, variantName(f.Name, strconv.Itoa(size)))
+	}
+	if f.Thumbnail != "" {
+		names = append(names, variantName(f.Name, "thumb"))
+	}
+	return names
+}
+
+// mediaFiles is every file kept for f: the upload, its sizes and original
+func mediaFiles(config Config, f *MediaFile) []string {
+	var paths []string
+	for _, name := range variantNames(f) {
+		paths = append(paths, filepath.Join(config.UploadsDir, name))
+	}
+	if f.Original != "" {
+		paths = append(paths, filepath.Join(config.originalsDir(), f.Name))
+	}
+	return paths
+}
+
+/*
+renameMedia renames an upload, with its sizes and original, and fixes
+the posts using it.
+*/
+func renameMedia(config Config, db *sql.DB, repo PostsRepo, name string, newName string) error {
+	newName = filepath.Base(filepath.Clean("/" + strings.TrimSpace(newName)))
+	if filepath.Ext(newName) == "" {
+		newName += filepath.Ext(name)
+	}
+	if newName == "/" || newName == filepath.Ext(name) || newName == name {
+		return fmt.Errorf("Not a new name: %q", newName)
+	}
+	if _, err := os.Stat(filepath.Join(config.UploadsDir, newName)); err == nil {
+		return fmt.Errorf("There's already an upload called %s", newName)
+	}
+
+	f, err := getMediaFile(config, db, name)
+	if err != nil {
+		return err
+	}
+	renamed := *f
+	renamed.Name = newName
+	oldPaths, newPaths := mediaFiles(config, f), mediaFiles(config, &renamed)
+	for i := range oldPaths {
+		err := os.Rename(oldPaths[i], newPaths[i])
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Could not rename %s: %v", filepath.Base(oldPaths[i]), err)
+		}
+	}
+
+	if f.Thumbnail != "" {
+		f.Thumbnail = variantName(
+			strings.Join([]string{config.Blog.Url, "uploads", newName}, "/"), "thumb")
+	}
+	if f.Original != "" {
+		f.Original = ORIGINALSPATH + "/" + newName
+	}
+	_, err = db.Exec(`UPDATE media SET name=?, thumbnail=?, original=? WHERE id=?`,
+		newName, f.Thumbnail, f.Original, f.ID)
+	if err != nil {
+		return err
+	}
+
+	oldNames, newNames := variantNames(f), variantNames(&renamed)
+	fix := func(s string) string {
+		for i := range oldNames {
+			s = uploadRefRegex(oldNames[i]).ReplaceAllString(
+				s, "/${1}/"+strings.Replace(newNames[i], "$", "$$", -1)+"${2}")
+		}
+		return s
+	}
+	for _, post := range mediaReferences(db, oldNames...) {
+		post.Body = fix(post.Body)
+		for i := range post.Media {
+			post.Media[i].URL = fix(post.Media[i].URL)
+			post.Media[i].Thumbnail = fix(post.Media[i].Thumbnail)
+			post.Media[i].Original = fix(post.Media[i].Original)
+		}
+		_, err = storePost(db, repo, post, false)
+		if err != nil {
+			logger.Errorf("Could not update %s for renamed %s: %v", post.Slug, name, err)
+		}
+	}
+	return nil
+}
+
+/*
+deleteMedia removes an upload with its sizes and original, unless a
+post still uses it or one of its sizes.
+*/
+func deleteMedia(config Config, db *sql.DB, name string) error {
+	f, err := getMediaFile(config, db, name)
+	if err != nil {
+		return err
+	}
+	if posts := mediaReferences(db, variantNames(f)...); len(posts) > 0 {
+		var titles []string
+		for _, post := range posts {
+			title := post.Title
+			if title == "" {
+				title = post.Slug
+			}
+			titles = append(titles, title)
+		}
+		return fmt.Errorf("%s is used by %s", name, strings.Join(titles, ", "))
+	}
+
+	for _, p := range mediaFiles(config, f) {
+		err := os.Remove(p)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Could not delete %s: %v", filepath.Base(p), err)
+		}
+	}
+	_, err = db.Exec(`DELETE FROM media WHERE id = ?`, f.ID)
+	return err
+}
+
+// CreateMediaLibraryFunc shows the owner their uploads and where they're used
+func CreateMediaLibraryFunc(config Config, db *sql.DB) http.HandlerFunc {
+	logger.Debug("Creating media library handler")
+	initMediaTable(db)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !checkIsOwner(config, r) {
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+
+		syncMedia(config, db)
+		files := GetMediaFiles(config, db)
+		for i := range files {
+			files[i].Posts = mediaReferences(db, variantNames(&files[i])...)
+		}
+
+		t, err := getTemplate(config.TemplatesDir, "media.html")
+		if err != nil {
+			logger.Errorf("Could not get template: %v", err)
+			http.Error(w, "Could not render the media library", http.StatusInternalServerError)
+			return
+		}
+
+		flash, _ := GetFlash(w, r, "flash")
+
+		err = t.ExecuteTemplate(w, "base", struct {
+			Config  Config
+			IsOwner bool
+			Flash   string
+			Files   []MediaFile
+		}{
+			Config:  config,
+			IsOwner: true,
+			Flash:   flash,
+			Files:   files,
+		})
+		if err != nil {
+			logger.Errorf("Could not render media library: %v", err)
+		}
+	}
+}
+
+// CreateRenameMediaFunc renames the upload `name` to `newname`
+func CreateRenameMediaFunc(config Config, db *sql.DB, repo PostsRepo) http.HandlerFunc {
+	logger.Debug("Creating rename media handler")
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || !checkIsOwner(config, r) {
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+
+		name := r.PostFormValue("name")
+		err := renameMedia(config, db, repo, name, r.PostFormValue("newname"))
+		if err != nil {
+			logger.Errorf("Could not rename %s: %v", name, err)
+			SetFlash(w, "flash", err.Error())
+		} else {
+			SetFlash(w, "flash", fmt.Sprintf("Renamed %s", name))
+		}
+		http.Redirect(w, r, MEDIAPATH, http.StatusSeeOther)
+	}
+}
+
+// CreateDeleteMediaFunc deletes the upload `name` if no post uses it
+func CreateDeleteMediaFunc(config Config, db *sql.DB) http.HandlerFunc {
+	logger.Debug("Creating delete media handler")
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || !checkIsOwner(config, r) {
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+
+		name := r.PostFormValue("name")
+		err := deleteMedia(config, db, name)
+		if err != nil {
+			logger.Errorf("Could not delete %s: %v", name, err)
+			SetFlash(w, "flash", err.Error())
+		} else {
+			SetFlash(w, "flash", fmt.Sprintf("Deleted %s", name))
+		}
+		http.Redirect(w, r, MEDIAPATH, http.StatusSeeOther)
+	}
+}
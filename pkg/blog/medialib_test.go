@@ -0,0 +1,151 @@
+package blog
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMediaLibrary(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goldfrog-media")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.Nil(t, initDb(testDb))
+	defer os.Remove(testDb)
+	db, _ := GetDb(testDb)
+
+	var config Config
+	config.Blog.Url = "https://example.com"
+	config.UploadsDir = filepath.Join(dir, "uploads")
+	os.Mkdir(config.UploadsDir, 0755)
+
+	m, err := processImage(config, "cat.jpg", testJPEG(t, 1000, 500, 1))
+	assert.Nil(t, err)
+	assert.Nil(t, recordMedia(config, db, m, time.Now()))
+	// copied in by hand
+	ioutil.WriteFile(filepath.Join(config.UploadsDir, "notes.txt"), []byte("notes"), 0644)
+
+	post := NewPost(PostOpts{
+		Title: "Cats",
+		Slug:  "cats",
+		Body:  "Look: ![](https://example.com/uploads/cat.jpg)",
+	})
+	assert.Nil(t, CreatePost(db, &post))
+	other := NewPost(PostOpts{Slug: "dogs", Body: "Not /uploads/cat.jpg.bak"})
+	assert.Nil(t, CreatePost(db, &other))
+
+	syncMedia(config, db)
+	files := GetMediaFiles(config, db)
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name)
+	}
+	assert.ElementsMatch(t, []string{"cat.jpg", "notes.txt"}, names)
+
+	cat, err := getMediaFile(config, db, "cat.jpg")
+	if assert.Nil(t, err) {
+		assert.Equal(t, []int{480, 960}, cat.Sizes)
+		assert.Equal(t, "https://example.com/uploads/cat-thumb.jpg", cat.Preview())
+		assert.Equal(t, "![](https://example.com/uploads/cat.jpg)", cat.Markdown())
+		assert.True(t, cat.Size > 0)
+	}
+
+	refs := mediaReferences(db, "cat.jpg")
+	if assert.Equal(t, 1, len(refs)) {
+		assert.Equal(t, "cats", refs[0].Slug)
+	}
+
+	err = deleteMedia(config, db, "cat.jpg")
+	assert.EqualError(t, err, "cat.jpg is used by Cats")
+
+	assert.NotNil(t, renameMedia(config, db, &NullPostsRepo{}, "cat.jpg", "notes.txt"))
+	assert.Nil(t, renameMedia(config, db, &NullPostsRepo{}, "cat.jpg", "kitten"))
+	for _, name := range []string{"kitten.jpg", "kitten-480.jpg", "kitten-thumb.jpg"} {
+		_, err := os.Stat(filepath.Join(config.UploadsDir, name))
+		assert.Nil(t, err, name)
+	}
+	_, err = os.Stat(filepath.Join(dir, "originals", "kitten.jpg"))
+	assert.Nil(t, err)
+
+	renamed, _ := GetPostBySlug(db, "cats")
+	assert.Equal(t, "Look: ![](https://example.com/uploads/kitten.jpg)", renamed.Body)
+	untouched, _ := GetPostBySlug(db, "dogs")
+	assert.Equal(t, "Not /uploads/cat.jpg.bak", untouched.Body)
+
+	assert.Nil(t, DeletePost(db, "1"))
+	// a post using one of the sizes keeps them all
+	small := NewPost(PostOpts{
+		Title: "Small cats",
+		Slug:  "small-cats",
+		Body:  "![](https://example.com/uploads/kitten-480.jpg)",
+	})
+	assert.Nil(t, CreatePost(db, &small))
+	err = deleteMedia(config, db, "kitten.jpg")
+	assert.EqualError(t, err, "kitten.jpg is used by Small cats")
+
+	stored, _ := GetPostBySlug(db, "small-cats")
+	assert.Nil(t, DeletePost(db, strconv.Itoa(stored.ID)))
+	assert.Nil(t, deleteMedia(config, db, "kitten.jpg"))
+	left, _ := ioutil.ReadDir(config.UploadsDir)
+	if assert.Equal(t, 1, len(left)) {
+		assert.Equal(t, "notes.txt", left[0].Name())
+	}
+}
+
+func TestMediaHandlers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goldfrog-media")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.Nil(t, initDb(testDb))
+	defer os.Remove(testDb)
+	db, _ := GetDb(testDb)
+
+	var config Config
+	config.UploadsDir = filepath.Join(dir, "uploads")
+	config.TemplatesDir = filepath.Join(dir, "templates")
+	config.Signin.Username = "me"
+	config.Signin.Password = "pw"
+	os.MkdirAll(filepath.Join(config.TemplatesDir, "base"), 0755)
+	os.Mkdir(config.UploadsDir, 0755)
+	ioutil.WriteFile(filepath.Join(config.TemplatesDir, "base", "base.html"),
+		[]byte(`{{define "base"}}{{range .Files}}{{.Name}} {{len .Posts}};{{end}}{{end}}`), 0644)
+	ioutil.WriteFile(filepath.Join(config.TemplatesDir, "media.html"), []byte(""), 0644)
+	ioutil.WriteFile(filepath.Join(config.UploadsDir, "a.gif"), []byte("GIF89a"), 0644)
+	cookie := &http.Cookie{Name: "goldfrog", Value: hashAccount("me", "pw")}
+
+	library := CreateMediaLibraryFunc(config, db)
+
+	req := httptest.NewRequest("GET", "/media", nil)
+	rr := httptest.NewRecorder()
+	library(rr, req)
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+
+	req.AddCookie(cookie)
+	rr = httptest.NewRecorder()
+	library(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "a.gif 0;")
+
+	form := url.Values{"name": {"a.gif"}}
+	req = httptest.NewRequest("POST", "/media/delete", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(cookie)
+	rr = httptest.NewRecorder()
+	CreateDeleteMediaFunc(config, db)(rr, req)
+	assert.Equal(t, MEDIAPATH, rr.Header().Get("Location"))
+
+	_, err = os.Stat(filepath.Join(config.UploadsDir, "a.gif"))
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, 0, len(GetMediaFiles(config, db)))
+}
@@ -0,0 +1,268 @@
+package blog
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/araddon/dateparse"
+	"github.com/sivy/goldfrog/pkg/syndication"
+)
+
+/*
+The save path shared by the post forms and the API: build the post,
+store it (file first, then db), syndicate it and store what the
+syndicators returned.
+*/
+
+// makeSlug picks the slug for a post: notes get a hash of their body
+func makeSlug(title string, slug string, body string) string {
+	if title == "" {
+		slug = MakeNoteSlug(body)
+	}
+
+	if slug == "" {
+		slug = MakePostSlug(title)
+	}
+	return slug
+}
+
+/*
+parsePostDate reads a date given in the author's timezone and returns
+it in UTC for storage; an empty or unparseable date means now.
+*/
+func parsePostDate(date string, tz *time.Location) time.Time {
+	var postDate time.Time
+	if date != "" {
+		// the date is provided in the author's timezone
+		var err error
+		postDate, err = dateparse.ParseIn(date, tz)
+		if err != nil {
+			logger.Warnf("Could not parse date: %s", date)
+			date = ""
+		}
+		// then convert to UTC for storage
+		postDate = postDate.UTC()
+	}
+	if date == "" {
+		// server is already in UTC
+		postDate = time.Now()
+	}
+	return postDate
+}
+
+/*
+storePost saves post to its file and to the db (creating it if isNew)
+and returns it freshly loaded from the db, with its ID.
+*/
+func storePost(db *sql.DB, repo PostsRepo, post *Post, isNew bool) (*Post, error) {
+	err := repo.SavePostFile(post)
+	if err != nil {
+		logger.Errorf("Could not save post file: %v", err)
+		return nil, fmt.Errorf("Could not save post file: %v", err)
+	}
+
+	if isNew {
+		err = CreatePost(db, post)
+	} else {
+		err = SavePost(db, post)
+	}
+	if err != nil {
+		logger.Errorf("Could not save post: %v", err)
+		return nil, fmt.Errorf("Could not save post: %v", err)
+	}
+
+	stored, err := GetPostBySlug(db, post.Slug)
+	if err == nil {
+		err := indexPostLinks(db, stored)
+		if err != nil {
+			logger.Errorf("Could not index links of %s: %v", stored.Slug, err)
+		}
+		forgetBacklinks(db, stored.Slug)
+		prerenderDiagrams(stored)
+		fetchReplyContext(stored)
+		fetchLinkPreviews(stored)
+	}
+	return stored, err
+}
+
+/*
+syndicatePost sends a stored post, with its uploaded images, to the
+syndication targets in includeHooks (and webmentions, if enabled),
+saves the ids, links and
+status of each target into the post's front matter and queues retries
+for the targets that failed.
+*/
+func syndicatePost(
+	config Config, db *sql.DB, repo PostsRepo, post *Post,
+	includeHooks map[string]bool) ([]syndication.Result, error) {
+
+	results, err := sendToTargets(
+		config, db, repo, post, includeHooks, config.WebMentionEnabled)
+	queueFailedSyndications(db, post, results)
+	return results, err
+}
+
+// sendToTargets does the sending and saving for syndicatePost
+func sendToTargets(
+	config Config, db *sql.DB, repo PostsRepo, post *Post,
+	includeHooks map[string]bool, webmentions bool) ([]syndication.Result, error) {
+
+	targets := config.SyndicationTargets()
+	hooks := make(map[string]bool)
+	for _, target := range targets {
+		if includeHooks[target.Name] {
+			hooks[target.Name] = true
+		}
+	}
+
+	if webmentions {
+		targets = append(targets, syndication.Target{
+			Name: "webmention",
+			Type: "webmention",
+		})
+		hooks["webmention"] = true
+	}
+
+	postData := makePostData(config, post)
+	postData.Media = postMedia(config, post)
+	// syndication carries on even if the author's request goes away
+	results := syndication.Syndicate(
+		context.Background(), targets, hooks, postData)
+
+	applyResults(post, results)
+
+	err := SavePost(db, post)
+	if err != nil {
+		logger.Error(err)
+		return results, fmt.Errorf(
+			"Your post was saved, but some syndication links might be missing (%v)",
+			err)
+	}
+
+	err = repo.SavePostFile(post)
+	if err != nil {
+		logger.Error(err)
+		return results, fmt.Errorf(
+			"Your post was saved, but some syndication links might be missing on disk (%v)",
+			err)
+	}
+	return results, nil
+}
+
+// makePostData copies what the syndicators need from post
+func makePostData(config Config, post *Post) syndication.PostData {
+	// don't depend on updating a reference to a Post
+	return syndication.PostData{
+		Title:       post.Title,
+		Slug:        post.Slug,
+		PostDate:    post.PostDate,
+		Tags:        post.Tags,
+		Body:        post.Body,
+		FrontMatter: post.FrontMatter,
+		PermaLink:   config.Blog.Url + post.PermaLink(),
+		SiteName:    config.Blog.Title,
+	}
+}
+
+// applyResults stores the outcome of each target in post's front matter
+func applyResults(post *Post, results []syndication.Result) {
+	syndicationMeta := syndication.ResultsMeta(results)
+	logger.Debugf("new meta after hooks: %v", syndicationMeta)
+
+	if post.FrontMatter == nil {
+		post.FrontMatter = make(map[string]string)
+	}
+	for _, result := range results {
+		if result.Error == "" {
+			delete(post.FrontMatter, result.Target+"_error")
+		}
+	}
+	for k, v := range syndicationMeta {
+		post.FrontMatter[k] = v
+	}
+}
+
+// syndicatedIDs maps each target post was sent to to the id of its copy there
+func syndicatedIDs(config Config, post *Post) map[string]string {
+	ids := make(map[string]string)
+	for _, target := range config.SyndicationTargets() {
+		if id := post.FrontMatter[target.Name+"_id"]; id != "" {
+			ids[target.Name] = id
+		}
+	}
+	return ids
+}
+
+/*
+propagateUpdate edits the copies of post on the targets it was already
+sent to (those that support editing, like Mastodon) and records the
+outcome in its front matter; the caller saves it.
+*/
+func propagateUpdate(config Config, post *Post) []syndication.Result {
+	ids := syndicatedIDs(config, post)
+	if len(ids) == 0 {
+		return nil
+	}
+	results := syndication.UpdateSyndicated(
+		context.Background(), config.SyndicationTargets(), ids,
+		makePostData(config, post))
+	applyResults(post, results)
+	return results
+}
+
+// propagateDelete deletes the copies of post on the targets it was sent to
+func propagateDelete(config Config, post *Post) []syndication.Result {
+	ids := syndicatedIDs(config, post)
+	if len(ids) == 0 {
+		return nil
+	}
+	return syndication.DeleteSyndicated(
+		context.Background(), config.SyndicationTargets(), ids,
+		post.FrontMatter)
+}
+
+/*
+syndicationSummary describes the results of syndicating a post for
+the flash message, e.g. "Posted to mastodon; bluesky failed: timeout".
+*/
+func syndicationSummary(results []syndication.Result) string {
+	var parts []string
+	for _, result := range results {
+		switch {
+		case result.Status == syndication.StatusFailed:
+			parts = append(parts, fmt.Sprintf(
+				"%s failed: %s", result.Target, result.Error))
+		case result.Status == syndication.StatusUnknown:
+			parts = append(parts, fmt.Sprintf(
+				"%s didn't answer in time, check there before sending it again",
+				result.Target))
+		case result.Status == syndication.StatusUpdated:
+			parts = append(parts, fmt.Sprintf("Updated on %s", result.Target))
+		case result.Status == syndication.StatusDeleted:
+			parts = append(parts, fmt.Sprintf("Deleted from %s", result.Target))
+		case result.URL != "":
+			parts = append(parts, fmt.Sprintf(
+				"Posted to %s (%s)", result.Target, result.URL))
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+// removePost deletes post from the db and from disk
+func removePost(db *sql.DB, repo PostsRepo, post *Post) error {
+	err := DeletePost(db, strconv.Itoa(post.ID))
+	if err != nil {
+		logger.Errorf("Could not delete post: %v", err)
+		return fmt.Errorf("Could not delete post: %v", err)
+	}
+
+	err = repo.DeletePostFile(post)
+	if err != nil {
+		logger.Errorf("Could not delete post file: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package blog
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"html/template"
+	"sync"
+)
+
+const (
+	renderCacheMaxEntries int = 2000
+)
+
+/*
+renderCache keeps rendered markdown keyed by a hash of the source, so
+list pages don't render every post body on every request. Post bodies
+are also tracked by post ID so that saving or deleting a post drops
+its rendering right away instead of leaving it to age out.
+*/
+type renderCache struct {
+	mu      sync.RWMutex
+	entries map[string]template.HTML
+	posts   map[int]string // post ID -> hash of its last rendered body
+	render  func(string) template.HTML
+}
+
+var renderedMarkdown = newRenderCache(renderMarkdown)
+
+func newRenderCache(render func(string) template.HTML) *renderCache {
+	return &renderCache{
+		entries: make(map[string]template.HTML),
+		posts:   make(map[int]string),
+		render:  render,
+	}
+}
+
+func contentHash(content string) string {
+	return fmt.Sprintf("%x", sha1.Sum([]byte(content)))
+}
+
+// Get returns the rendering of content, rendering it if needed
+func (c *renderCache) Get(content string) template.HTML {
+	hash := contentHash(content)
+
+	c.mu.RLock()
+	html, ok := c.entries[hash]
+	c.mu.RUnlock()
+	if ok {
+		return html
+	}
+
+	html = c.render(content)
+
+	c.mu.Lock()
+	if len(c.entries) >= renderCacheMaxEntries {
+		// crude, but the cache fills right back up with what's in use
+		c.entries = make(map[string]template.HTML)
+		c.posts = make(map[int]string)
+	}
+	c.entries[hash] = html
+	c.mu.Unlock()
+
+	return html
+}
+
+// GetPost returns the rendered body of a post
+func (c *renderCache) GetPost(post *Post) template.HTML {
+	html := c.Get(post.Body)
+
+	hash := contentHash(post.Body)
+	c.mu.Lock()
+	if old, ok := c.posts[post.ID]; ok && old != hash {
+		delete(c.entries, old)
+	}
+	c.posts[post.ID] = hash
+	c.mu.Unlock()
+
+	return html
+}
+
+// Invalidate drops the rendered body of the post with postID
+func (c *renderCache) Invalidate(postID int) {
+	c.mu.Lock()
+	if hash, ok := c.posts[postID]; ok {
+		delete(c.entries, hash)
+		delete(c.posts, postID)
+	}
+	c.mu.Unlock()
+}
+
+// Reset drops every rendering, when the renderer changes
+func (c *renderCache) Reset() {
+	c.mu.Lock()
+	c.entries = make(map[string]template.HTML)
+	c.posts = make(map[int]string)
+	c.mu.Unlock()
+}
+
+// Forget drops the rendering of content
+func (c *renderCache) Forget(content string) {
+	c.mu.Lock()
+	delete(c.entries, contentHash(content))
+	c.mu.Unlock()
+}
@@ -0,0 +1,39 @@
+package blog
+
+import (
+	"html/template"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderCache(t *testing.T) {
+	renders := 0
+	cache := newRenderCache(func(s string) template.HTML {
+		renders++
+		return renderMarkdown(s)
+	})
+
+	out := cache.Get("*foo*")
+	assert.Contains(t, string(out), "<em>foo</em>")
+	cache.Get("*foo*")
+	assert.Equal(t, 1, renders)
+
+	post := NewPost(PostOpts{Body: "*bar*"})
+	post.ID = 1
+	cache.GetPost(&post)
+	cache.GetPost(&post)
+	assert.Equal(t, 2, renders)
+
+	// saving a post drops its rendering
+	cache.Invalidate(post.ID)
+	cache.GetPost(&post)
+	assert.Equal(t, 3, renders)
+
+	// and so does rendering a new body for it
+	post.Body = "*baz*"
+	out = cache.GetPost(&post)
+	assert.Contains(t, string(out), "<em>baz</em>")
+	assert.Equal(t, 4, renders)
+	assert.Equal(t, 2, len(cache.entries))
+}
@@ -0,0 +1,115 @@
+package blog
+
+import (
+	"database/sql"
+	"fmt"
+	"html/template"
+	"net/url"
+	"strings"
+
+	"github.com/sivy/goldfrog/pkg/webmention"
+)
+
+/*
+Posts responding to a page elsewhere show some of it: its title,
+author and an excerpt, fetched in the background when the post is
+saved and kept in the reply_contexts table. The `replycontext`
+template function renders them from there, so pages never wait on the
+network.
+*/
+
+var replyContexts *webmention.ContextCache
+
+func initReplyContexts(db *sql.DB) {
+	cache, err := webmention.NewContextCache(db)
+	if err != nil {
+		logger.Errorf("Could not set up reply contexts: %v", err)
+		return
+	}
+	replyContexts = cache
+}
+
+// fetchReplyContext fetches the context of the page post responds to
+func fetchReplyContext(post *Post) {
+	if replyContexts == nil || post.KindTarget() == "" {
+		return
+	}
+	replyContexts.RefreshLater(post.KindTarget())
+}
+
+// httpURL is s if it's an http(s) url, "" if it's anything else
+func httpURL(s string) string {
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ""
+	}
+	return u.String()
+}
+
+/*
+replyContexter renders the page post responds to as an h-cite, with as
+much of it as has been fetched. Its urls come from other sites, so
+only http(s) ones are linked.
+*/
+func replyContexter(post *Post) template.HTML {
+	target := post.KindTarget()
+	if target == "" {
+		return ""
+	}
+
+	var property string
+	for _, kp := range kindProperties {
+		if kp.Kind == post.Kind() {
+			property = kp.Property
+		}
+	}
+
+	context := &webmention.Context{URL: target}
+	if replyContexts != nil {
+		if cached, ok := replyContexts.Get(target); ok {
+			context = cached
+		}
+		// pick up contexts that failed or have gone stale
+		replyContexts.RefreshLater(target)
+	}
+
+	esc := template.HTMLEscapeString
+	var parts []string
+	if context.AuthorName != "" {
+		author := esc(context.AuthorName)
+		if authorURL := httpURL(context.AuthorURL); authorURL != "" {
+			author = fmt.Sprintf(`<a class="p-name u-url" href="%s">%s</a>`,
+				esc(authorURL), author)
+		} else {
+			author = fmt.Sprintf(`<span class="p-name">%s</span>`, author)
+		}
+		if photo := httpURL(context.AuthorPhoto); photo != "" {
+			author = fmt.Sprintf(`<img class="u-photo" src="%s" alt="" loading="lazy"> `,
+				esc(photo)) + author
+		}
+		parts = append(parts, fmt.Sprintf(`<p class="p-author h-card">%s</p>`, author))
+	}
+
+	name := context.Name
+	if name == "" {
+		name = strings.TrimPrefix(strings.TrimPrefix(target, "https://"), "http://")
+	}
+	if link := httpURL(target); link != "" {
+		parts = append(parts, fmt.Sprintf(`<p><a class="u-url p-name" href="%s">%s</a></p>`,
+			esc(link), esc(name)))
+	} else {
+		parts = append(parts, fmt.Sprintf(`<p class="p-name">%s</p>`, esc(name)))
+	}
+
+	if context.Summary != "" {
+		parts = append(parts, fmt.Sprintf(`<p class="p-summary">%s</p>`, esc(context.Summary)))
+	}
+	if !context.Published.IsZero() {
+		parts = append(parts, fmt.Sprintf(`<time class="dt-published" datetime="%s">%s</time>`,
+			context.Published.Format("2006-01-02T15:04:05Z07:00"),
+			context.Published.Format("January 2, 2006")))
+	}
+
+	return template.HTML(fmt.Sprintf(`<div class="reply-context u-%s h-cite">%s</div>`,
+		property, strings.Join(parts, "")))
+}
@@ -0,0 +1,66 @@
+package blog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/sivy/goldfrog/pkg/webmention"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplyContexter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/evil" {
+			w.Write([]byte(`<div class="h-entry"><h1 class="p-name">Evil</h1>
+				<span class="p-author h-card"><a class="p-name u-url" href="javascript:alert(1)">Eve</a></span>
+				</div>`))
+			return
+		}
+		w.Write([]byte(`<div class="h-entry"><h1 class="p-name">Frogs</h1>
+			<span class="p-author h-card"><a class="p-name u-url" href="/">Ann</a></span>
+			<p class="e-content">All about frogs &amp; toads.</p></div>`))
+	}))
+	defer server.Close()
+
+	assert.Nil(t, initDb(testDb))
+	defer os.Remove(testDb)
+	db, _ := GetDb(testDb)
+
+	cache, err := webmention.NewContextCache(db)
+	assert.Nil(t, err)
+	replyContexts = cache
+	defer func() { replyContexts = nil }()
+
+	target := server.URL + "/frogs"
+	post := NewPost(PostOpts{
+		Body:        "Me too",
+		FrontMatter: map[string]string{"in-reply-to": target},
+	})
+	note := NewPost(PostOpts{Body: "hi"})
+	assert.Equal(t, "", string(replyContexter(&note)))
+
+	_, err = cache.Refresh(target)
+	assert.Nil(t, err)
+
+	html := string(replyContexter(&post))
+	assert.Contains(t, html, `<div class="reply-context u-in-reply-to h-cite">`)
+	assert.Contains(t, html, `<a class="p-name u-url" href="`+server.URL+`/">Ann</a>`)
+	assert.Contains(t, html, `<a class="u-url p-name" href="`+target+`">Frogs</a>`)
+	assert.Contains(t, html, `<p class="p-summary">All about frogs &amp; toads.</p>`)
+
+	// only http(s) urls are linked
+	evil := server.URL + "/evil"
+	_, err = cache.Refresh(evil)
+	assert.Nil(t, err)
+	post.FrontMatter["in-reply-to"] = evil
+	html = string(replyContexter(&post))
+	assert.Contains(t, html, `<span class="p-name">Eve</span>`)
+	assert.NotContains(t, html, "javascript:")
+
+	post.FrontMatter["in-reply-to"] = "javascript:alert(1)"
+	html = string(replyContexter(&post))
+	assert.Contains(t, html, `<p class="p-name">javascript:alert(1)</p>`)
+	assert.NotContains(t, html, "href")
+}
@@ -0,0 +1,99 @@
+package blog
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/go-chi/chi"
+)
+
+/*
+Routes sets up every page, feed and form goldfrog serves. goldfrogd
+mounts it behind its middleware.
+*/
+func Routes(config Config, db *sql.DB, repo PostsRepo, dbFile string) chi.Router {
+	r := chi.NewRouter()
+
+	r.Use(WebSubLinks(config))
+	mountPages(r, config, db)
+
+	r.Mount("/new", CreateNewPostFunc(config, db, repo))
+	r.Mount(
+		"/edit/{postID}",
+		CreateEditPostFunc(config, db, repo))
+	r.Mount(
+		"/edit",
+		CreateEditPostFunc(config, db, repo))
+	r.Mount("/delete", CreateDeletePostFunc(config, db, repo))
+	r.Mount("/syndicate", CreateSyndicatePostFunc(config, db, repo))
+	r.Mount("/syndicate/preview", CreateSyndicationPreviewFunc(config, db))
+	r.Mount(MEDIAPATH, CreateMediaLibraryFunc(config, db))
+	r.Mount(MEDIAPATH+"/rename", CreateRenameMediaFunc(config, db, repo))
+	r.Mount(MEDIAPATH+"/delete", CreateDeleteMediaFunc(config, db))
+
+	r.Mount(APIPREFIX, APIRoutes(config, db, repo))
+	r.Mount(MICROPUBPATH, CreateMicropubFunc(config, db, repo))
+
+	if config.WebSub.BuiltinHub {
+		hub, err := initWebSubHub(config, db)
+		if err != nil {
+			logger.Fatalf("Could not start WebSub hub: %v", err)
+		}
+		r.Mount(WEBSUBHUBPATH, hub)
+	}
+
+	r.Mount("/signin", CreateSigninPageFunc(config, dbFile))
+	r.Mount("/signout", CreateSignoutPageFunc(config, dbFile))
+
+	FileServer(r, "/static", http.Dir(config.StaticDir))
+	FileServer(r, "/uploads", http.Dir(config.UploadsDir))
+	r.Get(ORIGINALSPATH+"/{name}", CreateOriginalFunc(config))
+
+	return r
+}
+
+/*
+ReadOnlyRoutes serves only the public pages and feeds, without the
+forms, APIs or hub. The static exporter requests pages from it.
+*/
+func ReadOnlyRoutes(config Config, db *sql.DB) chi.Router {
+	r := chi.NewRouter()
+
+	r.Use(WebSubLinks(config))
+	mountPages(r, config, db)
+
+	return r
+}
+
+// mountPages adds the pages and feeds anyone can read to r
+func mountPages(r chi.Router, config Config, db *sql.DB) {
+	initMarkdown(config)
+	initReplyContexts(db)
+	initLinkPreviews(config, db)
+	initWikiLinks(db)
+
+	r.Mount("/", CreateIndexFunc(config, db))
+	// redirect for old permalinks
+	r.Mount("/{year}/{month}/{dayOrSlug}", CreateDailyPostsFunc(config, db))
+	r.Mount("/{year}/{month}/{day}/{slug}", CreatePostPageFunc(
+		config, db))
+	r.Mount("/archive", CreateArchiveYearMonthFunc(config, db))
+	r.Mount("/archive/{year}/{month}", CreateArchivePageFunc(config, db))
+	r.Mount("/tag/{tag}", CreateTagPageFunc(config, db))
+	r.Mount("/series/{series}", CreateSeriesPageFunc(config, db))
+	r.Mount("/feed.xml", CreateRssFunc(config, db))
+	r.Mount("/feed_daily.xml", CreateDailyRssFunc(config, db))
+	r.Mount("/tag/{tag}/feed.xml", CreateTagRssFunc(config, db))
+	r.Mount("/tag/{tag}/feed_daily.xml", CreateTagDailyRssFunc(config, db))
+	r.Mount("/series/{series}/feed.xml", CreateSeriesRssFunc(config, db))
+	r.Mount("/series/{series}/feed_daily.xml", CreateSeriesDailyRssFunc(config, db))
+	r.Mount("/author/{author}/feed.xml", CreateAuthorRssFunc(config, db))
+	r.Mount("/author/{author}/feed_daily.xml", CreateAuthorDailyRssFunc(config, db))
+	for _, kind := range PostKinds {
+		r.Mount(kindPath(kind)+"/feed.xml", CreateKindRssFunc(
+			config, db, kind))
+		r.Mount(kindPath(kind)+"/feed_daily.xml", CreateKindDailyRssFunc(
+			config, db, kind))
+	}
+	r.Mount("/search", CreateSearchPageFunc(config, db))
+}
@@ -0,0 +1,267 @@
+package blog
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+)
+
+/*
+Multi-part posts name their series in the front matter, with their
+place in it if the post dates don't give the right order:
+
+	series: Building a blog
+	series_order: 2
+
+Posts in a series have their parts listed at /series/{name}, with its
+feeds, and the post page gets the previous and next parts. The series
+is also kept in the posts table, so it can be queried.
+*/
+
+// Series is the name of the series the post is part of, if any
+func (post *Post) Series() string {
+	return strings.TrimSpace(post.FrontMatter["series"])
+}
+
+// SeriesOrder is the post's place in its series, 0 when it's by date
+func (post *Post) SeriesOrder() int {
+	order, err := strconv.Atoi(strings.TrimSpace(post.FrontMatter["series_order"]))
+	if err != nil || order < 0 {
+		return 0
+	}
+	return order
+}
+
+// seriesPath is the url of a series' page, feeds are under it
+func seriesPath(name string) string {
+	return "/series/" + url.PathEscape(name)
+}
+
+// migrateSeries adds the series columns and fills them in from front matter
+func migrateSeries(db *sql.DB) error {
+	logger.Info("Adding series to posts")
+	for _, column := range []string{
+		`series varchar(256) default ""`,
+		`series_order integer default 0`,
+	} {
+		_, err := db.Exec(`ALTER TABLE posts ADD COLUMN ` + column)
+		if err != nil {
+			return fmt.Errorf("Could not add series to posts: %v", err)
+		}
+	}
+
+	rows, err := db.Query(`
+		SELECT id, frontmatter FROM posts WHERE frontmatter like '%series%'`)
+	if err != nil {
+		return err
+	}
+	var posts []Post
+	for rows.Next() {
+		var post Post
+		var fmStr string
+		if err := rows.Scan(&post.ID, &fmStr); err == nil {
+			post.FrontMatter = GetFrontMatter(fmStr)
+			posts = append(posts, post)
+		}
+	}
+	rows.Close()
+
+	for _, post := range posts {
+		_, err = db.Exec(`UPDATE posts SET series = ?, series_order = ? WHERE id = ?`,
+			post.Series(), post.SeriesOrder(), post.ID)
+		if err != nil {
+			return fmt.Errorf("Could not set series of post %d: %v", post.ID, err)
+		}
+	}
+	return nil
+}
+
+/*
+GetSeriesPosts gets the posts in a series in order: the ones with a
+series_order by it, then the rest by date.
+*/
+func GetSeriesPosts(db *sql.DB, name string) []*Post {
+	rows, err := db.Query(`
+		SELECT id, title, slug, postdate, tags, frontmatter, body
+		FROM posts
+		WHERE series = ? COLLATE NOCASE
+		ORDER BY
+			series_order = 0,
+			series_order,
+			datetime(postdate),
+			id
+	`, name)
+	if err != nil {
+		logger.Errorf("Could not load series %s: %v", name, err)
+		return nil
+	}
+	defer rows.Close()
+	return rowsToPosts(rows)
+}
+
+// GetSeriesNames lists every series with posts in it
+func GetSeriesNames(db *sql.DB) []string {
+	rows, err := db.Query(`
+		SELECT DISTINCT series FROM posts WHERE series != "" ORDER BY series`)
+	if err != nil {
+		logger.Errorf("Could not load series: %v", err)
+		return nil
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err == nil {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// SeriesNav is a post's place in its series, for the post page
+type SeriesNav struct {
+	Name  string
+	Link  string
+	Posts []*Post
+	// Part is the post's place in Posts, from 1
+	Part int
+	Prev *Post
+	Next *Post
+}
+
+// getSeriesNav gets the series post is in, nil if it isn't in one
+func getSeriesNav(db *sql.DB, post *Post) *SeriesNav {
+	name := post.Series()
+	if name == "" {
+		return nil
+	}
+	nav := &SeriesNav{
+		Name:  name,
+		Link:  seriesPath(name),
+		Posts: GetSeriesPosts(db, name),
+	}
+	for i, p := range nav.Posts {
+		if p.ID != post.ID {
+			continue
+		}
+		nav.Part = i + 1
+		if i > 0 {
+			nav.Prev = nav.Posts[i-1]
+		}
+		if i < len(nav.Posts)-1 {
+			nav.Next = nav.Posts[i+1]
+		}
+	}
+	return nav
+}
+
+/*
+seriesParam is the series named in the url. seriesPath escapes a "/"
+in the name, and chi routes on the escaped path, so the param comes
+escaped too.
+*/
+func seriesParam(r *http.Request) string {
+	name := chi.URLParam(r, "series")
+	if unescaped, err := url.PathUnescape(name); err == nil {
+		return unescaped
+	}
+	return name
+}
+
+func seriesSlice(config Config) feedSlice {
+	return feedSlice{
+		Name:  "series",
+		Items: config.Feeds.SeriesItems,
+		Query: func(r *http.Request) (GetPostOpts, string, string) {
+			name := seriesParam(r)
+			return GetPostOpts{Series: name},
+				fmt.Sprintf("Posts in the series '%s'", name),
+				seriesPath(name)
+		},
+	}
+}
+
+// CreateSeriesRssFunc serves the posts in the `series` url param
+func CreateSeriesRssFunc(config Config, db *sql.DB) http.HandlerFunc {
+	logger.Debug("Creating series rss handler")
+	return createRssFunc(config, db, seriesSlice(config))
+}
+
+// CreateSeriesDailyRssFunc
+func CreateSeriesDailyRssFunc(config Config, db *sql.DB) http.HandlerFunc {
+	logger.Debug("Creating series daily rss handler")
+	return createDailyRssFunc(config, db, seriesSlice(config))
+}
+
+// CreateSeriesPageFunc lists the parts of the `series` url param in order
+func CreateSeriesPageFunc(config Config, db *sql.DB) http.HandlerFunc {
+	logger.Debug("Creating series page handler")
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger.Info("Serving series page...")
+
+		isOwner := checkIsOwner(config, r)
+
+		name := seriesParam(r)
+		posts := GetSeriesPosts(db, name)
+		if len(posts) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		// the name as the posts have it
+		name = posts[0].Series()
+
+		user := User{
+			DisplayName: config.Blog.Author.Name,
+			Email:       config.Blog.Author.Email,
+			Url:         config.Blog.Url,
+			Image:       config.Blog.Author.Image,
+			IsAdmin:     isOwner,
+		}
+
+		for _, p := range posts {
+			p.User = user
+		}
+
+		validator := postsValidator(config, db, r.URL.Path, posts)
+		if checkNotModified(w, r, config, validator, isOwner) {
+			return
+		}
+
+		t, err := getTemplate(config.TemplatesDir, "post_list.html")
+
+		if err != nil {
+			logger.Errorf("Could not parse template: %v", err)
+			w.WriteHeader(500)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		flash, _ := GetFlash(w, r, "flash")
+
+		err = t.ExecuteTemplate(w, "base", struct {
+			Posts   []*Post
+			Config  Config
+			Title   string
+			Series  string
+			IsOwner bool
+			Flash   string
+		}{
+			Posts:   posts,
+			Config:  config,
+			Title:   fmt.Sprintf("Series '%s'", name),
+			Series:  name,
+			IsOwner: isOwner,
+			Flash:   flash,
+		})
+
+		if err != nil {
+			logger.Warnf("Error rendering: %v", err)
+		}
+	}
+}
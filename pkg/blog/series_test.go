@@ -0,0 +1,172 @@
+package blog
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeries(t *testing.T) {
+	assert.Nil(t, initDb(testDb))
+	defer os.Remove(testDb)
+	db, _ := GetDb(testDb)
+
+	date := time.Date(2020, 1, 2, 12, 0, 0, 0, time.UTC)
+	for i, p := range []Post{
+		// part 2 is the oldest, but it says where it goes
+		NewPost(PostOpts{Title: "Two", Slug: "two", PostDate: date,
+			FrontMatter: map[string]string{"series": "Go Tour", "series_order": "2"}}),
+		NewPost(PostOpts{Title: "One", Slug: "one", PostDate: date.Add(time.Hour),
+			FrontMatter: map[string]string{"series": "Go Tour", "series_order": "1"}}),
+		NewPost(PostOpts{Title: "Extra", Slug: "extra", PostDate: date.Add(2 * time.Hour),
+			FrontMatter: map[string]string{"series": "go tour"}}),
+		NewPost(PostOpts{Title: "Alone", Slug: "alone", PostDate: date}),
+	} {
+		p := p
+		assert.Nil(t, CreatePost(db, &p), i)
+	}
+
+	slugs := func(posts []*Post) []string {
+		var slugs []string
+		for _, post := range posts {
+			slugs = append(slugs, post.Slug)
+		}
+		return slugs
+	}
+	assert.Equal(t, []string{"one", "two", "extra"}, slugs(GetSeriesPosts(db, "Go Tour")))
+	assert.Equal(t, []string{"extra", "one", "two"},
+		slugs(GetPosts(db, GetPostOpts{Series: "go tour"})))
+	assert.Equal(t, []string{"Go Tour", "go tour"}, GetSeriesNames(db))
+
+	two, _ := GetPostBySlug(db, "two")
+	nav := getSeriesNav(db, two)
+	assert.Equal(t, "Go Tour", nav.Name)
+	assert.Equal(t, "/series/Go%20Tour", nav.Link)
+	assert.Equal(t, 2, nav.Part)
+	assert.Equal(t, "one", nav.Prev.Slug)
+	assert.Equal(t, "extra", nav.Next.Slug)
+
+	one, _ := GetPostBySlug(db, "one")
+	nav = getSeriesNav(db, one)
+	assert.Nil(t, nav.Prev)
+	assert.Equal(t, "two", nav.Next.Slug)
+
+	alone, _ := GetPostBySlug(db, "alone")
+	assert.Nil(t, getSeriesNav(db, alone))
+
+	// leaving the series
+	delete(two.FrontMatter, "series")
+	assert.Nil(t, SavePost(db, two))
+	assert.Equal(t, []string{"one", "extra"}, slugs(GetSeriesPosts(db, "Go Tour")))
+}
+
+func TestMigrateSeries(t *testing.T) {
+	os.Remove(testDb)
+	db, _ := GetDb(testDb)
+	defer os.Remove(testDb)
+
+	// a posts table from before there were series
+	_, err := db.Exec(`CREATE TABLE posts (
+		id integer primary key,
+		title varchar(1024) default "",
+		slug varchar(256) unique,
+		postdate varchar(25),
+		tags varchar(1024),
+		frontmatter text default "",
+		body text default "",
+		format varchar(15),
+		kind varchar(15) default "");
+	INSERT INTO posts (title, slug, postdate, tags, frontmatter, body, kind)
+	VALUES ("Part", "part", "2020-01-01T00:00:00Z", "",
+		"series: Old Series
+series_order: 3", "", "article"),
+		("Other", "other", "2020-01-01T00:00:00Z", "", "author: Guest", "", "article");`)
+	assert.Nil(t, err)
+	assert.Nil(t, MigrateDb(db))
+	assert.Nil(t, MigrateDb(db))
+
+	posts := GetSeriesPosts(db, "old series")
+	assert.Len(t, posts, 1)
+	assert.Equal(t, 3, posts[0].SeriesOrder())
+
+	// authors are filled in along the way
+	posts = GetPosts(db, GetPostOpts{Author: "Guest"})
+	if assert.Len(t, posts, 1) {
+		assert.Equal(t, "other", posts[0].Slug)
+	}
+}
+
+func TestSeriesPages(t *testing.T) {
+	assert.Nil(t, initDb(testDb))
+	defer os.Remove(testDb)
+	db, _ := GetDb(testDb)
+	repo := &NullPostsRepo{}
+
+	dir, _ := ioutil.TempDir("", "goldfrog-templates")
+	defer os.RemoveAll(dir)
+	var config Config
+	config.TemplatesDir = dir
+	os.MkdirAll(filepath.Join(dir, "base"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "base", "base.html"),
+		[]byte(`{{define "base"}}{{template "content" .}}{{end}}`), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "base", "rss.xml"), []byte(
+		`{{define "rss"}}{{.Link}}:{{range .Posts}}{{.Slug}};{{end}}{{end}}`), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "post_list.html"), []byte(
+		`{{define "content"}}{{.Series}}:{{range .Posts}}{{.Slug}};{{end}}{{end}}`), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "post_detail.html"), []byte(
+		`{{define "content"}}{{with .Series}}{{.Part}}/{{len .Posts}} `+
+			`{{with .Prev}}{{.Slug}}{{end}}|{{with .Next}}{{.Slug}}{{end}}{{end}}{{end}}`), 0644)
+
+	date := time.Date(2020, 1, 2, 12, 0, 0, 0, time.UTC)
+	for _, p := range []Post{
+		NewPost(PostOpts{Title: "One", Slug: "one", PostDate: date,
+			FrontMatter: map[string]string{"series": "Go Tour"}}),
+		NewPost(PostOpts{Title: "Two", Slug: "two", PostDate: date.Add(time.Hour),
+			FrontMatter: map[string]string{"series": "Go Tour"}}),
+		NewPost(PostOpts{Title: "Half", Slug: "half", PostDate: date,
+			FrontMatter: map[string]string{"series": "Input/Output"}}),
+	} {
+		p := p
+		_, err := storePost(db, repo, &p, true)
+		assert.Nil(t, err)
+	}
+
+	r := Routes(config, db, repo, "")
+	defer func() { wikiLinkDb = nil }()
+	get := func(path string) (int, string) {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		return rr.Code, rr.Body.String()
+	}
+
+	code, body := get("/series/Go%20Tour")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Go Tour:one;two;", body)
+
+	code, _ = get("/series/nope")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	code, body = get("/2020/01/02/one")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "1/2 |two", body)
+
+	code, body = get("/series/Go%20Tour/feed.xml")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, "/series/Go%20Tour:two;one;")
+
+	// a "/" in the name is escaped in its urls
+	assert.Equal(t, "/series/Input%2FOutput", seriesPath("Input/Output"))
+	code, body = get(seriesPath("Input/Output"))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Input/Output:half;", body)
+
+	code, body = get(seriesPath("Input/Output") + "/feed.xml")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, "/series/Input%2FOutput:half;")
+}
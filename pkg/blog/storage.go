@@ -3,6 +3,9 @@ package blog
 import (
 	"database/sql"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/araddon/dateparse"
@@ -20,13 +23,32 @@ type GetPostOpts struct {
 	Body   string
 	Offset int
 	Limit  int
+
+	// Tag restricts results to posts tagged with Tag
+	Tag string
+	// Kind restricts results to one of PostKinds
+	Kind string
+	// Series restricts results to the posts in a series
+	Series string
+	// Author restricts results to posts by Author (see Post.Author);
+	// with DefaultAuthor set, posts that have no author are included too.
+	Author        string
+	DefaultAuthor bool
+	// Since and Until bound the post date (Since inclusive, Until exclusive)
+	Since time.Time
+	Until time.Time
+
+	// BeforeDate and BeforeID continue a listing after the post with
+	// that date and id, for cursor pagination
+	BeforeDate time.Time
+	BeforeID   int
 }
 
 type ArchiveEntry struct {
-	Year       string
-	Month      string
-	CountPosts int
-	CountNotes int
+	Year       string `json:"year"`
+	Month      string `json:"month"`
+	CountPosts int    `json:"count_posts"`
+	CountNotes int    `json:"count_notes"`
 }
 
 var dateFmts = [...]string{
@@ -47,45 +69,42 @@ func GetPosts(db *sql.DB, opts GetPostOpts) []*Post {
 	}
 
 	var whereColumns []string
-	var whereValues []string
+	var args []interface{}
 
 	for column, value := range whereClauses {
-		whereColumns = append(whereColumns, column)
-		whereValues = append(whereValues, value)
+		whereColumns = append(whereColumns, fmt.Sprintf("%s like ?", column))
+		args = append(args, value)
 	}
 
 	var posts = make([]*Post, 0)
 
-	sql := "SELECT id, title, slug, postdate, tags, frontmatter, body FROM posts"
+	// search terms match any column, filters must all match
+	var conditions []string
 	if len(whereColumns) > 0 {
-		sql += " WHERE "
-		for i, c := range whereColumns {
-			sql += fmt.Sprintf("%s like ?", c)
-			if i+1 < len(whereColumns) {
-				sql += " OR "
-			}
-		}
+		conditions = append(
+			conditions, "("+strings.Join(whereColumns, " OR ")+")")
 	}
 
-	sql += " ORDER BY datetime(postdate) DESC"
-	if opts.Offset > 0 {
-		sql += " OFFSET ?"
+	filters, filterArgs := postFilters(opts)
+	conditions = append(conditions, filters...)
+	args = append(args, filterArgs...)
+
+	sql := "SELECT id, title, slug, postdate, tags, frontmatter, body FROM posts"
+	if len(conditions) > 0 {
+		sql += " WHERE " + strings.Join(conditions, " AND ")
 	}
+
+	sql += " ORDER BY datetime(postdate) DESC, id DESC"
 	if opts.Limit == 0 {
 		opts.Limit = 100
 	}
 
-	if opts.Limit != -1 {
-		sql += " LIMIT ?"
-		whereValues = append(
-			whereValues, fmt.Sprintf("%d", opts.Limit))
-	}
+	// sqlite wants LIMIT before OFFSET, and an OFFSET needs a LIMIT
+	sql += " LIMIT ?"
+	args = append(args, opts.Limit)
 
-	args := make([]interface{}, len(whereValues))
-	for i, id := range whereValues {
-		args[i] = id
-	}
 	if opts.Offset > 0 {
+		sql += " OFFSET ?"
 		args = append(args, opts.Offset)
 	}
 
@@ -105,6 +124,57 @@ func GetPosts(db *sql.DB, opts GetPostOpts) []*Post {
 	return posts
 }
 
+/*
+postFilters turns the filtering options in GetPostOpts into sql
+conditions (to be joined with AND) and their arguments.
+*/
+func postFilters(opts GetPostOpts) ([]string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+
+	if opts.Tag != "" {
+		conditions = append(conditions, "tags like ?")
+		args = append(args, "%"+opts.Tag+"%")
+	}
+
+	if opts.Kind != "" {
+		conditions = append(conditions, "kind = ?")
+		args = append(args, opts.Kind)
+	}
+
+	if opts.Series != "" {
+		conditions = append(conditions, "series = ? COLLATE NOCASE")
+		args = append(args, opts.Series)
+	}
+
+	if opts.Author != "" {
+		authorCondition := "author = ? COLLATE NOCASE"
+		if opts.DefaultAuthor {
+			authorCondition = `(` + authorCondition + ` OR author = "")`
+		}
+		conditions = append(conditions, authorCondition)
+		args = append(args, opts.Author)
+	}
+
+	if !opts.Since.IsZero() {
+		conditions = append(conditions, "datetime(postdate) >= datetime(?)")
+		args = append(args, opts.Since.UTC().Format(time.RFC3339))
+	}
+	if !opts.Until.IsZero() {
+		conditions = append(conditions, "datetime(postdate) < datetime(?)")
+		args = append(args, opts.Until.UTC().Format(time.RFC3339))
+	}
+
+	if !opts.BeforeDate.IsZero() {
+		conditions = append(conditions, `(datetime(postdate) < datetime(?)
+			OR (datetime(postdate) = datetime(?) AND id < ?))`)
+		before := opts.BeforeDate.UTC().Format(time.RFC3339)
+		args = append(args, before, before, opts.BeforeID)
+	}
+
+	return conditions, args
+}
+
 func GetTaggedPosts(db *sql.DB, tag string) []*Post {
 
 	var posts = make([]*Post, 0)
@@ -137,6 +207,44 @@ func GetTaggedPosts(db *sql.DB, tag string) []*Post {
 	return posts
 }
 
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// GetTagCounts lists every tag in use with its number of posts
+func GetTagCounts(db *sql.DB) []TagCount {
+	var tagCounts = make([]TagCount, 0)
+
+	rows, err := db.Query(`SELECT tags FROM posts`)
+	if err != nil {
+		logger.Errorf("Could not load tags: %v", err)
+		return tagCounts
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var tags string
+		err := rows.Scan(&tags)
+		if err != nil {
+			logger.Error(err)
+			continue
+		}
+		for _, tag := range dedupe(splitTags(tags)) {
+			counts[tag]++
+		}
+	}
+
+	for tag, count := range counts {
+		tagCounts = append(tagCounts, TagCount{Tag: tag, Count: count})
+	}
+	sort.Slice(tagCounts, func(i, j int) bool {
+		return tagCounts[i].Tag < tagCounts[j].Tag
+	})
+	return tagCounts
+}
+
 func GetPost(db *sql.DB, postID string) (*Post, error) {
 
 	var p Post
@@ -153,13 +261,12 @@ func GetPost(db *sql.DB, postID string) (*Post, error) {
 		return &p, err
 	}
 
-	var posts = make([]*Post, 1)
-
-	posts = rowsToPosts(rows)
-
-	post := posts[0]
+	posts := rowsToPosts(rows)
+	if len(posts) == 0 {
+		return &p, sql.ErrNoRows
+	}
 
-	return post, nil
+	return posts[0], nil
 }
 
 func GetPostBySlug(db *sql.DB, postSlug string) (*Post, error) {
@@ -185,10 +292,11 @@ func GetPostBySlug(db *sql.DB, postSlug string) (*Post, error) {
 		return &p, err
 	}
 
-	var posts = make([]*Post, 1)
-	posts = rowsToPosts(rows)
-	post := posts[0]
-	return post, nil
+	posts := rowsToPosts(rows)
+	if len(posts) == 0 {
+		return &p, sql.ErrNoRows
+	}
+	return posts[0], nil
 }
 
 func GetArchiveYearMonths(db *sql.DB) []ArchiveEntry {
@@ -297,17 +405,29 @@ func CreatePost(db *sql.DB, post *Post) error {
 		tags,
 		postdate,
 		frontmatter,
-		body
+		body,
+		kind,
+		series,
+		series_order,
+		author,
+		updated
 	) VALUES (
 		?, ?, ?,
-		?, ?, ?
+		?, ?, ?,
+		?, ?, ?,
+		?, ?
 	)
 	`, post.Slug,
 		post.Title,
 		post.TagString(),
 		post.PostDate.Format(time.RFC3339),
 		post.FrontMatterYAML(),
-		post.Body)
+		post.Body,
+		post.Kind(),
+		post.Series(),
+		post.SeriesOrder(),
+		post.Author(),
+		time.Now().UTC().Format(time.RFC3339))
 
 	if err != nil {
 		logger.Errorf("Could not save post: %v", err)
@@ -334,19 +454,30 @@ func SavePost(db *sql.DB, post *Post) error {
 		tags=?,
 		frontmatter=?,
 		body=?,
-		postdate=?
+		postdate=?,
+		kind=?,
+		series=?,
+		series_order=?,
+		author=?,
+		updated=?
 	WHERE id=?
 	`, post.Title,
 		post.TagString(),
 		post.FrontMatterYAML(),
 		post.Body,
 		post.PostDate.Format(time.RFC3339),
+		post.Kind(),
+		post.Series(),
+		post.SeriesOrder(),
+		post.Author(),
+		time.Now().UTC().Format(time.RFC3339),
 		post.ID)
 
 	if err != nil {
 		logger.Errorf("Could not save post: %v", err)
 		return err
 	}
+	renderedMarkdown.Invalidate(post.ID)
 
 	logger.Debug("saved post, now load for sanity...")
 	p, _ := GetPostBySlug(db, post.Slug)
@@ -358,6 +489,7 @@ func SavePost(db *sql.DB, post *Post) error {
 }
 
 func DeletePost(db *sql.DB, postID string) error {
+	post, _ := GetPost(db, postID)
 
 	_, err := db.Exec(`
 	DELETE FROM posts WHERE id=?
@@ -368,6 +500,26 @@ func DeletePost(db *sql.DB, postID string) error {
 		return err
 	}
 
+	if id, err := strconv.Atoi(postID); err == nil {
+		renderedMarkdown.Invalidate(id)
+	}
+
+	// the pages it was on changed, see lastChange
+	_, err = db.Exec(`INSERT INTO post_deletions (post_id, deleted) VALUES (?, ?)`,
+		postID, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		logger.Errorf("Could not record deletion: %v", err)
+	}
+
+	// links to it are broken now, links from it are gone
+	err = unlinkPost(db, postID)
+	if err != nil {
+		logger.Errorf("Could not unlink post: %v", err)
+	}
+	if post != nil && post.Slug != "" {
+		forgetBacklinks(db, post.Slug)
+	}
+
 	return nil
 }
 
@@ -383,7 +535,8 @@ func initDb(dbFile string) error {
 		tags varchar(1024),
 		frontmatter text default "",
 		body text default "",
-		format varchar(15));
+		format varchar(15),
+		kind varchar(15) default "");
 	`
 	db, err := GetDb(dbFile)
 	if err != nil {
@@ -397,9 +550,176 @@ func initDb(dbFile string) error {
 		return err
 	}
 	logger.Debug(res)
+	err = MigrateDb(db)
+	if err != nil {
+		return err
+	}
+	err = initMediaTable(db)
+	if err != nil {
+		return err
+	}
+	err = initPostLinks(db)
+	if err != nil {
+		return err
+	}
+	return initSyndicationJobs(db)
+}
+
+// postColumns are the names of the columns in the posts table
+func postColumns(db *sql.DB) (map[string]bool, error) {
+	rows, err := db.Query(`PRAGMA table_info(posts)`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dflt, &pk); err == nil {
+			columns[name] = true
+		}
+	}
+	return columns, rows.Err()
+}
+
+/*
+MigrateDb brings a posts table made by an older goldfrog up to date:
+it adds the kind column, and fills it in for posts that don't have one,
+the updated column (starting at the post dates), the series columns,
+filled in from the front matter, the author column, likewise, and the
+table of deletions.
+*/
+func MigrateDb(db *sql.DB) error {
+	columns, err := postColumns(db)
+	if err != nil {
+		return err
+	}
+
+	if !columns["kind"] {
+		logger.Info("Adding kind to posts")
+		_, err = db.Exec(`ALTER TABLE posts ADD COLUMN kind varchar(15) default ""`)
+		if err != nil {
+			return fmt.Errorf("Could not add kind to posts: %v", err)
+		}
+	}
+
+	rows, err := db.Query(`SELECT id, title, frontmatter FROM posts WHERE kind = ""`)
+	if err != nil {
+		return err
+	}
+	kinds := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var title, fmStr string
+		if err := rows.Scan(&id, &title, &fmStr); err == nil {
+			kinds[id] = postKind(title, GetFrontMatter(fmStr))
+		}
+	}
+	rows.Close()
+
+	for id, kind := range kinds {
+		_, err = db.Exec(`UPDATE posts SET kind = ? WHERE id = ?`, kind, id)
+		if err != nil {
+			return fmt.Errorf("Could not set kind of post %d: %v", id, err)
+		}
+	}
+
+	if !columns["updated"] {
+		logger.Info("Adding updated to posts")
+		_, err = db.Exec(`ALTER TABLE posts ADD COLUMN updated varchar(25) default ""`)
+		if err != nil {
+			return fmt.Errorf("Could not add updated to posts: %v", err)
+		}
+		_, err = db.Exec(`UPDATE posts SET updated = postdate`)
+		if err != nil {
+			return fmt.Errorf("Could not set updated of posts: %v", err)
+		}
+	}
+
+	_, err = db.Exec(`
+	CREATE TABLE IF NOT EXISTS post_deletions (
+		id integer primary key,
+		post_id integer,
+		deleted varchar(25));
+	`)
+	if err != nil {
+		return fmt.Errorf("Could not create post deletions table: %v", err)
+	}
+
+	if !columns["author"] {
+		err = migrateAuthors(db)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !columns["series"] {
+		return migrateSeries(db)
+	}
+	return nil
+}
+
+// migrateAuthors adds the author column and fills it in from the front matter
+func migrateAuthors(db *sql.DB) error {
+	logger.Info("Adding author to posts")
+	_, err := db.Exec(`ALTER TABLE posts ADD COLUMN author varchar(256) default ""`)
+	if err != nil {
+		return fmt.Errorf("Could not add author to posts: %v", err)
+	}
+
+	rows, err := db.Query(`
+		SELECT id, frontmatter FROM posts WHERE frontmatter like '%author%'`)
+	if err != nil {
+		return err
+	}
+	var posts []Post
+	for rows.Next() {
+		var post Post
+		var fmStr string
+		if err := rows.Scan(&post.ID, &fmStr); err == nil {
+			post.FrontMatter = GetFrontMatter(fmStr)
+			posts = append(posts, post)
+		}
+	}
+	rows.Close()
+
+	for _, post := range posts {
+		_, err = db.Exec(`UPDATE posts SET author = ? WHERE id = ?`,
+			post.Author(), post.ID)
+		if err != nil {
+			return fmt.Errorf("Could not set author of post %d: %v", post.ID, err)
+		}
+	}
 	return nil
 }
 
+/*
+lastChange is when a post was last created, edited or deleted, for
+Last-Modified. It's the same for every page: an edit or a deletion
+can change which posts a page shows, not just the posts on it.
+*/
+func lastChange(db *sql.DB) time.Time {
+	var last time.Time
+	for _, query := range []string{
+		`SELECT coalesce(max(datetime(updated)), "") FROM posts`,
+		`SELECT coalesce(max(datetime(deleted)), "") FROM post_deletions`,
+	} {
+		var value string
+		err := db.QueryRow(query).Scan(&value)
+		if err != nil {
+			logger.Errorf("Could not find the last change: %v", err)
+			continue
+		}
+		t, err := time.Parse("2006-01-02 15:04:05", value)
+		if err == nil && t.After(last) {
+			last = t
+		}
+	}
+	return last
+}
+
 func checkDb(dbFile string) bool {
 	db, err := GetDb(dbFile)
 	if err != nil {
@@ -454,6 +774,7 @@ func rowsToPosts(rows *sql.Rows) []*Post {
 		p.Tags = splitTags(tags)
 		// logger.Debugf("rowsToPosts frontmatter string: %v", fmStr)
 		p.FrontMatter = GetFrontMatter(fmStr)
+		p.Media = GetFrontMatterMedia(fmStr)
 
 		p.Body = body
 
@@ -94,3 +94,63 @@ func TestCreateSavePostPlain(t *testing.T) {
 		p2.PostDate.Format(time.RFC3339),
 		p3.PostDate.Format(time.RFC3339))
 }
+
+func TestGetPostsFilters(t *testing.T) {
+	initDb(testDb)
+	db, _ := GetDb(testDb)
+	defer func() {
+		os.Remove(testDb)
+	}()
+
+	day := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	for i, opts := range []PostOpts{
+		{Title: "an article", Slug: "an-article", Tags: []string{"golang"}},
+		{Slug: "a-note", Tags: []string{"golang"}},
+		{Slug: "another-note", FrontMatter: map[string]string{
+			"author": "Guest Writer"}},
+		// yaml quotes this one in the front matter
+		{Slug: "quoted-note", FrontMatter: map[string]string{
+			"author": "Ann: 100%"}},
+	} {
+		opts.PostDate = day.AddDate(0, 0, -i)
+		p := NewPost(opts)
+		err := CreatePost(db, &p)
+		assert.Nil(t, err)
+	}
+
+	posts := GetPosts(db, GetPostOpts{Kind: KINDARTICLE})
+	assert.Equal(t, 1, len(posts))
+	assert.Equal(t, "an-article", posts[0].Slug)
+
+	posts = GetPosts(db, GetPostOpts{Kind: KINDNOTE})
+	assert.Equal(t, 3, len(posts))
+
+	posts = GetPosts(db, GetPostOpts{Tag: "golang", Kind: KINDNOTE})
+	assert.Equal(t, 1, len(posts))
+	assert.Equal(t, "a-note", posts[0].Slug)
+
+	posts = GetPosts(db, GetPostOpts{Author: "Guest Writer"})
+	assert.Equal(t, 1, len(posts))
+	assert.Equal(t, "another-note", posts[0].Slug)
+
+	posts = GetPosts(db, GetPostOpts{Author: "Steve", DefaultAuthor: true})
+	assert.Equal(t, 2, len(posts))
+
+	posts = GetPosts(db, GetPostOpts{Author: "guest writer"})
+	assert.Equal(t, 1, len(posts))
+	assert.Equal(t, 0, len(GetPosts(db, GetPostOpts{Author: "Guest%"})))
+	posts = GetPosts(db, GetPostOpts{Author: "Ann: 100%"})
+	if assert.Equal(t, 1, len(posts)) {
+		assert.Equal(t, "quoted-note", posts[0].Slug)
+	}
+
+	posts = GetPosts(db, GetPostOpts{
+		Since: day.AddDate(0, 0, -1), Until: day})
+	assert.Equal(t, 1, len(posts))
+	assert.Equal(t, "a-note", posts[0].Slug)
+
+	posts = GetPosts(db, GetPostOpts{Limit: 1, Offset: 1})
+	assert.Equal(t, 1, len(posts))
+	assert.Equal(t, "a-note", posts[0].Slug)
+}
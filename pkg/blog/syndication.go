@@ -0,0 +1,264 @@
+package blog
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sivy/goldfrog/pkg/syndication"
+)
+
+/*
+SyndicationTarget is a syndication target as the post forms show it:
+a checkbox named Name, and on the edit page what became of the post
+there: Status is "posted" (with URL), "failed" (with Error), "unknown"
+(it timed out), "retrying" (until NextAttempt) or empty if it was
+never sent.
+Propagates is set when saving the post also edits the copy there,
+which the skip_propagation checkbox turns off.
+*/
+type SyndicationTarget struct {
+	Name        string
+	Label       string
+	URL         string
+	Status      string
+	Error       string
+	NextAttempt time.Time
+	Propagates  bool
+}
+
+/*
+SyndicationTargets lists the configured syndication targets: the
+`syndication` list, plus the older `twitter` and `mastodon` sections
+when they are filled in and not overridden by a target of that name.
+*/
+func (config Config) SyndicationTargets() []syndication.Target {
+	targets := make([]syndication.Target, 0, len(config.Syndication)+2)
+	names := make(map[string]bool)
+
+	for _, target := range config.Syndication {
+		if target.Name == "" {
+			target.Name = target.Type
+		}
+		if names[target.Name] {
+			logger.Warnf("Duplicate syndication target %q, ignoring", target.Name)
+			continue
+		}
+		names[target.Name] = true
+		targets = append(targets, target)
+	}
+
+	if config.Twitter.ClientKey != "" && !names["twitter"] {
+		targets = append(targets, syndication.Target{
+			Name:  "twitter",
+			Type:  "twitter",
+			Label: "Twitter",
+			Options: map[string]interface{}{
+				"clientkey":    config.Twitter.ClientKey,
+				"clientsecret": config.Twitter.ClientSecret,
+				"accesskey":    config.Twitter.AccessKey,
+				"accesssecret": config.Twitter.AccessSecret,
+				"userid":       config.Twitter.UserID,
+				"linkformat":   config.Twitter.LinkFormat,
+				"template":     config.Twitter.Template,
+			},
+		})
+	}
+
+	if config.Mastodon.Site != "" && !names["mastodon"] {
+		targets = append(targets, syndication.Target{
+			Name:  "mastodon",
+			Type:  "mastodon",
+			Label: "Mastodon",
+			Options: map[string]interface{}{
+				"site":           config.Mastodon.Site,
+				"clientid":       config.Mastodon.ClientID,
+				"clientsecret":   config.Mastodon.ClientSecret,
+				"accesstoken":    config.Mastodon.AccessToken,
+				"linkformat":     config.Mastodon.LinkFormat,
+				"template":       config.Mastodon.Template,
+				"visibility":     config.Mastodon.Visibility,
+				"contentwarning": config.Mastodon.ContentWarning,
+				"language":       config.Mastodon.Language,
+				"thread":         config.Mastodon.Thread,
+			},
+		})
+	}
+
+	return targets
+}
+
+/*
+postSyndicationTargets lists the targets for the post forms, with
+what became of post at each of them (post may be nil).
+*/
+func postSyndicationTargets(config Config, db *sql.DB, post *Post) []SyndicationTarget {
+	var targets []SyndicationTarget
+
+	retries := make(map[string]SyndicationJob)
+	if post != nil && db != nil {
+		for _, job := range GetSyndicationJobs(db, post.ID) {
+			retries[job.Target] = job
+		}
+	}
+
+	for _, target := range config.SyndicationTargets() {
+		t := SyndicationTarget{
+			Name:  target.Name,
+			Label: syndication.TargetLabel(target),
+		}
+		if post != nil {
+			fm := post.FrontMatter
+			t.URL = fm[target.Name+"_url"]
+			t.Status = fm[target.Name+"_status"]
+			t.Error = fm[target.Name+"_error"]
+			t.Propagates = fm[target.Name+"_id"] != "" && syndication.CanUpdate(target)
+			if t.Status == "" && (t.URL != "" || fm[target.Name+"_id"] != "") {
+				// sent before statuses were recorded
+				t.Status = syndication.StatusPosted
+			}
+			if job, ok := retries[target.Name]; ok && job.Status == JOBPENDING {
+				t.Status = "retrying"
+				t.Error = job.LastError
+				t.NextAttempt = job.NextAttempt
+			}
+		}
+		targets = append(targets, t)
+	}
+	return targets
+}
+
+/*
+TootOptions are the controls of the post forms for one Mastodon
+target, filled from the post's front matter when editing. Each is
+named {Target}_{option} in the form and the front matter (see Field);
+empty values leave the target's defaults.
+*/
+type TootOptions struct {
+	Target         string
+	Label          string
+	Visibility     string
+	ContentWarning string
+	Language       string
+	Thread         string
+	Visibilities   []string
+}
+
+// Field is the form field and front matter key of a Mastodon option
+func (opts TootOptions) Field(option string) string {
+	return opts.Target + "_" + option
+}
+
+// postTootOptions lists the options of every Mastodon target for post
+func postTootOptions(config Config, post *Post) []TootOptions {
+	var options []TootOptions
+	for _, target := range config.SyndicationTargets() {
+		if target.Type != "mastodon" {
+			continue
+		}
+		opts := TootOptions{
+			Target:       target.Name,
+			Label:        syndication.TargetLabel(target),
+			Visibilities: syndication.MastodonVisibilities,
+		}
+		if post != nil {
+			fm := post.FrontMatter
+			opts.Visibility = fm[opts.Field(syndication.MastodonVisibilityOption)]
+			opts.ContentWarning = fm[opts.Field(syndication.MastodonContentWarningOption)]
+			opts.Language = fm[opts.Field(syndication.MastodonLanguageOption)]
+			opts.Thread = fm[opts.Field(syndication.MastodonThreadOption)]
+		}
+		options = append(options, opts)
+	}
+	return options
+}
+
+/*
+tootFormOptions copies the Mastodon controls of the post forms into
+frontMatter: a checked thread checkbox ("on") is stored as "true", and
+empty or unchecked controls remove their key, going back to the
+target's defaults. Targets the form has no controls for are left alone.
+*/
+func tootFormOptions(config Config, r *http.Request, frontMatter map[string]string) {
+	r.ParseMultipartForm(32 << 20)
+
+	for _, opts := range postTootOptions(config, nil) {
+		hasControls := false
+		for _, option := range syndication.MastodonOptions {
+			_, ok := r.PostForm[opts.Field(option)]
+			hasControls = hasControls || ok
+		}
+		if !hasControls {
+			continue
+		}
+
+		for _, option := range syndication.MastodonOptions {
+			field := opts.Field(option)
+			value := strings.TrimSpace(r.PostFormValue(field))
+			if option == syndication.MastodonThreadOption && value == "on" {
+				value = "true"
+			}
+			if value == "" {
+				delete(frontMatter, field)
+				continue
+			}
+			frontMatter[field] = value
+		}
+	}
+}
+
+// syndicationFormHooks reads the syndication checkboxes of the post forms
+func syndicationFormHooks(config Config, r *http.Request) map[string]bool {
+	includeHooks := make(map[string]bool)
+
+	for _, target := range config.SyndicationTargets() {
+		if r.PostFormValue(target.Name) == "on" {
+			includeHooks[target.Name] = true
+		}
+	}
+	return includeHooks
+}
+
+/*
+unsentHooks drops the targets post already has an `{target}_id` for,
+or that timed out and may have it, from includeHooks, so that editing a
+post doesn't send it there again; force keeps them, to send a new copy
+anyway.
+*/
+func unsentHooks(post *Post, includeHooks map[string]bool, force bool) map[string]bool {
+	if force {
+		return includeHooks
+	}
+	hooks := make(map[string]bool)
+	for name, include := range includeHooks {
+		if post.FrontMatter[name+"_id"] != "" ||
+			post.FrontMatter[name+"_status"] == syndication.StatusUnknown {
+			logger.Infof("%s already sent to %s, skipping", post.Slug, name)
+			continue
+		}
+		hooks[name] = include
+	}
+	return hooks
+}
+
+/*
+syndicationHooks checks a list of target names, as the API and
+Micropub receive them, against the configured targets.
+*/
+func syndicationHooks(config Config, names []string) (map[string]bool, error) {
+	known := make(map[string]bool)
+	for _, target := range config.SyndicationTargets() {
+		known[target.Name] = true
+	}
+
+	includeHooks := make(map[string]bool)
+	for _, name := range names {
+		if !known[name] {
+			return nil, fmt.Errorf("Unknown syndication target: %q", name)
+		}
+		includeHooks[name] = true
+	}
+	return includeHooks, nil
+}
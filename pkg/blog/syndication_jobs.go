@@ -0,0 +1,299 @@
+package blog
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/sivy/goldfrog/pkg/syndication"
+)
+
+/*
+Syndication jobs are retries of failed crossposts. When a target
+fails, a job is queued for it; a worker started with goldfrogd picks
+up due jobs and tries again, backing off exponentially, until the
+target succeeds or syndicationMaxAttempts is reached.
+*/
+
+const (
+	JOBPENDING string = "pending"
+	JOBDONE    string = "done"
+	JOBFAILED  string = "failed"
+
+	syndicationMaxAttempts int           = 8
+	syndicationBaseBackoff time.Duration = time.Minute
+	syndicationMaxBackoff  time.Duration = 6 * time.Hour
+)
+
+type SyndicationJob struct {
+	ID          int
+	PostID      int
+	Target      string
+	Status      string
+	Force       bool
+	Attempts    int
+	LastError   string
+	NextAttempt time.Time
+	Updated     time.Time
+}
+
+func initSyndicationJobs(db *sql.DB) error {
+	_, err := db.Exec(`
+	CREATE TABLE IF NOT EXISTS syndication_jobs (
+		id integer primary key,
+		post_id integer,
+		target varchar(256),
+		status varchar(15),
+		force integer default 0,
+		attempts integer default 0,
+		last_error text default "",
+		next_attempt varchar(25),
+		updated varchar(25),
+		unique(post_id, target));
+	`)
+	if err != nil {
+		logger.Errorf("Could not create syndication jobs table: %v", err)
+	}
+	return err
+}
+
+// syndicationBackoff is how long to wait after the given number of attempts
+func syndicationBackoff(attempts int) time.Duration {
+	backoff := syndicationBaseBackoff
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if backoff >= syndicationMaxBackoff {
+			return syndicationMaxBackoff
+		}
+	}
+	return backoff
+}
+
+/*
+queueSyndicationRetry records a failed attempt to send post to target
+and schedules the next one.
+*/
+func queueSyndicationRetry(db *sql.DB, postID int, target string, force bool, lastError string) error {
+	now := time.Now().UTC()
+	_, err := db.Exec(`
+		INSERT INTO syndication_jobs (
+			post_id, target, status, force, attempts, last_error,
+			next_attempt, updated
+		) VALUES (
+			?, ?, ?, ?, 1, ?, ?, ?
+		) ON CONFLICT(post_id, target) DO UPDATE
+		SET
+			status=excluded.status,
+			force=excluded.force,
+			attempts=1,
+			last_error=excluded.last_error,
+			next_attempt=excluded.next_attempt,
+			updated=excluded.updated
+	`, postID, target, JOBPENDING, force, lastError,
+		now.Add(syndicationBackoff(1)).Format(time.RFC3339),
+		now.Format(time.RFC3339))
+	return err
+}
+
+/*
+closeSyndicationJob marks the pending job for post and target, if
+any, as done: the post went out some other way, and a forced job
+would send it again.
+*/
+func closeSyndicationJob(db *sql.DB, postID int, target string) error {
+	_, err := db.Exec(`
+		UPDATE syndication_jobs
+		SET status=?, last_error="", updated=?
+		WHERE post_id=? AND target=? AND status=?
+	`, JOBDONE, time.Now().UTC().Format(time.RFC3339),
+		postID, target, JOBPENDING)
+	return err
+}
+
+func saveSyndicationJob(db *sql.DB, job SyndicationJob) error {
+	_, err := db.Exec(`
+		UPDATE syndication_jobs
+		SET status=?, attempts=?, last_error=?, next_attempt=?, updated=?
+		WHERE id=?
+	`, job.Status, job.Attempts, job.LastError,
+		job.NextAttempt.UTC().Format(time.RFC3339),
+		time.Now().UTC().Format(time.RFC3339),
+		job.ID)
+	return err
+}
+
+func querySyndicationJobs(db *sql.DB, where string, args ...interface{}) ([]SyndicationJob, error) {
+	rows, err := db.Query(`
+		SELECT id, post_id, target, status, force, attempts, last_error,
+			next_attempt, updated
+		FROM syndication_jobs
+		WHERE `+where+`
+		ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []SyndicationJob
+	for rows.Next() {
+		var job SyndicationJob
+		var next, updated string
+		err := rows.Scan(
+			&job.ID, &job.PostID, &job.Target, &job.Status, &job.Force,
+			&job.Attempts, &job.LastError, &next, &updated)
+		if err != nil {
+			logger.Error(err)
+			continue
+		}
+		job.NextAttempt, _ = time.Parse(time.RFC3339, next)
+		job.Updated, _ = time.Parse(time.RFC3339, updated)
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// GetSyndicationJobs lists the retries recorded for a post
+func GetSyndicationJobs(db *sql.DB, postID int) []SyndicationJob {
+	jobs, err := querySyndicationJobs(db, "post_id = ?", postID)
+	if err != nil {
+		logger.Errorf("Could not load syndication jobs: %v", err)
+	}
+	return jobs
+}
+
+func dueSyndicationJobs(db *sql.DB, now time.Time) ([]SyndicationJob, error) {
+	return querySyndicationJobs(db,
+		"status = ? AND datetime(next_attempt) <= datetime(?)",
+		JOBPENDING, now.UTC().Format(time.RFC3339))
+}
+
+/*
+queueFailedSyndications queues a retry for every target that failed
+in results, and closes the retries of those that went out. Targets
+that timed out (StatusUnknown) aren't retried, the post may be there.
+*/
+func queueFailedSyndications(db *sql.DB, post *Post, results []syndication.Result) {
+	for _, result := range results {
+		if result.Target == "webmention" {
+			continue
+		}
+		if result.Status == syndication.StatusPosted {
+			closeRetry(db, post, result.Target)
+			continue
+		}
+		if result.Status != syndication.StatusFailed {
+			continue
+		}
+		err := queueSyndicationRetry(db, post.ID, result.Target, false, result.Error)
+		if err != nil {
+			logger.Errorf("Could not queue retry for %s: %v", result.Target, err)
+		}
+	}
+}
+
+/*
+resyndicatePost sends post to a single target again. Targets
+unsentHooks drops are skipped unless force is set.
+When it's skipped or goes out, a retry queued for it is closed.
+*/
+func resyndicatePost(
+	config Config, db *sql.DB, repo PostsRepo, post *Post,
+	target string, force bool) (syndication.Result, bool, error) {
+
+	if len(unsentHooks(post, map[string]bool{target: true}, force)) == 0 {
+		logger.Infof("%s already sent to %s, skipping", post.Slug, target)
+		closeRetry(db, post, target)
+		return syndication.Result{}, true, nil
+	}
+
+	results, err := sendToTargets(
+		config, db, repo, post, map[string]bool{target: true}, false)
+	if len(results) == 0 {
+		return syndication.Result{}, false, fmt.Errorf(
+			"Unknown syndication target: %q", target)
+	}
+	if results[0].Status == syndication.StatusPosted {
+		closeRetry(db, post, target)
+	}
+	return results[0], false, err
+}
+
+func closeRetry(db *sql.DB, post *Post, target string) {
+	err := closeSyndicationJob(db, post.ID, target)
+	if err != nil {
+		logger.Errorf("Could not close retry for %s: %v", target, err)
+	}
+}
+
+/*
+processSyndicationJobs runs every due job once and returns how many
+it ran.
+*/
+func processSyndicationJobs(config Config, db *sql.DB, repo PostsRepo) int {
+	now := time.Now()
+	jobs, err := dueSyndicationJobs(db, now)
+	if err != nil {
+		logger.Errorf("Could not load syndication jobs: %v", err)
+		return 0
+	}
+
+	for _, job := range jobs {
+		logger.Infof("Retrying %s for post %d (attempt %d)",
+			job.Target, job.PostID, job.Attempts+1)
+
+		post, err := GetPost(db, strconv.Itoa(job.PostID))
+		if err != nil {
+			job.Status = JOBFAILED
+			job.LastError = "post not found"
+			saveSyndicationJob(db, job)
+			continue
+		}
+
+		result, skipped, err := resyndicatePost(
+			config, db, repo, post, job.Target, job.Force)
+		job.Attempts++
+
+		switch {
+		case skipped || result.Status == syndication.StatusPosted:
+			job.Status = JOBDONE
+			job.LastError = ""
+		case result.Status == syndication.StatusUnknown,
+			job.Attempts >= syndicationMaxAttempts:
+			// a timeout may have posted it, trying again could duplicate it
+			job.Status = JOBFAILED
+			job.LastError = result.Error
+		default:
+			job.LastError = result.Error
+			if job.LastError == "" && err != nil {
+				job.LastError = err.Error()
+			}
+			job.NextAttempt = now.Add(syndicationBackoff(job.Attempts))
+		}
+
+		err = saveSyndicationJob(db, job)
+		if err != nil {
+			logger.Errorf("Could not save syndication job: %v", err)
+		}
+	}
+	return len(jobs)
+}
+
+/*
+StartSyndicationWorker sets up the jobs table and retries due
+syndication jobs every interval, in the background.
+*/
+func StartSyndicationWorker(
+	config Config, db *sql.DB, repo PostsRepo, interval time.Duration) error {
+	err := initSyndicationJobs(db)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for range time.Tick(interval) {
+			processSyndicationJobs(config, db, repo)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,233 @@
+package blog
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sivy/goldfrog/pkg/syndication"
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyHook fails while flakyFailures > 0
+var flakyFailures int
+
+type flakyHook struct{}
+
+func (fh flakyHook) HandlePost(ctx context.Context, postData syndication.PostData) syndication.Result {
+	if flakyFailures > 0 {
+		flakyFailures--
+		return syndication.Result{
+			Status: syndication.StatusFailed, Error: "service unavailable"}
+	}
+	return syndication.Result{ID: "42", URL: "https://flaky.example/42"}
+}
+
+// slowHook doesn't answer before it times out
+type slowHook struct{}
+
+func (sh slowHook) HandlePost(ctx context.Context, postData syndication.PostData) syndication.Result {
+	<-ctx.Done()
+	time.Sleep(10 * time.Millisecond)
+	return syndication.Result{ID: "43"}
+}
+
+func init() {
+	syndication.Register("test-flaky", syndication.Registration{
+		Options: func() interface{} { return &struct{}{} },
+		New: func(name string, options interface{}) syndication.Hook {
+			return flakyHook{}
+		},
+	})
+	syndication.Register("test-slow", syndication.Registration{
+		Options: func() interface{} { return &struct{}{} },
+		New: func(name string, options interface{}) syndication.Hook {
+			return slowHook{}
+		},
+	})
+}
+
+func TestSyndicationRetries(t *testing.T) {
+	var config Config
+	config.Blog.Url = "http://blog.example"
+	config.Syndication = []syndication.Target{{Name: "flaky", Type: "test-flaky"}}
+
+	assert.Nil(t, initDb(testDb))
+	defer os.Remove(testDb)
+	db, _ := GetDb(testDb)
+	repo := &NullPostsRepo{}
+
+	p := NewPost(PostOpts{Title: "Retry me", Slug: "retry-me", Body: "body"})
+	post, err := storePost(db, repo, &p, true)
+	assert.Nil(t, err)
+
+	flakyFailures = 2
+	results, err := syndicatePost(
+		config, db, repo, post, map[string]bool{"flaky": true})
+	assert.Nil(t, err)
+	assert.Equal(t, syndication.StatusFailed, results[0].Status)
+	assert.Equal(t, "failed", post.FrontMatter["flaky_status"])
+
+	jobs := GetSyndicationJobs(db, post.ID)
+	assert.Equal(t, 1, len(jobs))
+	assert.Equal(t, JOBPENDING, jobs[0].Status)
+	assert.True(t, jobs[0].NextAttempt.After(time.Now()))
+
+	targets := postSyndicationTargets(config, db, post)
+	assert.Equal(t, "retrying", targets[0].Status)
+	assert.Equal(t, "service unavailable", targets[0].Error)
+
+	// nothing is due yet
+	assert.Equal(t, 0, processSyndicationJobs(config, db, repo))
+
+	makeDue := func() {
+		_, err := db.Exec(`UPDATE syndication_jobs SET next_attempt = ?`,
+			time.Now().Add(-time.Minute).UTC().Format(time.RFC3339))
+		assert.Nil(t, err)
+	}
+
+	makeDue()
+	assert.Equal(t, 1, processSyndicationJobs(config, db, repo))
+	jobs = GetSyndicationJobs(db, post.ID)
+	assert.Equal(t, JOBPENDING, jobs[0].Status)
+	assert.Equal(t, 2, jobs[0].Attempts)
+
+	makeDue()
+	assert.Equal(t, 1, processSyndicationJobs(config, db, repo))
+	jobs = GetSyndicationJobs(db, post.ID)
+	assert.Equal(t, JOBDONE, jobs[0].Status)
+
+	post, _ = GetPost(db, "1")
+	assert.Equal(t, "42", post.FrontMatter["flaky_id"])
+	assert.Equal(t, "posted", post.FrontMatter["flaky_status"])
+	assert.Equal(t, "", post.FrontMatter["flaky_error"])
+
+	_, skipped, err := resyndicatePost(config, db, repo, post, "flaky", false)
+	assert.Nil(t, err)
+	assert.True(t, skipped)
+
+	result, skipped, err := resyndicatePost(config, db, repo, post, "flaky", true)
+	assert.Nil(t, err)
+	assert.False(t, skipped)
+	assert.Equal(t, syndication.StatusPosted, result.Status)
+
+	_, _, err = resyndicatePost(config, db, repo, post, "nope", true)
+	assert.NotNil(t, err)
+}
+
+func TestSyndicatePostHandler(t *testing.T) {
+	var config Config
+	config.Signin.Username = "me"
+	config.Signin.Password = "pw"
+	config.Syndication = []syndication.Target{{Name: "flaky", Type: "test-flaky"}}
+
+	assert.Nil(t, initDb(testDb))
+	defer os.Remove(testDb)
+	db, _ := GetDb(testDb)
+
+	p := NewPost(PostOpts{Title: "Send me", Slug: "send-me", Body: "body"})
+	assert.Nil(t, CreatePost(db, &p))
+
+	flakyFailures = 1
+	handler := CreateSyndicatePostFunc(config, db, &NullPostsRepo{})
+	req := httptest.NewRequest("POST", "/syndicate", strings.NewReader(url.Values{
+		"postID": {"1"},
+		"target": {"flaky"},
+	}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{
+		Name: "goldfrog", Value: hashAccount("me", "pw")})
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, "/edit/1", rr.Header().Get("Location"))
+	jobs := GetSyndicationJobs(db, 1)
+	assert.Equal(t, 1, len(jobs))
+}
+
+func TestResendClosesForcedRetry(t *testing.T) {
+	var config Config
+	config.Syndication = []syndication.Target{{Name: "flaky", Type: "test-flaky"}}
+
+	assert.Nil(t, initDb(testDb))
+	defer os.Remove(testDb)
+	db, _ := GetDb(testDb)
+	repo := &NullPostsRepo{}
+
+	p := NewPost(PostOpts{Title: "Resend me", Slug: "resend-me", Body: "body"})
+	post, err := storePost(db, repo, &p, true)
+	assert.Nil(t, err)
+	assert.Nil(t, queueSyndicationRetry(db, post.ID, "flaky", true, "down"))
+
+	flakyFailures = 0
+	result, skipped, err := resyndicatePost(config, db, repo, post, "flaky", true)
+	assert.Nil(t, err)
+	assert.False(t, skipped)
+	assert.Equal(t, syndication.StatusPosted, result.Status)
+
+	jobs := GetSyndicationJobs(db, post.ID)
+	if assert.Equal(t, 1, len(jobs)) {
+		assert.Equal(t, JOBDONE, jobs[0].Status)
+	}
+	targets := postSyndicationTargets(config, db, post)
+	assert.Equal(t, "posted", targets[0].Status)
+
+	// the worker has nothing left to send
+	_, err = db.Exec(`UPDATE syndication_jobs SET next_attempt = ?`,
+		time.Now().Add(-time.Minute).UTC().Format(time.RFC3339))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, processSyndicationJobs(config, db, repo))
+}
+
+func TestSyndicationTimeoutNotRetried(t *testing.T) {
+	defer func(timeout time.Duration) {
+		syndication.SyndicateTimeout = timeout
+	}(syndication.SyndicateTimeout)
+	syndication.SyndicateTimeout = 10 * time.Millisecond
+
+	var config Config
+	config.Syndication = []syndication.Target{{Name: "slow", Type: "test-slow"}}
+
+	assert.Nil(t, initDb(testDb))
+	defer os.Remove(testDb)
+	db, _ := GetDb(testDb)
+	repo := &NullPostsRepo{}
+
+	p := NewPost(PostOpts{Title: "Slow", Slug: "slow", Body: "body"})
+	post, err := storePost(db, repo, &p, true)
+	assert.Nil(t, err)
+
+	// it may have gone out, so it isn't sent again
+	results, err := syndicatePost(
+		config, db, repo, post, map[string]bool{"slow": true})
+	assert.Nil(t, err)
+	assert.Equal(t, syndication.StatusUnknown, results[0].Status)
+	assert.Equal(t, "unknown", post.FrontMatter["slow_status"])
+	assert.Contains(t, post.FrontMatter["slow_error"], "deadline")
+	assert.Equal(t, 0, len(GetSyndicationJobs(db, post.ID)))
+	assert.Equal(t, "unknown", postSyndicationTargets(config, db, post)[0].Status)
+
+	// nor is a queued retry that times out
+	assert.Nil(t, queueSyndicationRetry(db, post.ID, "slow", true, "down"))
+	_, err = db.Exec(`UPDATE syndication_jobs SET next_attempt = ?`,
+		time.Now().Add(-time.Minute).UTC().Format(time.RFC3339))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, processSyndicationJobs(config, db, repo))
+	jobs := GetSyndicationJobs(db, post.ID)
+	if assert.Equal(t, 1, len(jobs)) {
+		assert.Equal(t, JOBFAILED, jobs[0].Status)
+		assert.Equal(t, 2, jobs[0].Attempts)
+	}
+}
+
+func TestSyndicationBackoff(t *testing.T) {
+	assert.Equal(t, time.Minute, syndicationBackoff(1))
+	assert.Equal(t, 4*time.Minute, syndicationBackoff(3))
+	assert.Equal(t, syndicationMaxBackoff, syndicationBackoff(20))
+}
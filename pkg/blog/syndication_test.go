@@ -0,0 +1,226 @@
+package blog
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/sivy/goldfrog/pkg/syndication"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyndicationTargets(t *testing.T) {
+	var config Config
+	config.Mastodon.Site = "https://legacy.example"
+	config.Twitter.ClientKey = "key"
+	config.Syndication = []syndication.Target{
+		{Name: "mastodon", Type: "mastodon", Options: map[string]interface{}{
+			"site": "https://new.example"}},
+		{Name: "mastodon-work", Type: "mastodon", Label: "Work"},
+	}
+
+	targets := config.SyndicationTargets()
+	assert.Equal(t, 3, len(targets))
+	assert.Equal(t, "https://new.example", targets[0].Options["site"])
+	assert.Equal(t, "mastodon-work", targets[1].Name)
+	assert.Equal(t, "twitter", targets[2].Name)
+
+	post := NewPost(PostOpts{Title: "t"})
+	post.FrontMatter["mastodon-work_url"] = "https://work.example/1"
+	formTargets := postSyndicationTargets(config, nil, &post)
+	assert.Equal(t, "Work", formTargets[1].Label)
+	assert.Equal(t, "https://work.example/1", formTargets[1].URL)
+
+	req := httptest.NewRequest("POST", "/new", strings.NewReader(url.Values{
+		"mastodon-work": {"on"},
+		"bogus":         {"on"},
+	}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.ParseForm()
+	assert.Equal(t,
+		map[string]bool{"mastodon-work": true},
+		syndicationFormHooks(config, req))
+
+	_, err := syndicationHooks(config, []string{"twitter", "bogus"})
+	assert.NotNil(t, err)
+}
+
+func TestUnsentHooks(t *testing.T) {
+	post := NewPost(PostOpts{Title: "t"})
+	post.FrontMatter["mastodon_id"] = "1"
+	hooks := map[string]bool{"mastodon": true, "bluesky": true}
+
+	assert.Equal(t,
+		map[string]bool{"bluesky": true},
+		unsentHooks(&post, hooks, false))
+	assert.Equal(t, hooks, unsentHooks(&post, hooks, true))
+
+	post.FrontMatter["bluesky_status"] = syndication.StatusUnknown
+	assert.Equal(t, map[string]bool{}, unsentHooks(&post, hooks, false))
+}
+
+func TestSyndicationSummary(t *testing.T) {
+	summary := syndicationSummary([]syndication.Result{
+		{Target: "mastodon", Status: syndication.StatusPosted, URL: "https://m.example/1"},
+		{Target: "bluesky", Status: syndication.StatusFailed, Error: "timeout"},
+		{Target: "webmention", Status: syndication.StatusPosted},
+		{Target: "twitter", Status: syndication.StatusUnknown, Error: "context deadline exceeded"},
+	})
+	assert.Equal(t,
+		"Posted to mastodon (https://m.example/1); bluesky failed: timeout; "+
+			"twitter didn't answer in time, check there before sending it again",
+		summary)
+}
+
+// editableHook records the edits and deletes it is asked for
+type editableHook struct {
+	edits   *[]string
+	deletes *[]string
+}
+
+func (eh editableHook) HandlePost(ctx context.Context, postData syndication.PostData) syndication.Result {
+	return syndication.Result{ID: "1"}
+}
+
+func (eh editableHook) UpdatePost(ctx context.Context, id string, postData syndication.PostData) syndication.Result {
+	*eh.edits = append(*eh.edits, id+":"+postData.Body)
+	return syndication.Result{Status: syndication.StatusUpdated, ID: id}
+}
+
+func (eh editableHook) DeletePost(
+	ctx context.Context, id string, frontMatter map[string]string) error {
+	*eh.deletes = append(*eh.deletes, id)
+	return nil
+}
+
+func TestPropagateToSyndicated(t *testing.T) {
+	var edits, deletes []string
+	syndication.Register("test-editable", syndication.Registration{
+		Options: func() interface{} { return &struct{}{} },
+		New: func(name string, options interface{}) syndication.Hook {
+			return editableHook{&edits, &deletes}
+		},
+	})
+
+	var config Config
+	config.Syndication = []syndication.Target{
+		{Name: "editable", Type: "test-editable"},
+		{Name: "unsent", Type: "test-editable"},
+	}
+
+	post := NewPost(PostOpts{Slug: "edited", Body: "fixed a typo"})
+	post.FrontMatter = map[string]string{
+		"editable_id":    "1",
+		"editable_error": "old failure",
+	}
+
+	targets := postSyndicationTargets(config, nil, &post)
+	assert.True(t, targets[0].Propagates)
+	assert.False(t, targets[1].Propagates)
+
+	results := propagateUpdate(config, &post)
+	assert.Equal(t, []string{"1:fixed a typo"}, edits)
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, "updated", post.FrontMatter["editable_status"])
+	assert.Equal(t, "", post.FrontMatter["editable_error"])
+	assert.Equal(t, "Updated on editable", syndicationSummary(results))
+
+	results = propagateDelete(config, &post)
+	assert.Equal(t, []string{"1"}, deletes)
+	assert.Equal(t, "Deleted from editable", syndicationSummary(results))
+}
+
+func TestSyndicationPreviewHandler(t *testing.T) {
+	var config Config
+	config.Blog.Url = "https://example.com"
+	config.Signin.Username = "me"
+	config.Signin.Password = "pw"
+	config.Syndication = []syndication.Target{
+		{Name: "sky", Type: "bluesky", Options: map[string]interface{}{
+			"template": "{{.Body}} {{.Link}}", "linkformat": "%s"}},
+	}
+
+	handler := CreateSyndicationPreviewFunc(config, nil)
+	form := url.Values{"title": {""}, "body": {"Hello\r\nworld"}}
+
+	req := httptest.NewRequest("POST", "/syndicate/preview", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	req = httptest.NewRequest("POST", "/syndicate/preview", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{
+		Name: "goldfrog", Value: hashAccount("me", "pw")})
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var body struct {
+		Previews []syndication.Preview `json:"previews"`
+	}
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &body))
+	if assert.Equal(t, 1, len(body.Previews)) {
+		preview := body.Previews[0]
+		assert.Equal(t, "sky", preview.Target)
+		assert.Regexp(t, `^Hello\nworld https://example.com/\d{4}/\d{2}/\d{2}/`, preview.Text)
+		assert.Equal(t, 300, preview.MaxLen)
+	}
+}
+
+func TestTootFormOptions(t *testing.T) {
+	var config Config
+	config.Mastodon.Site = "https://m.example"
+	config.Syndication = []syndication.Target{
+		{Name: "work", Type: "mastodon", Label: "Work"},
+	}
+
+	req := httptest.NewRequest("POST", "/new", strings.NewReader(url.Values{
+		"mastodon_visibility": {"public"},
+		"mastodon_cw":         {""},
+		"mastodon_thread":     {"on"},
+		"work_visibility":     {"private"},
+	}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	post := NewPost(PostOpts{Slug: "toot"})
+	post.FrontMatter["mastodon_cw"] = "none"
+	post.FrontMatter["mastodon_language"] = "de"
+	tootFormOptions(config, req, post.FrontMatter)
+	// cleared controls go back to the target's defaults
+	assert.Equal(t, map[string]string{
+		"mastodon_visibility": "public",
+		"mastodon_thread":     "true",
+		"work_visibility":     "private",
+	}, post.FrontMatter)
+
+	opts := postTootOptions(config, &post)
+	if assert.Equal(t, 2, len(opts)) {
+		assert.Equal(t, "work", opts[0].Target)
+		assert.Equal(t, "Work", opts[0].Label)
+		assert.Equal(t, "private", opts[0].Visibility)
+		assert.Equal(t, "", opts[0].Thread)
+		assert.Equal(t, "mastodon", opts[1].Target)
+		assert.Equal(t, "public", opts[1].Visibility)
+		assert.Equal(t, "true", opts[1].Thread)
+		assert.Equal(t, "mastodon_thread", opts[1].Field("thread"))
+	}
+
+	// unchecking thread when editing, without the work controls
+	req = httptest.NewRequest("POST", "/edit", strings.NewReader(url.Values{
+		"mastodon_visibility": {"public"},
+	}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	tootFormOptions(config, req, post.FrontMatter)
+	assert.Equal(t, map[string]string{
+		"mastodon_visibility": "public",
+		"work_visibility":     "private",
+	}, post.FrontMatter)
+
+	assert.Empty(t, postTootOptions(Config{}, nil))
+}
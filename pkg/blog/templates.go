@@ -0,0 +1,203 @@
+package blog
+
+import (
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	tmplText "text/template"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+/*
+TemplateRegistry keeps parsed templates around so that handlers don't
+parse them again on every request. Templates are parsed on first use
+(LoadTemplates parses the page templates up front). In dev mode the
+templates directory is watched and the registry emptied whenever a
+file changes, so edits show up on the next request.
+*/
+type TemplateRegistry struct {
+	TemplatesDir string
+	DevMode      bool
+
+	mu        sync.RWMutex
+	templates map[string]*template.Template
+	texts     map[string]*tmplText.Template
+	watcher   *fsnotify.Watcher
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   *TemplateRegistry
+)
+
+/*
+LoadTemplates creates the template registry used by the handlers and
+parses the page templates in templatesDir. Errors in individual
+templates are returned, but the registry is still installed; those
+templates will report their errors when they are requested.
+*/
+func LoadTemplates(templatesDir string, devMode bool) (*TemplateRegistry, error) {
+	r := NewTemplateRegistry(templatesDir, devMode)
+
+	if devMode {
+		err := r.watch()
+		if err != nil {
+			logger.Errorf("Could not watch templates: %v", err)
+		}
+	}
+
+	err := r.ParseAll()
+
+	registryMu.Lock()
+	if registry != nil {
+		registry.Close()
+	}
+	registry = r
+	registryMu.Unlock()
+
+	return r, err
+}
+
+func NewTemplateRegistry(templatesDir string, devMode bool) *TemplateRegistry {
+	return &TemplateRegistry{
+		TemplatesDir: templatesDir,
+		DevMode:      devMode,
+		templates:    make(map[string]*template.Template),
+		texts:        make(map[string]*tmplText.Template),
+	}
+}
+
+func getRegistry(templatesDir string) *TemplateRegistry {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	if registry != nil && registry.TemplatesDir == templatesDir {
+		return registry
+	}
+	return nil
+}
+
+// ParseAll parses every page template (*.html) and the base feeds
+func (r *TemplateRegistry) ParseAll() error {
+	var firstErr error
+	var count int
+
+	pages, _ := filepath.Glob(filepath.Join(r.TemplatesDir, "*.html"))
+	feeds, _ := filepath.Glob(filepath.Join(r.TemplatesDir, "base/*.xml"))
+
+	for _, path := range append(pages, feeds...) {
+		name, _ := filepath.Rel(r.TemplatesDir, path)
+		name = filepath.ToSlash(name)
+
+		_, err := r.Template(name)
+		if err != nil {
+			logger.Warnf("Could not parse template %s: %v", name, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		count++
+	}
+
+	logger.Infof("Loaded %d templates from %s", count, r.TemplatesDir)
+	return firstErr
+}
+
+// Template returns the html template `name` (with the base templates)
+func (r *TemplateRegistry) Template(name string) (*template.Template, error) {
+	r.mu.RLock()
+	t, ok := r.templates[name]
+	r.mu.RUnlock()
+	if ok {
+		return t, nil
+	}
+
+	t, err := parseTemplate(r.TemplatesDir, name)
+	if err != nil {
+		return t, err
+	}
+
+	r.mu.Lock()
+	r.templates[name] = t
+	r.mu.Unlock()
+	return t, nil
+}
+
+// TextTemplate returns the text template `name` (with the base templates)
+func (r *TemplateRegistry) TextTemplate(name string) (*tmplText.Template, error) {
+	r.mu.RLock()
+	t, ok := r.texts[name]
+	r.mu.RUnlock()
+	if ok {
+		return t, nil
+	}
+
+	t, err := parseTextTemplate(r.TemplatesDir, name)
+	if err != nil {
+		return t, err
+	}
+
+	r.mu.Lock()
+	r.texts[name] = t
+	r.mu.Unlock()
+	return t, nil
+}
+
+// Reset drops all parsed templates
+func (r *TemplateRegistry) Reset() {
+	r.mu.Lock()
+	r.templates = make(map[string]*template.Template)
+	r.texts = make(map[string]*tmplText.Template)
+	r.mu.Unlock()
+}
+
+// Close stops watching the templates directory
+func (r *TemplateRegistry) Close() {
+	if r.watcher != nil {
+		r.watcher.Close()
+	}
+}
+
+func (r *TemplateRegistry) watch() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	r.watcher = watcher
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				// editors write temp files next to the real one
+				if strings.HasPrefix(filepath.Base(event.Name), ".") {
+					continue
+				}
+				logger.Debugf("Template changed: %s, reloading", event)
+				r.Reset()
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logger.Errorf("%s", err)
+			}
+		}
+	}()
+
+	// watch the templates dir and its subdirectories (base/, ...)
+	return filepath.Walk(r.TemplatesDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return watcher.Add(path)
+		}
+		return nil
+	})
+}
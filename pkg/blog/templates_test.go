@@ -0,0 +1,46 @@
+package blog
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplateRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goldfrog-templates")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	os.Mkdir(filepath.Join(dir, "base"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "base/base.html"),
+		[]byte(`{{define "base"}}<b>{{template "content" .}}</b>{{end}}`), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "page.html"),
+		[]byte(`{{define "content"}}one{{end}}`), 0644)
+
+	registry, err := LoadTemplates(dir, false)
+	assert.Nil(t, err)
+	defer registry.Close()
+
+	t1, err := getTemplate(dir, "page.html")
+	assert.Nil(t, err)
+
+	var out bytes.Buffer
+	t1.ExecuteTemplate(&out, "base", nil)
+	assert.Equal(t, "<b>one</b>", out.String())
+
+	// changes are not picked up until the registry is reset
+	ioutil.WriteFile(filepath.Join(dir, "page.html"),
+		[]byte(`{{define "content"}}two{{end}}`), 0644)
+	t2, _ := getTemplate(dir, "page.html")
+	assert.Equal(t, t1, t2)
+
+	registry.Reset()
+	t3, _ := getTemplate(dir, "page.html")
+	out.Reset()
+	t3.ExecuteTemplate(&out, "base", nil)
+	assert.Equal(t, "<b>two</b>", out.String())
+}
@@ -1,13 +1,26 @@
 package blog
 
 import (
+	"crypto/sha1"
 	"fmt"
+	"html/template"
+	"sort"
 	"strings"
 	"time"
 
 	yaml "gopkg.in/yaml.v2"
 )
 
+const (
+	KINDARTICLE  string = "article"
+	KINDNOTE     string = "note"
+	KINDREPLY    string = "reply"
+	KINDLIKE     string = "like"
+	KINDBOOKMARK string = "bookmark"
+	KINDREPOST   string = "repost"
+	KINDPHOTO    string = "photo"
+)
+
 type Blog struct {
 	Title   string            `json:"title"`
 	Subhead string            `json:"subhead"`
@@ -21,9 +34,28 @@ type PostOpts struct {
 	PostDate    time.Time         `json:"date"`
 	Tags        []string          `json:"tags"`
 	FrontMatter map[string]string `json:"frontmatter"`
+	Media       []Media           `json:"media"`
 	Body        string            `json:"body"`
 }
 
+/*
+Media is an image attached to a post. Posts keep their media as a
+list under `media` in their front matter.
+*/
+type Media struct {
+	URL     string `json:"url" yaml:"url"`
+	Alt     string `json:"alt" yaml:"alt"`
+	Caption string `json:"caption,omitempty" yaml:"caption,omitempty"`
+	Type    string `json:"type,omitempty" yaml:"type,omitempty"`
+
+	// set for the uploads processImage could work on
+	Width     int    `json:"width,omitempty" yaml:"width,omitempty"`
+	Height    int    `json:"height,omitempty" yaml:"height,omitempty"`
+	Sizes     []int  `json:"sizes,omitempty" yaml:"sizes,omitempty,flow"`
+	Thumbnail string `json:"thumbnail,omitempty" yaml:"thumbnail,omitempty"`
+	Original  string `json:"original,omitempty" yaml:"original,omitempty"`
+}
+
 type Post struct {
 	ID          int               `json:"post_id"`
 	Title       string            `json:"title"`
@@ -31,6 +63,7 @@ type Post struct {
 	PostDate    time.Time         `json:"date"`
 	Tags        []string          `json:"tags"`
 	FrontMatter map[string]string `json:"frontmatter"`
+	Media       []Media           `json:"media"`
 	Body        string            `json:"body"`
 	User        User              `json:"user"`
 }
@@ -51,10 +84,51 @@ func (post *Post) PermaLink() string {
 	)
 }
 
+/*
+Kind is the kind set by the post's front matter (see postKind), or
+KINDARTICLE for posts with a title and KINDNOTE otherwise.
+*/
+func (post *Post) Kind() string {
+	return postKind(post.Title, post.FrontMatter)
+}
+
+// Author is the `author` front matter entry, empty for the blog's author
+func (post *Post) Author() string {
+	return strings.TrimSpace(post.FrontMatter["author"])
+}
+
+// HTML is the post body rendered from markdown
+func (post *Post) HTML() template.HTML {
+	return renderedMarkdown.GetPost(post)
+}
+
 func (post *Post) PermaShortId() string {
 	return post.Slug
 }
 
+// Revision is a hash of the post content, it changes on every edit
+func (post *Post) Revision() string {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n%s\n",
+		post.Title, post.Slug,
+		post.PostDate.Format(POSTTIMESTAMPFMT), post.TagString())
+
+	keys := make([]string, 0, len(post.FrontMatter))
+	for k := range post.FrontMatter {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(h, "%s: %s\n", k, post.FrontMatter[k])
+	}
+	for _, m := range post.Media {
+		fmt.Fprintf(h, "media: %s %s %s\n", m.URL, m.Alt, m.Caption)
+	}
+
+	fmt.Fprintf(h, "%s", post.Body)
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
 func (post *Post) ToString() string {
 	fileContent := post.FrontMatterYAML()
 
@@ -70,7 +144,17 @@ func (post *Post) FrontMatterYAML() string {
 	fm["slug"] = post.Slug
 	fm["date"] = post.PostDate.Format(POSTTIMESTAMPFMT)
 	fm["tags"] = strings.Join(post.Tags, ",")
-	fmBytes, _ := yaml.Marshal(post.FrontMatter)
+
+	var out interface{} = fm
+	if len(post.Media) > 0 {
+		withMedia := make(map[string]interface{}, len(fm)+1)
+		for k, v := range fm {
+			withMedia[k] = v
+		}
+		withMedia["media"] = post.Media
+		out = withMedia
+	}
+	fmBytes, _ := yaml.Marshal(out)
 	fmStr := string(fmBytes)
 	return fmStr
 }
@@ -86,6 +170,7 @@ func NewPost(opts PostOpts) Post {
 		Slug:     opts.Slug,
 		PostDate: date,
 		Tags:     opts.Tags,
+		Media:    opts.Media,
 		Body:     opts.Body,
 	}
 	if opts.FrontMatter != nil {
@@ -0,0 +1,130 @@
+package blog
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/sivy/goldfrog/pkg/websub"
+)
+
+const (
+	WEBSUBHUBPATH string = "/websub"
+)
+
+// builtinHub is the hub Routes mounts, which pingHub publishes to directly
+var builtinHub *websub.Hub
+
+/*
+initWebSubHub sets up the built-in hub. It only takes publish requests
+from the owner; the blog's own pings skip HTTP.
+*/
+func initWebSubHub(config Config, db *sql.DB) (*websub.Hub, error) {
+	hub, err := websub.NewHub(db, config.WebSubHub(), config.Blog.Url)
+	if err != nil {
+		return nil, err
+	}
+	hub.CanPublish = func(r *http.Request) bool {
+		return checkAPIOwner(config, r)
+	}
+	builtinHub = hub
+	return hub, nil
+}
+
+// WebSubHub is the url of the hub feeds are published through, if any
+func (config Config) WebSubHub() string {
+	if config.WebSub.Hub != "" {
+		return config.WebSub.Hub
+	}
+	if config.WebSub.BuiltinHub {
+		return strings.TrimRight(config.Blog.Url, "/") + WEBSUBHUBPATH
+	}
+	return ""
+}
+
+/*
+WebSubLinks is middleware advertising the hub (and the canonical url of
+the page or feed as the topic) in Link headers, so WebSub subscribers
+can discover them.
+*/
+func WebSubLinks(config Config) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hub := config.WebSubHub()
+			if hub != "" && (r.Method == "GET" || r.Method == "HEAD") {
+				self := strings.TrimRight(config.Blog.Url, "/") + r.URL.Path
+				w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="hub"`, hub))
+				w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="self"`, self))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+/*
+feedTopics lists the urls whose content changes when post is
+published, edited or deleted: the index, the feeds the post shows up
+in and the tag feeds for tags (the post's and any it had before).
+*/
+func feedTopics(config Config, post *Post, extraTags ...string) []string {
+	base := strings.TrimRight(config.Blog.Url, "/")
+
+	author := post.Author()
+	if author == "" {
+		author = config.Blog.Author.Name
+	}
+
+	kindPath := kindPath(post.Kind())
+
+	paths := []string{
+		"/",
+		"/feed.xml",
+		"/feed_daily.xml",
+		kindPath + "/feed.xml",
+		kindPath + "/feed_daily.xml",
+	}
+	if author != "" {
+		paths = append(paths,
+			"/author/"+url.PathEscape(author)+"/feed.xml",
+			"/author/"+url.PathEscape(author)+"/feed_daily.xml")
+	}
+
+	for _, tag := range dedupe(append(extraTags, post.Tags...)) {
+		paths = append(paths,
+			"/tag/"+url.PathEscape(tag)+"/feed.xml",
+			"/tag/"+url.PathEscape(tag)+"/feed_daily.xml")
+	}
+
+	var topics []string
+	for _, p := range paths {
+		topics = append(topics, base+p)
+	}
+	return topics
+}
+
+/*
+pingHub tells the hub that the feeds containing post have changed. It
+runs in the background so publishing doesn't wait on the hub.
+*/
+func pingHub(config Config, post *Post, extraTags ...string) {
+	hub := config.WebSubHub()
+	if hub == "" {
+		return
+	}
+	topics := feedTopics(config, post, extraTags...)
+	if builtinHub != nil && builtinHub.URL == hub {
+		err := builtinHub.Publish(topics...)
+		if err != nil {
+			logger.Errorf("Could not publish to hub: %v", err)
+		}
+		return
+	}
+	go func() {
+		err := websub.Publish(hub, topics...)
+		if err != nil {
+			logger.Errorf("Could not ping hub: %v", err)
+		}
+	}()
+}
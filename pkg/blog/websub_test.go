@@ -0,0 +1,46 @@
+package blog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFeedTopics(t *testing.T) {
+	var config Config
+	config.Blog.Url = "http://monkinetic.blog"
+	p := NewPost(PostOpts{Title: "a title", Tags: []string{"go"}})
+
+	topics := feedTopics(config, &p, "old")
+	assert.Contains(t, topics, "http://monkinetic.blog/feed.xml")
+	assert.Contains(t, topics, "http://monkinetic.blog/articles/feed.xml")
+	assert.Contains(t, topics, "http://monkinetic.blog/tag/go/feed.xml")
+	assert.Contains(t, topics, "http://monkinetic.blog/tag/old/feed.xml")
+	assert.NotContains(t, topics, "http://monkinetic.blog/notes/feed.xml")
+}
+
+func TestWebSubLinks(t *testing.T) {
+	var config Config
+	config.Blog.Url = "http://monkinetic.blog"
+	config.WebSub.BuiltinHub = true
+
+	handler := WebSubLinks(config)(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {}))
+
+	req, _ := http.NewRequest("GET", "/feed.xml", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	links := rr.Header()["Link"]
+	assert.Contains(t, links, `<http://monkinetic.blog/websub>; rel="hub"`)
+	assert.Contains(t, links, `<http://monkinetic.blog/feed.xml>; rel="self"`)
+
+	config.WebSub.BuiltinHub = false
+	handler = WebSubLinks(config)(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {}))
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Empty(t, rr.Header()["Link"])
+}
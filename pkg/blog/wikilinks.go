@@ -0,0 +1,183 @@
+package blog
+
+import (
+	"database/sql"
+	"strings"
+)
+
+/*
+Posts link to each other by slug with [[slug]] or [[slug|text]], which
+are resolved against the posts table when a post is rendered. The wiki
+links in each post are also kept in the post_links table when it's
+indexed (or saved), for the backlinks on post pages and for finding
+links to posts that don't exist.
+*/
+
+// wikiLinkDb is where wiki links are resolved, set up by initWikiLinks
+var wikiLinkDb *sql.DB
+
+func initWikiLinks(db *sql.DB) {
+	err := initPostLinks(db)
+	if err != nil {
+		logger.Errorf("Could not set up wiki links: %v", err)
+	}
+	wikiLinkDb = db
+}
+
+func initPostLinks(db *sql.DB) error {
+	_, err := db.Exec(`
+	CREATE TABLE IF NOT EXISTS post_links (
+		source_id integer,
+		target_slug varchar(256),
+		PRIMARY KEY (source_id, target_slug));
+	`)
+	if err != nil {
+		logger.Errorf("Could not create post_links table: %v", err)
+	}
+	return err
+}
+
+// resolveWikiLink is the permalink and title of the post with slug
+func resolveWikiLink(slug string) (string, string, bool) {
+	if wikiLinkDb == nil {
+		return "", "", false
+	}
+	post, err := GetPostBySlug(wikiLinkDb, slug)
+	if err != nil {
+		return "", "", false
+	}
+	return post.PermaLink(), post.Title, true
+}
+
+// wikiLinkSlugs are the slugs body links to, once each
+func wikiLinkSlugs(body string) []string {
+	var slugs []string
+	seen := make(map[string]bool)
+	for _, link := range markdownRenderer.WikiLinks(body) {
+		if !seen[link.Slug] {
+			seen[link.Slug] = true
+			slugs = append(slugs, link.Slug)
+		}
+	}
+	return slugs
+}
+
+// indexPostLinks replaces the links of post in the link graph
+func indexPostLinks(db *sql.DB, post *Post) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM post_links WHERE source_id = ?`, post.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, slug := range wikiLinkSlugs(post.Body) {
+		if slug == post.Slug {
+			continue
+		}
+		_, err = tx.Exec(`
+		INSERT INTO post_links (source_id, target_slug) VALUES (?, ?)
+		`, post.ID, slug)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// unlinkPost removes the links of the post with postID from the link graph
+func unlinkPost(db *sql.DB, postID string) error {
+	_, err := db.Exec(`DELETE FROM post_links WHERE source_id = ?`, postID)
+	return err
+}
+
+// GetBacklinks gets the posts linking to the post with slug, newest first
+func GetBacklinks(db *sql.DB, slug string) []*Post {
+	rows, err := db.Query(`
+		SELECT
+			posts.id,
+			posts.title,
+			posts.slug,
+			posts.postdate,
+			posts.tags,
+			posts.frontmatter,
+			posts.body
+		FROM posts
+		JOIN post_links ON post_links.source_id = posts.id
+		WHERE post_links.target_slug = ?
+		ORDER BY posts.postdate DESC
+	`, slug)
+	if err != nil {
+		logger.Errorf("Could not get backlinks of %s: %v", slug, err)
+		return nil
+	}
+	defer rows.Close()
+	return rowsToPosts(rows)
+}
+
+/*
+forgetBacklinks drops the renderings of the posts linking to slug, so
+their links to it are resolved again.
+*/
+func forgetBacklinks(db *sql.DB, slug string) {
+	for _, post := range GetBacklinks(db, slug) {
+		renderedMarkdown.Forget(post.Body)
+	}
+}
+
+// BrokenLink is a wiki link to a post that doesn't exist
+type BrokenLink struct {
+	PostID    int
+	PostSlug  string
+	PostTitle string
+	Target    string
+}
+
+// GetBrokenLinks finds the wiki links in the link graph to missing posts
+func GetBrokenLinks(db *sql.DB) ([]BrokenLink, error) {
+	rows, err := db.Query(`
+		SELECT posts.id, posts.slug, posts.title, post_links.target_slug
+		FROM post_links
+		JOIN posts ON posts.id = post_links.source_id
+		LEFT JOIN posts AS targets ON targets.slug = post_links.target_slug
+		WHERE targets.id IS NULL
+		ORDER BY posts.postdate, post_links.target_slug
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []BrokenLink
+	for rows.Next() {
+		var link BrokenLink
+		err := rows.Scan(&link.PostID, &link.PostSlug, &link.PostTitle, &link.Target)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+// brokenWikiLinks are the slugs body links to that have no post
+func brokenWikiLinks(db *sql.DB, body string) []string {
+	var broken []string
+	for _, slug := range wikiLinkSlugs(body) {
+		if _, err := GetPostBySlug(db, slug); err != nil {
+			broken = append(broken, slug)
+		}
+	}
+	return broken
+}
+
+// brokenLinksSummary is the flash message for a post's broken links
+func brokenLinksSummary(broken []string) string {
+	if len(broken) == 0 {
+		return ""
+	}
+	return "No posts for links to " + strings.Join(broken, ", ")
+}
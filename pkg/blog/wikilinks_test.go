@@ -0,0 +1,117 @@
+package blog
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWikiLinks(t *testing.T) {
+	assert.Nil(t, initDb(testDb))
+	defer os.Remove(testDb)
+	db, _ := GetDb(testDb)
+	repo := &NullPostsRepo{}
+
+	initWikiLinks(db)
+	defer func() { wikiLinkDb = nil }()
+
+	date := time.Date(2020, 1, 2, 12, 0, 0, 0, time.UTC)
+	a := NewPost(PostOpts{
+		Title: "A", Slug: "a", PostDate: date,
+		Body: "See [[b]] and [[missing|this]], not [[a]]",
+	})
+	postA, err := storePost(db, repo, &a, true)
+	assert.Nil(t, err)
+	assert.Contains(t, string(markDowner(postA.Body)),
+		`<span class="wikilink wikilink-broken" title="No post b">b</span>`)
+
+	links, err := GetBrokenLinks(db)
+	assert.Nil(t, err)
+	assert.Equal(t, []BrokenLink{
+		{PostID: postA.ID, PostSlug: "a", PostTitle: "A", Target: "b"},
+		{PostID: postA.ID, PostSlug: "a", PostTitle: "A", Target: "missing"},
+	}, links)
+
+	// the link to b resolves once it's there
+	b := NewPost(PostOpts{Title: "B", Slug: "b", PostDate: date, Body: "Hi"})
+	postB, err := storePost(db, repo, &b, true)
+	assert.Nil(t, err)
+	assert.Contains(t, string(markDowner(postA.Body)),
+		`See <a class="wikilink" href="/2020/01/02/b">B</a>`)
+	assert.Equal(t, []string{"missing"}, brokenWikiLinks(db, postA.Body))
+	assert.Equal(t, "No posts for links to missing",
+		brokenLinksSummary(brokenWikiLinks(db, postA.Body)))
+
+	backlinks := GetBacklinks(db, "b")
+	assert.Len(t, backlinks, 1)
+	assert.Equal(t, "a", backlinks[0].Slug)
+	assert.Len(t, GetBacklinks(db, "a"), 0)
+
+	assert.Nil(t, DeletePost(db, strconv.Itoa(postB.ID)))
+	links, _ = GetBrokenLinks(db)
+	assert.Len(t, links, 2)
+	assert.Contains(t, string(markDowner(postA.Body)), "wikilink-broken\" title=\"No post b\"")
+
+	assert.Nil(t, DeletePost(db, strconv.Itoa(postA.ID)))
+	links, _ = GetBrokenLinks(db)
+	assert.Len(t, links, 0)
+}
+
+func TestIndexFileLinks(t *testing.T) {
+	assert.Nil(t, initDb(testDb))
+	defer os.Remove(testDb)
+	db, _ := GetDb(testDb)
+
+	dir, _ := ioutil.TempDir("", "goldfrog-posts")
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "2020-01-02-linking.md")
+	ioutil.WriteFile(file, []byte("title: linking\nslug: linking\ndate: \"2020-01-02T12:00:00Z\"\n---\nSee [[test-post]]\n"), 0644)
+
+	assert.Equal(t, 1, IndexFile(file, db, false))
+	assert.Equal(t, 0, len(GetBacklinks(db, "linking")))
+	backlinks := GetBacklinks(db, "test-post")
+	assert.Len(t, backlinks, 1)
+	assert.Equal(t, "linking", backlinks[0].Slug)
+}
+
+func TestPostPageBacklinks(t *testing.T) {
+	assert.Nil(t, initDb(testDb))
+	defer os.Remove(testDb)
+	db, _ := GetDb(testDb)
+	repo := &NullPostsRepo{}
+
+	dir, _ := ioutil.TempDir("", "goldfrog-templates")
+	defer os.RemoveAll(dir)
+	var config Config
+	config.TemplatesDir = dir
+	os.MkdirAll(filepath.Join(dir, "base"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "base", "base.html"),
+		[]byte(`{{define "base"}}{{range .Backlinks}}{{.Slug}};{{end}}{{end}}`), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "post_detail.html"), []byte(""), 0644)
+
+	date := time.Date(2020, 1, 2, 12, 0, 0, 0, time.UTC)
+	for _, p := range []Post{
+		NewPost(PostOpts{Title: "Target", Slug: "target", PostDate: date}),
+		NewPost(PostOpts{Title: "Older", Slug: "older", PostDate: date.Add(time.Hour), Body: "[[target]]"}),
+		NewPost(PostOpts{Title: "Newer", Slug: "newer", PostDate: date.Add(2 * time.Hour), Body: "[[target|T]]"}),
+	} {
+		p := p
+		_, err := storePost(db, repo, &p, true)
+		assert.Nil(t, err)
+	}
+
+	r := Routes(config, db, repo, "")
+	defer func() { wikiLinkDb = nil }()
+	req := httptest.NewRequest("GET", "/2020/01/02/target", nil)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "newer;older;", rr.Body.String())
+}
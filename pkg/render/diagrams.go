@@ -0,0 +1,163 @@
+package render
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gomarkdown/markdown/ast"
+)
+
+/*
+With the Diagrams extension, fenced code blocks in a diagram language
+(```dot, ```mermaid) are shown as the SVG their tool draws. Tools are
+slow and may not be installed where pages are rendered, so diagrams are
+drawn ahead of time, when a post is saved, into a DiagramCache keyed by
+a hash of their source. A diagram that isn't in the cache is shown as
+its code.
+*/
+
+// DiagramTools are the commands that read a diagram on stdin and write SVG
+var DiagramTools = map[string][]string{
+	"dot":     {"dot", "-Tsvg"},
+	"mermaid": {"mmdc", "--input", "-", "--output", "-", "--outputFormat", "svg", "--quiet"},
+}
+
+// DiagramTimeout is how long a diagram tool gets to draw one diagram
+const DiagramTimeout = 30 * time.Second
+
+type DiagramCache struct {
+	Dir   string
+	Tools map[string][]string
+}
+
+// NewDiagramCache keeps diagrams drawn with the DiagramTools in dir
+func NewDiagramCache(dir string) *DiagramCache {
+	tools := make(map[string][]string)
+	for kind, command := range DiagramTools {
+		tools[kind] = command
+	}
+	return &DiagramCache{Dir: dir, Tools: tools}
+}
+
+func (c *DiagramCache) path(kind string, source string) string {
+	return filepath.Join(c.Dir, fmt.Sprintf(
+		"%s-%x.svg", kind, sha1.Sum([]byte(source))))
+}
+
+// Get is the cached SVG for source, if it's been drawn
+func (c *DiagramCache) Get(kind string, source string) (string, bool) {
+	content, err := ioutil.ReadFile(c.path(kind, source))
+	if err != nil {
+		return "", false
+	}
+	return string(content), true
+}
+
+// Draw runs kind's tool over source and caches the SVG
+func (c *DiagramCache) Draw(kind string, source string) error {
+	command, ok := c.Tools[kind]
+	if !ok || len(command) == 0 {
+		return fmt.Errorf("No tool for %s diagrams", kind)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DiagramTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Stdin = strings.NewReader(source)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("Could not draw %s diagram: %v %s",
+			kind, err, strings.TrimSpace(stderr.String()))
+	}
+
+	svg := stdout.String()
+	start := strings.Index(svg, "<svg")
+	if start < 0 {
+		return fmt.Errorf("Could not draw %s diagram: no svg in output", kind)
+	}
+
+	err = os.MkdirAll(c.Dir, 0755)
+	if err != nil {
+		return err
+	}
+	// write then rename, so pages never read half a diagram
+	file := c.path(kind, source)
+	tmp := file + ".tmp"
+	err = ioutil.WriteFile(tmp, []byte(strings.TrimSpace(svg[start:])), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// diagram is the kind and source of a diagram code block
+func (c *DiagramCache) diagram(node ast.Node) (string, string, bool) {
+	block, ok := node.(*ast.CodeBlock)
+	if !ok || !block.IsFenced {
+		return "", "", false
+	}
+	fields := strings.Fields(string(block.Info))
+	if len(fields) == 0 {
+		return "", "", false
+	}
+	kind := strings.ToLower(strings.TrimPrefix(fields[0], "."))
+	if _, ok := c.Tools[kind]; !ok {
+		return "", "", false
+	}
+	return kind, string(block.Literal), true
+}
+
+/*
+Prerender draws the diagrams in content that aren't in the cache yet,
+returning the first error, after trying them all.
+*/
+func (r *Renderer) Prerender(content string) error {
+	if r.Extensions&Diagrams == 0 || r.DiagramCache == nil {
+		return nil
+	}
+	var firstErr error
+	content, _ = r.expand(content)
+	ast.WalkFunc(r.Parse(content), func(node ast.Node, entering bool) ast.WalkStatus {
+		kind, source, ok := r.DiagramCache.diagram(node)
+		if !ok || !entering {
+			return ast.GoToNext
+		}
+		if _, cached := r.DiagramCache.Get(kind, source); cached {
+			return ast.GoToNext
+		}
+		if err := r.DiagramCache.Draw(kind, source); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		return ast.GoToNext
+	})
+	return firstErr
+}
+
+// renderDiagram is the raw hook the Diagrams extension renders diagrams with
+func (r *Renderer) renderDiagram(node ast.Node) (string, bool) {
+	if r.DiagramCache == nil {
+		return "", false
+	}
+	kind, source, ok := r.DiagramCache.diagram(node)
+	if !ok {
+		return "", false
+	}
+	svg, ok := r.DiagramCache.Get(kind, source)
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf(
+		"<figure class=\"diagram diagram-%s\">%s</figure>\n", kind, svg), true
+}
@@ -0,0 +1,50 @@
+package render
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiagrams(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diagrams")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	r := New(DefaultExtensions)
+	r.DiagramCache = NewDiagramCache(dir)
+	r.DiagramCache.Tools["dot"] = []string{
+		"sh", "-c", `echo '<?xml version="1.0"?>'; printf '<svg><text>'; cat; printf '</text></svg>'`}
+	r.DiagramCache.Tools["mermaid"] = []string{"false"}
+
+	content := "Before\n\n```dot\ndigraph { a -> b }\n```\n"
+
+	// not drawn yet, so it's code
+	html := string(r.HTML(content))
+	assert.Contains(t, html, "<pre")
+	assert.NotContains(t, html, "<svg")
+
+	assert.Nil(t, r.Prerender(content))
+	files, _ := filepath.Glob(filepath.Join(dir, "dot-*.svg"))
+	assert.Len(t, files, 1)
+
+	html = string(r.HTML(content))
+	assert.Contains(t, html,
+		"<figure class=\"diagram diagram-dot\"><svg><text>digraph { a -> b }\n</text></svg></figure>")
+	assert.NotContains(t, html, "<?xml")
+	assert.NotContains(t, html, "<pre")
+
+	// cached, so it isn't drawn again
+	r.DiagramCache.Tools["dot"] = []string{"false"}
+	assert.Nil(t, r.Prerender(content))
+
+	err = r.Prerender("```mermaid\ngraph TD; A-->B\n```\n")
+	assert.Contains(t, err.Error(), "Could not draw mermaid diagram")
+	assert.Contains(t, string(r.HTML("```mermaid\ngraph TD; A-->B\n```\n")), "<pre")
+
+	// plain text leaves diagrams as their code
+	assert.Contains(t, r.Text(content), "digraph { a -&gt; b }")
+}
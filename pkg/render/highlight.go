@@ -0,0 +1,162 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/chroma"
+	chromahtml "github.com/alecthomas/chroma/formatters/html"
+	"github.com/alecthomas/chroma/lexers"
+	"github.com/alecthomas/chroma/styles"
+	"github.com/gomarkdown/markdown/ast"
+)
+
+/*
+With the Highlighting extension, fenced code blocks are highlighted by
+chroma, as spans with classes styled by the stylesheet WriteStylesheet
+writes. Options follow the language in braces:
+
+	```{go linenos hl_lines=2,4-6 linenostart=10}
+
+linenos numbers the lines (linenos=table puts the numbers in a column
+of their own), hl_lines highlights lines and linenostart is the number
+of the first line.
+*/
+
+// DefaultTheme is the chroma style stylesheets are written with by default
+const DefaultTheme = "github"
+
+// CodeOptions are read from the info string of a fenced code block
+type CodeOptions struct {
+	Language    string
+	LineNumbers bool
+	Table       bool
+	LineStart   int
+	Highlight   [][2]int
+}
+
+// ParseCodeInfo reads a code block's info string, like "go linenos hl_lines=3"
+func ParseCodeInfo(info string) (CodeOptions, error) {
+	opts := CodeOptions{LineStart: 1}
+	fields := strings.Fields(strings.NewReplacer(`"`, "", "'", "").Replace(info))
+	for i, field := range fields {
+		key, value := field, ""
+		if eq := strings.Index(field, "="); eq >= 0 {
+			key, value = field[:eq], field[eq+1:]
+		}
+		switch key {
+		case "linenos":
+			opts.LineNumbers = value != "false"
+			opts.Table = value == "table"
+		case "hl_lines", "hl":
+			ranges, err := parseLineRanges(value)
+			if err != nil {
+				return opts, err
+			}
+			opts.Highlight = append(opts.Highlight, ranges...)
+		case "linenostart":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return opts, fmt.Errorf("Bad linenostart: %s", value)
+			}
+			opts.LineStart = n
+		default:
+			if i == 0 && value == "" {
+				opts.Language = strings.TrimPrefix(key, ".")
+				continue
+			}
+			return opts, fmt.Errorf("Unknown code block option: %s", key)
+		}
+	}
+	return opts, nil
+}
+
+// parseLineRanges reads lines and ranges of lines, like 2,4-6
+func parseLineRanges(value string) ([][2]int, error) {
+	var ranges [][2]int
+	for _, part := range strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == '[' || r == ']'
+	}) {
+		bounds := strings.SplitN(part, "-", 2)
+		start, err := strconv.Atoi(bounds[0])
+		end := start
+		if err == nil && len(bounds) == 2 {
+			end, err = strconv.Atoi(bounds[1])
+		}
+		if err != nil || end < start {
+			return nil, fmt.Errorf("Bad line range: %s", part)
+		}
+		ranges = append(ranges, [2]int{start, end})
+	}
+	return ranges, nil
+}
+
+/*
+highlightCode renders fenced code blocks with chroma, leaving the ones
+without a language it knows (and no options) to the html renderer.
+*/
+func highlightCode(w io.Writer, node ast.Node, entering bool) (ast.WalkStatus, bool) {
+	block, ok := node.(*ast.CodeBlock)
+	if !ok || !block.IsFenced {
+		return ast.GoToNext, false
+	}
+	info := string(block.Info)
+	opts, err := ParseCodeInfo(info)
+	if err != nil {
+		logger.Warnf("Code block {%s}: %v", info, err)
+	}
+
+	lexer := lexers.Get(opts.Language)
+	if lexer == nil {
+		if !opts.LineNumbers && len(opts.Highlight) == 0 {
+			return ast.GoToNext, false
+		}
+		lexer = lexers.Fallback
+	}
+	iterator, err := chroma.Coalesce(lexer).Tokenise(nil, string(block.Literal))
+	if err != nil {
+		logger.Warnf("Could not highlight %s code: %v", opts.Language, err)
+		return ast.GoToNext, false
+	}
+
+	// numbers are relative to linenostart
+	highlight := make([][2]int, len(opts.Highlight))
+	for i, r := range opts.Highlight {
+		highlight[i] = [2]int{r[0] + opts.LineStart - 1, r[1] + opts.LineStart - 1}
+	}
+	formatter := chromahtml.New(
+		chromahtml.WithClasses(true),
+		chromahtml.WithLineNumbers(opts.LineNumbers),
+		chromahtml.LineNumbersInTable(opts.Table),
+		chromahtml.BaseLineNumber(opts.LineStart),
+		chromahtml.HighlightLines(highlight),
+	)
+	var buf bytes.Buffer
+	if err := formatter.Format(&buf, styles.Fallback, iterator); err != nil {
+		logger.Warnf("Could not highlight %s code: %v", opts.Language, err)
+		return ast.GoToNext, false
+	}
+	buf.WriteString("\n")
+	w.Write(buf.Bytes())
+	return ast.GoToNext, true
+}
+
+// Themes are the chroma styles stylesheets can be written with
+func Themes() []string {
+	names := styles.Names()
+	sort.Strings(names)
+	return names
+}
+
+// WriteStylesheet writes the css for highlighted code in theme
+func WriteStylesheet(w io.Writer, theme string) error {
+	style, ok := styles.Registry[strings.ToLower(theme)]
+	if !ok {
+		return fmt.Errorf("Unknown theme: %s", theme)
+	}
+	return chromahtml.New(chromahtml.WithClasses(true)).WriteCSS(w, style)
+}
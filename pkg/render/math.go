@@ -0,0 +1,579 @@
+package render
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+
+	"github.com/gomarkdown/markdown/ast"
+)
+
+/*
+TeXToMathML converts the TeX (LaTeX math) in $...$ and $$...$$ to
+MathML, so browsers show formulas without any scripts. It covers what
+posts tend to use: scripts, fractions, roots, greek letters and the
+usual symbols, \text and font commands, \left...\right, accents and
+matrix-like environments. Anything it doesn't know is shown as an
+error in place, the rest of the formula is still rendered.
+*/
+func TeXToMathML(tex string, display bool) string {
+	p := &mathParser{tokens: tokenizeTeX(tex), display: display}
+	body := p.parseRow(nil)
+	for p.isSymbol("}") {
+		// unbalanced, carry on after it
+		p.next()
+		body += p.parseRow(nil)
+	}
+	mode := "inline"
+	if display {
+		mode = "block"
+	}
+	return fmt.Sprintf(
+		`<math xmlns="http://www.w3.org/1998/Math/MathML" display="%s">`+
+			`<semantics><mrow>%s</mrow>`+
+			`<annotation encoding="application/x-tex">%s</annotation>`+
+			`</semantics></math>`,
+		mode, body, html.EscapeString(strings.TrimSpace(tex)))
+}
+
+// renderMath is the raw hook the Math extension renders math with
+func renderMath(node ast.Node) (string, bool) {
+	switch n := node.(type) {
+	case *ast.Math:
+		return TeXToMathML(string(n.Literal), false), true
+	case *ast.MathBlock:
+		return TeXToMathML(string(n.Literal), true) + "\n", true
+	}
+	return "", false
+}
+
+type texTokenKind int
+
+const (
+	texCommand texTokenKind = iota
+	texLetter
+	texNumber
+	texSymbol
+)
+
+type texToken struct {
+	kind  texTokenKind
+	value string
+	// spaced is set when there's space before the token, which only
+	// matters in \text
+	spaced bool
+}
+
+func tokenizeTeX(tex string) []texToken {
+	var tokens []texToken
+	runes := []rune(tex)
+	spaced := false
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if unicode.IsSpace(r) {
+			spaced = true
+			continue
+		}
+		n := len(tokens)
+		switch {
+		case r == '\\':
+			j := i + 1
+			for j < len(runes) && unicode.IsLetter(runes[j]) {
+				j++
+			}
+			if j == i+1 && j < len(runes) {
+				// a single character command, like \, or \{
+				j++
+			}
+			tokens = append(tokens, texToken{kind: texCommand, value: string(runes[i+1 : j])})
+			i = j - 1
+		case unicode.IsDigit(r) || (r == '.' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			j := i
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || runes[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, texToken{kind: texNumber, value: string(runes[i:j])})
+			i = j - 1
+		case unicode.IsLetter(r):
+			tokens = append(tokens, texToken{kind: texLetter, value: string(r)})
+		default:
+			tokens = append(tokens, texToken{kind: texSymbol, value: string(r)})
+		}
+		tokens[n].spaced = spaced
+		spaced = false
+	}
+	return tokens
+}
+
+type mathParser struct {
+	tokens  []texToken
+	pos     int
+	display bool
+	// variant is the mathvariant of identifiers, set by \mathbf and co
+	variant string
+}
+
+func (p *mathParser) peek() (texToken, bool) {
+	if p.pos >= len(p.tokens) {
+		return texToken{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+func (p *mathParser) next() (texToken, bool) {
+	t, ok := p.peek()
+	if ok {
+		p.pos++
+	}
+	return t, ok
+}
+
+func (p *mathParser) isSymbol(value string) bool {
+	t, ok := p.peek()
+	return ok && t.kind == texSymbol && t.value == value
+}
+
+func (p *mathParser) isCommand(values ...string) bool {
+	t, ok := p.peek()
+	if !ok || t.kind != texCommand {
+		return false
+	}
+	for _, v := range values {
+		if t.value == v {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+parseRow parses items up to the end, a closing brace or whatever stop
+says ends the row (which is left for the caller).
+*/
+func (p *mathParser) parseRow(stop func() bool) string {
+	var items []string
+	for {
+		if _, ok := p.peek(); !ok || p.isSymbol("}") || (stop != nil && stop()) {
+			break
+		}
+		items = append(items, p.parseScripted())
+	}
+	return strings.Join(items, "")
+}
+
+// parseScripted parses an atom with its sub- and superscripts
+func (p *mathParser) parseScripted() string {
+	base, limits := p.parseAtom()
+	var sub, sup string
+	hasSub, hasSup := false, false
+	for {
+		switch {
+		case p.isSymbol("^") && !hasSup:
+			p.next()
+			sup = p.parseArg()
+			hasSup = true
+		case p.isSymbol("_") && !hasSub:
+			p.next()
+			sub = p.parseArg()
+			hasSub = true
+		case p.isSymbol("'") && !hasSup:
+			p.next()
+			sup = "<mo>′</mo>"
+			hasSup = true
+		default:
+			under, over := "msub", "msup"
+			both := "msubsup"
+			if limits && p.display {
+				under, over, both = "munder", "mover", "munderover"
+			}
+			switch {
+			case hasSub && hasSup:
+				return fmt.Sprintf("<%s>%s%s%s</%s>", both, base, sub, sup, both)
+			case hasSub:
+				return fmt.Sprintf("<%s>%s%s</%s>", under, base, sub, under)
+			case hasSup:
+				return fmt.Sprintf("<%s>%s%s</%s>", over, base, sup, over)
+			}
+			return base
+		}
+	}
+}
+
+// row makes a single element of items, as scripts and fractions need
+func row(items string) string {
+	if items == "" {
+		return "<mrow></mrow>"
+	}
+	return "<mrow>" + items + "</mrow>"
+}
+
+// parseArg parses a command's argument: a group or a single atom
+func (p *mathParser) parseArg() string {
+	if p.isSymbol("{") {
+		p.next()
+		items := p.parseRow(nil)
+		p.expect("}")
+		return row(items)
+	}
+	atom, _ := p.parseAtom()
+	return row(atom)
+}
+
+// parseText reads a group as it's written, for \text
+func (p *mathParser) parseText() string {
+	if !p.isSymbol("{") {
+		t, _ := p.next()
+		return t.value
+	}
+	p.next()
+	var b strings.Builder
+	depth := 0
+	for {
+		t, ok := p.next()
+		if !ok {
+			break
+		}
+		if t.kind == texSymbol && t.value == "{" {
+			depth++
+		}
+		if t.kind == texSymbol && t.value == "}" {
+			if depth == 0 {
+				if t.spaced && b.Len() > 0 {
+					b.WriteString(" ")
+				}
+				break
+			}
+			depth--
+		}
+		if b.Len() > 0 && t.spaced {
+			b.WriteString(" ")
+		}
+		if t.kind == texCommand {
+			b.WriteString(texEscapes[t.value])
+		} else {
+			b.WriteString(t.value)
+		}
+	}
+	return b.String()
+}
+
+func (p *mathParser) expect(symbol string) {
+	if p.isSymbol(symbol) {
+		p.next()
+	}
+}
+
+func mo(s string) string {
+	return "<mo>" + html.EscapeString(s) + "</mo>"
+}
+
+func (p *mathParser) mi(s string, normal bool) string {
+	variant := p.variant
+	if variant == "" && normal {
+		variant = "normal"
+	}
+	if variant != "" {
+		return fmt.Sprintf(`<mi mathvariant="%s">%s</mi>`, variant, html.EscapeString(s))
+	}
+	return "<mi>" + html.EscapeString(s) + "</mi>"
+}
+
+func merror(s string) string {
+	return "<merror><mtext>" + html.EscapeString(s) + "</mtext></merror>"
+}
+
+// parseAtom parses one thing, and tells whether it takes limits
+func (p *mathParser) parseAtom() (string, bool) {
+	t, ok := p.next()
+	if !ok {
+		return "", false
+	}
+	switch t.kind {
+	case texLetter:
+		return p.mi(t.value, false), false
+	case texNumber:
+		return "<mn>" + t.value + "</mn>", false
+	case texSymbol:
+		switch t.value {
+		case "{":
+			items := p.parseRow(nil)
+			p.expect("}")
+			return row(items), false
+		case "}", "&":
+			return "", false
+		case "-":
+			return mo("−"), false
+		case "*":
+			return mo("∗"), false
+		}
+		return mo(t.value), false
+	}
+	return p.parseCommand(t.value)
+}
+
+func (p *mathParser) parseCommand(name string) (string, bool) {
+	if s, ok := texIdentifiers[name]; ok {
+		return p.mi(s, false), false
+	}
+	if s, ok := texUprightIdentifiers[name]; ok {
+		return p.mi(s, true), false
+	}
+	if s, ok := texOperators[name]; ok {
+		return mo(s), false
+	}
+	if s, ok := texLargeOperators[name]; ok {
+		// integrals keep their limits at the side, like TeX
+		return fmt.Sprintf(`<mo largeop="true" movablelimits="true">%s</mo>`, s),
+			!strings.Contains(name, "int")
+	}
+	if texFunctions[name] {
+		return p.mi(name, true), name == "lim" || name == "max" || name == "min" ||
+			name == "sup" || name == "inf" || name == "det" || name == "gcd"
+	}
+	if s, ok := texEscapes[name]; ok {
+		return mo(s), false
+	}
+	if width, ok := texSpaces[name]; ok {
+		return fmt.Sprintf(`<mspace width="%s"></mspace>`, width), false
+	}
+	if variant, ok := texVariants[name]; ok {
+		old := p.variant
+		p.variant = variant
+		arg := p.parseArg()
+		p.variant = old
+		return arg, false
+	}
+	if accent, ok := texAccents[name]; ok {
+		return fmt.Sprintf(`<mover accent="true">%s<mo stretchy="false">%s</mo></mover>`,
+			p.parseArg(), accent), false
+	}
+
+	switch name {
+	case "frac", "dfrac", "tfrac", "cfrac":
+		num := p.parseArg()
+		den := p.parseArg()
+		return fmt.Sprintf("<mfrac>%s%s</mfrac>", num, den), false
+	case "binom":
+		top := p.parseArg()
+		bottom := p.parseArg()
+		return fmt.Sprintf(`<mrow><mo>(</mo><mfrac linethickness="0">%s%s</mfrac><mo>)</mo></mrow>`,
+			top, bottom), false
+	case "sqrt":
+		if p.isSymbol("[") {
+			p.next()
+			index := p.parseRow(func() bool { return p.isSymbol("]") })
+			p.expect("]")
+			return fmt.Sprintf("<mroot>%s%s</mroot>", p.parseArg(), row(index)), false
+		}
+		return fmt.Sprintf("<msqrt>%s</msqrt>", p.parseArg()), false
+	case "text", "textrm", "textnormal", "mbox", "textit", "textbf":
+		return "<mtext>" + html.EscapeString(p.parseText()) + "</mtext>", false
+	case "operatorname":
+		return p.mi(p.parseText(), true), false
+	case "underline":
+		return fmt.Sprintf(`<munder accentunder="true">%s<mo stretchy="true">_</mo></munder>`,
+			p.parseArg()), false
+	case "overbrace":
+		return fmt.Sprintf(`<mover>%s<mo stretchy="true">⏞</mo></mover>`, p.parseArg()), true
+	case "underbrace":
+		return fmt.Sprintf(`<munder>%s<mo stretchy="true">⏟</mo></munder>`, p.parseArg()), true
+	case "left":
+		open := p.delimiter()
+		inner := p.parseRow(func() bool { return p.isCommand("right") })
+		close := ""
+		if p.isCommand("right") {
+			p.next()
+			close = p.delimiter()
+		}
+		return fmt.Sprintf(`<mrow>%s%s%s</mrow>`,
+			stretchy(open), inner, stretchy(close)), false
+	case "right":
+		// without a \left
+		return stretchy(p.delimiter()), false
+	case "big", "Big", "bigg", "Bigg", "bigl", "bigr", "Bigl", "Bigr", "biggl", "biggr":
+		return mo(p.delimiter()), false
+	case "begin":
+		return p.parseEnvironment(), false
+	case "\\", "displaystyle", "textstyle", "limits", "nolimits":
+		return "", false
+	}
+	return merror(`\` + name), false
+}
+
+// delimiter reads the delimiter after \left, \right or \big
+func (p *mathParser) delimiter() string {
+	t, ok := p.next()
+	if !ok {
+		return ""
+	}
+	if t.kind == texCommand {
+		if s, ok := texEscapes[t.value]; ok {
+			return s
+		}
+		if s, ok := texOperators[t.value]; ok {
+			return s
+		}
+		return ""
+	}
+	if t.value == "." {
+		return ""
+	}
+	return t.value
+}
+
+func stretchy(delim string) string {
+	if delim == "" {
+		return ""
+	}
+	return `<mo stretchy="true">` + html.EscapeString(delim) + "</mo>"
+}
+
+var texEnvironmentDelimiters = map[string][2]string{
+	"pmatrix": {"(", ")"},
+	"bmatrix": {"[", "]"},
+	"Bmatrix": {"{", "}"},
+	"vmatrix": {"|", "|"},
+	"Vmatrix": {"‖", "‖"},
+	"cases":   {"{", ""},
+}
+
+// parseEnvironment parses \begin{name}...\end{name} into a table
+func (p *mathParser) parseEnvironment() string {
+	name := p.parseText()
+	if name == "array" && p.isSymbol("{") {
+		// the column spec
+		p.parseText()
+	}
+
+	var rows [][]string
+	cells := []string{}
+	for {
+		cell := p.parseRow(func() bool {
+			return p.isSymbol("&") || p.isCommand("\\", "end")
+		})
+		cells = append(cells, cell)
+		t, ok := p.next()
+		if !ok {
+			break
+		}
+		if t.kind == texSymbol && t.value == "&" {
+			continue
+		}
+		// a \\ at the end doesn't start another row
+		if t.value != "end" || len(cells) > 1 || cells[0] != "" {
+			rows = append(rows, cells)
+		}
+		cells = []string{}
+		if t.value == "end" {
+			p.parseText()
+			break
+		}
+	}
+	if len(cells) > 1 || (len(cells) == 1 && cells[0] != "") {
+		rows = append(rows, cells)
+	}
+
+	align := ""
+	if name == "cases" || name == "aligned" || name == "align" || name == "align*" {
+		align = ` columnalign="left"`
+		if name != "cases" {
+			align = ` columnalign="right left"`
+		}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "<mtable%s>", align)
+	for _, cells := range rows {
+		b.WriteString("<mtr>")
+		for _, cell := range cells {
+			b.WriteString("<mtd>" + cell + "</mtd>")
+		}
+		b.WriteString("</mtr>")
+	}
+	b.WriteString("</mtable>")
+
+	if delims, ok := texEnvironmentDelimiters[name]; ok {
+		return fmt.Sprintf("<mrow>%s%s%s</mrow>",
+			stretchy(delims[0]), b.String(), stretchy(delims[1]))
+	}
+	return b.String()
+}
+
+var texIdentifiers = map[string]string{
+	"alpha": "α", "beta": "β", "gamma": "γ", "delta": "δ", "epsilon": "ϵ",
+	"varepsilon": "ε", "zeta": "ζ", "eta": "η", "theta": "θ", "vartheta": "ϑ",
+	"iota": "ι", "kappa": "κ", "lambda": "λ", "mu": "μ", "nu": "ν", "xi": "ξ",
+	"pi": "π", "varpi": "ϖ", "rho": "ρ", "varrho": "ϱ", "sigma": "σ",
+	"varsigma": "ς", "tau": "τ", "upsilon": "υ", "phi": "ϕ", "varphi": "φ",
+	"chi": "χ", "psi": "ψ", "omega": "ω",
+	"ell": "ℓ", "hbar": "ℏ", "imath": "ı", "jmath": "ȷ", "wp": "℘",
+}
+
+var texUprightIdentifiers = map[string]string{
+	"Gamma": "Γ", "Delta": "Δ", "Theta": "Θ", "Lambda": "Λ", "Xi": "Ξ",
+	"Pi": "Π", "Sigma": "Σ", "Upsilon": "Υ", "Phi": "Φ", "Psi": "Ψ",
+	"Omega": "Ω",
+	"infty": "∞", "emptyset": "∅", "varnothing": "∅", "aleph": "ℵ",
+	"Re": "ℜ", "Im": "ℑ", "partial": "∂", "nabla": "∇",
+}
+
+var texOperators = map[string]string{
+	"pm": "±", "mp": "∓", "times": "×", "div": "÷", "cdot": "⋅", "ast": "∗",
+	"star": "⋆", "circ": "∘", "bullet": "∙", "oplus": "⊕", "otimes": "⊗",
+	"cap": "∩", "cup": "∪", "setminus": "∖", "wedge": "∧", "land": "∧",
+	"vee": "∨", "lor": "∨", "neg": "¬", "lnot": "¬",
+	"leq": "≤", "le": "≤", "geq": "≥", "ge": "≥", "neq": "≠", "ne": "≠",
+	"ll": "≪", "gg": "≫", "approx": "≈", "sim": "∼", "simeq": "≃",
+	"cong": "≅", "equiv": "≡", "propto": "∝", "prec": "≺", "succ": "≻",
+	"in": "∈", "notin": "∉", "ni": "∋", "subset": "⊂", "supset": "⊃",
+	"subseteq": "⊆", "supseteq": "⊇", "mid": "∣", "parallel": "∥",
+	"perp": "⊥", "forall": "∀", "exists": "∃", "nexists": "∄",
+	"to": "→", "rightarrow": "→", "leftarrow": "←", "gets": "←",
+	"leftrightarrow": "↔", "Rightarrow": "⇒", "Leftarrow": "⇐",
+	"Leftrightarrow": "⇔", "implies": "⟹", "iff": "⟺", "mapsto": "↦",
+	"uparrow": "↑", "downarrow": "↓", "longrightarrow": "⟶",
+	"ldots": "…", "dots": "…", "cdots": "⋯", "vdots": "⋮", "ddots": "⋱",
+	"langle": "⟨", "rangle": "⟩", "lceil": "⌈", "rceil": "⌉",
+	"lfloor": "⌊", "rfloor": "⌋", "vert": "|", "Vert": "‖",
+	"therefore": "∴", "because": "∵", "angle": "∠", "triangle": "△",
+	"degree": "°", "prime": "′",
+}
+
+var texLargeOperators = map[string]string{
+	"sum": "∑", "prod": "∏", "coprod": "∐", "int": "∫", "iint": "∬",
+	"iiint": "∭", "oint": "∮", "bigcup": "⋃", "bigcap": "⋂",
+	"bigoplus": "⨁", "bigotimes": "⨂", "bigvee": "⋁", "bigwedge": "⋀",
+}
+
+var texFunctions = map[string]bool{
+	"sin": true, "cos": true, "tan": true, "cot": true, "sec": true,
+	"csc": true, "arcsin": true, "arccos": true, "arctan": true,
+	"sinh": true, "cosh": true, "tanh": true, "log": true, "ln": true,
+	"lg": true, "exp": true, "lim": true, "max": true, "min": true,
+	"sup": true, "inf": true, "det": true, "gcd": true, "deg": true,
+	"dim": true, "ker": true, "arg": true, "Pr": true, "mod": true,
+}
+
+// texEscapes are the characters TeX needs a backslash for
+var texEscapes = map[string]string{
+	"{": "{", "}": "}", "%": "%", "$": "$", "&": "&", "#": "#", "_": "_",
+	"|": "‖", "lbrace": "{", "rbrace": "}", "backslash": "\\",
+}
+
+var texSpaces = map[string]string{
+	",": "0.1667em", ":": "0.2222em", ">": "0.2222em", ";": "0.2778em",
+	" ": "0.25em", "quad": "1em", "qquad": "2em", "!": "-0.1667em",
+}
+
+var texVariants = map[string]string{
+	"mathrm": "normal", "mathbf": "bold", "mathit": "italic",
+	"mathbb": "double-struck", "mathcal": "script", "mathscr": "script",
+	"mathfrak": "fraktur", "mathsf": "sans-serif", "mathtt": "monospace",
+	"boldsymbol": "bold-italic", "bm": "bold-italic",
+}
+
+var texAccents = map[string]string{
+	"hat": "^", "widehat": "^", "bar": "¯", "overline": "¯", "vec": "→",
+	"dot": "˙", "ddot": "¨", "tilde": "~", "widetilde": "~", "check": "ˇ",
+	"breve": "˘", "acute": "´", "grave": "`",
+}
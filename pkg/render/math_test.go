@@ -0,0 +1,51 @@
+package render
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTeXToMathML(t *testing.T) {
+	cases := map[string]string{
+		`x^2`:            `<msup><mi>x</mi><mrow><mn>2</mn></mrow></msup>`,
+		`\frac{a}{b}`:    `<mfrac><mrow><mi>a</mi></mrow><mrow><mi>b</mi></mrow></mfrac>`,
+		`\sqrt{x}`:       `<msqrt><mrow><mi>x</mi></mrow></msqrt>`,
+		`\alpha+\beta`:   `<mi>α</mi><mo>+</mo><mi>β</mi>`,
+		`\text{if } x`:   `<mtext>if </mtext><mi>x</mi>`,
+		`\foo`:           `<merror><mtext>\foo</mtext></merror>`,
+		`a}b`:            `<mi>a</mi><mi>b</mi>`,
+		`\sin x`:         `<mi mathvariant="normal">sin</mi><mi>x</mi>`,
+		`\sum_{i=1}^n i`: `<msubsup><mo largeop="true" movablelimits="true">∑</mo><mrow><mi>i</mi><mo>=</mo><mn>1</mn></mrow><mrow><mi>n</mi></mrow></msubsup><mi>i</mi>`,
+		`\begin{pmatrix}1&2\\3&4\end{pmatrix}`: `<mrow><mo stretchy="true">(</mo><mtable>` +
+			`<mtr><mtd><mn>1</mn></mtd><mtd><mn>2</mn></mtd></mtr>` +
+			`<mtr><mtd><mn>3</mn></mtd><mtd><mn>4</mn></mtd></mtr>` +
+			`</mtable><mo stretchy="true">)</mo></mrow>`,
+	}
+	for tex, expected := range cases {
+		assert.Contains(t, TeXToMathML(tex, false),
+			"<semantics><mrow>"+expected+"</mrow><annotation", tex)
+	}
+
+	mathml := TeXToMathML(`\sum_{i=1}^n a<b`, true)
+	assert.Contains(t, mathml, `display="block"`)
+	assert.Contains(t, mathml, "<munderover>")
+	assert.Contains(t, mathml, `<annotation encoding="application/x-tex">\sum_{i=1}^n a&lt;b</annotation>`)
+	assert.Contains(t, TeXToMathML(`\int_0^1 x`, true), "<msubsup>")
+}
+
+func TestMath(t *testing.T) {
+	content := "Euler: $e^{i\\pi}+1=0$\n\n$$\n\\int_0^1 x\\,dx\n$$\n"
+
+	html := string(New(DefaultExtensions).HTML(content))
+	assert.Contains(t, html, `<p>Euler: <math xmlns="http://www.w3.org/1998/Math/MathML" display="inline">`)
+	assert.Contains(t, html, `<math xmlns="http://www.w3.org/1998/Math/MathML" display="block">`)
+	assert.NotContains(t, html, "GOLDFROGRAW")
+
+	html = string(New(DefaultExtensions &^ Math).HTML(content))
+	assert.NotContains(t, html, "<math")
+	assert.Contains(t, html, `<span class="math inline">`)
+
+	assert.Equal(t, "Euler: \\(e^{i\\pi}+1=0\\)\n",
+		New(DefaultExtensions).Text("Euler: $e^{i\\pi}+1=0$"))
+}
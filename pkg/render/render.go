@@ -0,0 +1,365 @@
+/*
+Package render turns post markdown into html (and plain text) the same
+way everywhere goldfrog shows or sends a post.
+
+A Renderer expands its Shortcodes, parses with the markdown Extensions
+it's given, runs its Transforms over the document (hashtag and wiki
+links, task list checkboxes and heading anchors are done this way, on
+the AST, rather than on the html afterwards), renders with its Hooks
+(code highlighting is one) and sanitizes the result with its Policy.
+RawHooks render html the policy would strip, MathML for math and SVG
+for diagrams, so it's put back after sanitizing.
+*/
+package render
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/gomarkdown/markdown"
+	"github.com/gomarkdown/markdown/ast"
+	"github.com/gomarkdown/markdown/html"
+	"github.com/gomarkdown/markdown/parser"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/sirupsen/logrus"
+)
+
+var logger = logrus.New()
+
+// Extensions are the optional markdown features a Renderer supports
+type Extensions int
+
+const (
+	Footnotes Extensions = 1 << iota
+	Tables
+	TaskLists
+	DefinitionLists
+	SmartQuotes
+	HeadingAnchors
+	Highlighting
+	Math
+	Diagrams
+
+	NoExtensions  Extensions = 0
+	AllExtensions Extensions = Footnotes | Tables | TaskLists |
+		DefinitionLists | SmartQuotes | HeadingAnchors | Highlighting |
+		Math | Diagrams
+	// DefaultExtensions are used when none are configured
+	DefaultExtensions Extensions = AllExtensions
+)
+
+var extensionNames = map[string]Extensions{
+	"footnotes":       Footnotes,
+	"tables":          Tables,
+	"tasklists":       TaskLists,
+	"definitionlists": DefinitionLists,
+	"smartquotes":     SmartQuotes,
+	"headinganchors":  HeadingAnchors,
+	"highlighting":    Highlighting,
+	"math":            Math,
+	"diagrams":        Diagrams,
+}
+
+/*
+ParseExtensions reads extensions by name, as in the config, with the
+DefaultExtensions for none.
+*/
+func ParseExtensions(names []string) (Extensions, error) {
+	if len(names) == 0 {
+		return DefaultExtensions, nil
+	}
+	extensions := NoExtensions
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "none" {
+			continue
+		}
+		ext, ok := extensionNames[name]
+		if !ok {
+			return DefaultExtensions, fmt.Errorf("Unknown markdown extension: %s", name)
+		}
+		extensions |= ext
+	}
+	return extensions, nil
+}
+
+// String lists the names of the extensions
+func (e Extensions) String() string {
+	var names []string
+	for name, ext := range extensionNames {
+		if e&ext != 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// Transform changes a parsed document before it's rendered
+type Transform func(doc ast.Node)
+
+/*
+Hook renders a node itself, returning false to leave it to the
+default html renderer. See html.RenderNodeFunc.
+*/
+type Hook = html.RenderNodeFunc
+
+/*
+RawHook renders a node to trusted html, which isn't sanitized, returning
+false to leave it to the other hooks. Raw hooks come before any others.
+*/
+type RawHook func(node ast.Node) (string, bool)
+
+type Renderer struct {
+	Extensions Extensions
+	// Policy sanitizes rendered html, nil leaves it as it is
+	Policy *bluemonday.Policy
+	// Shortcodes are expanded (after sanitizing), nil leaves them be
+	Shortcodes *Shortcodes
+	// DiagramCache has the rendered diagrams, nil leaves them as code
+	DiagramCache *DiagramCache
+
+	transforms []Transform
+	hooks      []Hook
+	rawHooks   []RawHook
+}
+
+// New makes a Renderer with extensions, sanitizing with Policy()
+func New(extensions Extensions) *Renderer {
+	r := &Renderer{
+		Extensions: extensions,
+		Policy:     Policy(),
+		Shortcodes: NewShortcodes(),
+	}
+	if extensions&TaskLists != 0 {
+		r.transforms = append(r.transforms, taskLists)
+	}
+	if extensions&HeadingAnchors != 0 {
+		r.transforms = append(r.transforms, headingAnchors)
+	}
+	if extensions&Highlighting != 0 {
+		r.hooks = append(r.hooks, highlightCode)
+	}
+	if extensions&Math != 0 {
+		r.rawHooks = append(r.rawHooks, renderMath)
+	}
+	if extensions&Diagrams != 0 {
+		r.rawHooks = append(r.rawHooks, r.renderDiagram)
+	}
+	return r
+}
+
+// Transform adds transforms, run in the order they're added
+func (r *Renderer) Transform(transforms ...Transform) *Renderer {
+	r.transforms = append(r.transforms, transforms...)
+	return r
+}
+
+// Hook adds render hooks, the first one to render a node wins
+func (r *Renderer) Hook(hooks ...Hook) *Renderer {
+	r.hooks = append(r.hooks, hooks...)
+	return r
+}
+
+// RawHook adds raw hooks, the first one to render a node wins
+func (r *Renderer) RawHook(hooks ...RawHook) *Renderer {
+	r.rawHooks = append(r.rawHooks, hooks...)
+	return r
+}
+
+func (r *Renderer) parserExtensions() parser.Extensions {
+	extensions := parser.NoIntraEmphasis | parser.FencedCode |
+		parser.Autolink | parser.Strikethrough | parser.SpaceHeadings |
+		parser.HeadingIDs | parser.BackslashLineBreak | parser.MathJax
+	if r.Extensions&Footnotes != 0 {
+		extensions |= parser.Footnotes
+	}
+	if r.Extensions&Tables != 0 {
+		extensions |= parser.Tables
+	}
+	if r.Extensions&DefinitionLists != 0 {
+		extensions |= parser.DefinitionLists
+	}
+	if r.Extensions&HeadingAnchors != 0 {
+		extensions |= parser.AutoHeadingIDs
+	}
+	return extensions
+}
+
+// Parse parses content into a document, without running the transforms
+func (r *Renderer) Parse(content string) ast.Node {
+	p := parser.NewWithExtensions(r.parserExtensions())
+	return markdown.Parse([]byte(content), p)
+}
+
+/*
+render renders doc to html, with the raw hooks' html (when raw is set)
+collected and a placeholder left in its place.
+*/
+func (r *Renderer) render(doc ast.Node, raw bool) ([]byte, []template.HTML) {
+	flags := html.FlagsNone
+	if r.Extensions&SmartQuotes != 0 {
+		flags |= html.Smartypants | html.SmartypantsFractions |
+			html.SmartypantsDashes | html.SmartypantsLatexDashes
+	}
+	if r.Extensions&Footnotes != 0 {
+		flags |= html.FootnoteReturnLinks
+	}
+	opts := html.RendererOptions{
+		Flags:                      flags,
+		FootnoteReturnLinkContents: "↩",
+	}
+	var rendered []template.HTML
+	// the walk comes back to containers a raw hook rendered
+	handled := make(map[ast.Node]bool)
+	if len(r.hooks) > 0 || (raw && len(r.rawHooks) > 0) {
+		opts.RenderNodeHook = func(w io.Writer, node ast.Node, entering bool) (ast.WalkStatus, bool) {
+			if handled[node] {
+				return ast.GoToNext, true
+			}
+			for _, hook := range r.rawHooks {
+				if !raw || !entering {
+					break
+				}
+				if html, ok := hook(node); ok {
+					io.WriteString(w, rawPlaceholder(len(rendered)))
+					rendered = append(rendered, template.HTML(html))
+					handled[node] = true
+					return ast.SkipChildren, true
+				}
+			}
+			for _, hook := range r.hooks {
+				if status, ok := hook(w, node, entering); ok {
+					return status, true
+				}
+			}
+			return ast.GoToNext, false
+		}
+	}
+	return markdown.Render(doc, html.NewRenderer(opts)), rendered
+}
+
+func (r *Renderer) expand(content string) (string, []template.HTML) {
+	if r.Shortcodes == nil {
+		return content, nil
+	}
+	return r.Shortcodes.expand(content)
+}
+
+/*
+HTML renders content to sanitized html. Shortcodes are trusted, they
+come from the blog's templates, so they're left out of sanitizing.
+*/
+func (r *Renderer) HTML(content string) template.HTML {
+	content, shortcodes := r.expand(content)
+	doc := r.Parse(content)
+	for _, transform := range r.transforms {
+		transform(doc)
+	}
+	s, raw := r.render(doc, true)
+	if r.Policy != nil {
+		s = r.Policy.SanitizeBytes(s)
+	}
+	html := fillPlaceholders(string(s), raw, rawPlaceholder)
+	return template.HTML(fillPlaceholders(html, shortcodes, placeholder))
+}
+
+// Text renders content to plain text, dropping all the markup
+func (r *Renderer) Text(content string) string {
+	content, shortcodes := r.expand(content)
+	strict := bluemonday.StrictPolicy()
+	for i, html := range shortcodes {
+		shortcodes[i] = template.HTML(strict.Sanitize(string(html)))
+	}
+	s, _ := r.render(r.Parse(content), false)
+	return fillPlaceholders(strict.Sanitize(string(s)), shortcodes, placeholder)
+}
+
+// Hashtags finds the hashtags in content's text, outside code and links
+func (r *Renderer) Hashtags(content string) []string {
+	content, _ = r.expand(content)
+	var tags []string
+	walkText(r.Parse(content), func(text *ast.Text) {
+		for _, m := range hashtagRegex.FindAllStringSubmatch(string(text.Literal), -1) {
+			tags = append(tags, m[2])
+		}
+	})
+	return tags
+}
+
+// Policy is the bluemonday policy rendered posts are sanitized with
+func Policy() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	// these are the blog's own links
+	p.RequireNoFollowOnLinks(false)
+
+	// classes for styling and microformats
+	p.AllowAttrs("class").Matching(classRegex).Globally()
+
+	p.AllowAttrs("loading").Matching(bluemonday.SpaceSeparatedTokens).OnElements("img")
+	p.AllowAttrs("srcset", "sizes").OnElements("img")
+	p.AllowElements("figure", "figcaption")
+
+	// task list checkboxes
+	p.AllowAttrs("type").Matching(checkboxRegex).OnElements("input")
+	p.AllowAttrs("checked", "disabled").OnElements("input")
+
+	// embedded players
+	p.AllowAttrs("src").Matching(httpsRegex).OnElements("iframe")
+	p.AllowAttrs("width", "height").Matching(bluemonday.NumberOrPercent).OnElements("iframe")
+	p.AllowAttrs("title", "allow", "allowfullscreen", "frameborder").OnElements("iframe")
+	return p
+}
+
+// walkText calls f with the text nodes in doc, outside links
+func walkText(doc ast.Node, f func(*ast.Text)) {
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		switch n := node.(type) {
+		case *ast.Link:
+			return ast.SkipChildren
+		case *ast.Text:
+			if entering {
+				f(n)
+			}
+		}
+		return ast.GoToNext
+	})
+}
+
+// replaceNode puts nodes in the place of node in its parent
+func replaceNode(node ast.Node, nodes []ast.Node) {
+	parent := node.GetParent()
+	var children []ast.Node
+	for _, child := range parent.GetChildren() {
+		if child != node {
+			children = append(children, child)
+			continue
+		}
+		for _, n := range nodes {
+			n.SetParent(parent)
+			children = append(children, n)
+		}
+	}
+	parent.SetChildren(children)
+}
+
+func text(s string) ast.Node {
+	return &ast.Text{Leaf: ast.Leaf{Literal: []byte(s)}}
+}
+
+func htmlSpan(s string) ast.Node {
+	return &ast.HTMLSpan{Leaf: ast.Leaf{Literal: []byte(s)}}
+}
+
+// ToHTML renders content with the DefaultExtensions
+func ToHTML(content string) template.HTML {
+	return New(DefaultExtensions).HTML(content)
+}
+
+// ToText renders content to plain text with the DefaultExtensions
+func ToText(content string) string {
+	return New(DefaultExtensions).Text(content)
+}
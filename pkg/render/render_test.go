@@ -0,0 +1,176 @@
+package render
+
+import (
+	"io"
+
+	"strings"
+	"testing"
+
+	"github.com/gomarkdown/markdown/ast"
+	"github.com/stretchr/testify/assert"
+)
+
+func tagURL(tag string) string {
+	return "/tag/" + tag
+}
+
+func TestParseExtensions(t *testing.T) {
+	ext, err := ParseExtensions(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, DefaultExtensions, ext)
+
+	ext, err = ParseExtensions([]string{"Tables", " footnotes"})
+	assert.Nil(t, err)
+	assert.Equal(t, Tables|Footnotes, ext)
+	assert.Equal(t, "footnotes,tables", ext.String())
+
+	ext, err = ParseExtensions([]string{"none"})
+	assert.Nil(t, err)
+	assert.Equal(t, NoExtensions, ext)
+
+	_, err = ParseExtensions([]string{"emoji"})
+	assert.EqualError(t, err, "Unknown markdown extension: emoji")
+}
+
+func TestExtensions(t *testing.T) {
+	content := `# Intro
+
+## Intro
+
+"Quoted" -- text[^1]
+
+[^1]: A note
+
+- [ ] todo
+- [x] done
+
+Term
+: Definition
+
+| a | b |
+|---|---|
+| 1 | 2 |
+`
+	html := string(New(AllExtensions).HTML(content))
+	assert.Contains(t, html, `<h1 id="intro">Intro <a class="heading-anchor" href="#intro">#</a></h1>`)
+	assert.Contains(t, html, `<h2 id="intro-1">Intro <a class="heading-anchor" href="#intro-1">#</a></h2>`)
+	assert.Contains(t, html, `“Quoted” –`)
+	assert.Contains(t, html, `<a href="#fn:1">1</a>`)
+	assert.Contains(t, html, `<li id="fn:1">A note <a class="footnote-return" href="#fnref:1">↩</a></li>`)
+	assert.Contains(t, html, `<li><input type="checkbox" disabled=""> todo</li>`)
+	assert.Contains(t, html, `<li><input type="checkbox" checked="" disabled=""> done</li>`)
+	assert.Contains(t, html, `<dt>Term</dt>`)
+	assert.Contains(t, html, `<td>1</td>`)
+
+	html = string(New(NoExtensions).HTML(content))
+	assert.Contains(t, html, `<h1>Intro</h1>`)
+	assert.Contains(t, html, `&#34;Quoted&#34; --`)
+	assert.Contains(t, html, `<li>[ ] todo</li>`)
+	assert.NotContains(t, html, `<dt>`)
+	assert.NotContains(t, html, `<table>`)
+	assert.NotContains(t, html, `footnote`)
+}
+
+func TestSanitize(t *testing.T) {
+	html := string(ToHTML(`Hi <script>alert(1)</script>
+
+<div class="note" style="color: red" onclick="x()">Note</div>
+
+<iframe src="https://player.example/1" width="560"></iframe>
+<iframe src="http://player.example/2"></iframe>
+
+[link](javascript:alert(1)) [ok](https://example.com/)
+`))
+	assert.NotContains(t, html, "script")
+	assert.Contains(t, html, `<div class="note">Note</div>`)
+	assert.Contains(t, html, `<iframe src="https://player.example/1" width="560"></iframe>`)
+	assert.NotContains(t, html, "http://player.example")
+	assert.NotContains(t, html, "javascript")
+	assert.Contains(t, html, `<a href="https://example.com/">ok</a>`)
+
+	r := New(DefaultExtensions)
+	r.Policy = nil
+	assert.Contains(t, string(r.HTML("<script>x</script>")), "<script>")
+}
+
+func TestHashtags(t *testing.T) {
+	r := New(DefaultExtensions).Transform(LinkHashtags(tagURL))
+	content := "#first post about #golang, not `#code`, [#links](#foo) or a#b\n\n    #block\n"
+
+	assert.Equal(t, []string{"first", "golang"}, r.Hashtags(content))
+
+	html := string(r.HTML(content))
+	assert.Contains(t, html, `<p><a class="hashtag" href="/tag/first">#first</a> post about `+
+		`<a class="hashtag" href="/tag/golang">#golang</a>, not <code>#code</code>, `+
+		`<a href="#foo">#links</a> or a#b</p>`)
+	assert.Contains(t, html, "<pre><code>#block\n</code></pre>")
+}
+
+func TestText(t *testing.T) {
+	text := ToText("<p>foo</p>\n\nSome **bold** [link](https://example.com)")
+	assert.NotContains(t, text, "<p>")
+	assert.True(t, strings.Contains(text, "Some bold link"))
+}
+
+func TestHooks(t *testing.T) {
+	hooked := 0
+	r := New(NoExtensions).Hook(func(w io.Writer, node ast.Node, entering bool) (ast.WalkStatus, bool) {
+		if _, ok := node.(*ast.Code); ok {
+			hooked++
+			io.WriteString(w, "<strong>"+string(node.AsLeaf().Literal)+"</strong>")
+			return ast.GoToNext, true
+		}
+		return ast.GoToNext, false
+	})
+	assert.Equal(t, "<p>press <strong>q</strong></p>\n", string(r.HTML("press `q`")))
+	assert.Equal(t, 1, hooked)
+}
+
+func TestParseCodeInfo(t *testing.T) {
+	opts, err := ParseCodeInfo(`go linenos=table hl_lines="2,4-6" linenostart=10`)
+	assert.Nil(t, err)
+	assert.Equal(t, CodeOptions{
+		Language:    "go",
+		LineNumbers: true,
+		Table:       true,
+		LineStart:   10,
+		Highlight:   [][2]int{{2, 2}, {4, 6}},
+	}, opts)
+
+	opts, err = ParseCodeInfo("")
+	assert.Nil(t, err)
+	assert.Equal(t, CodeOptions{LineStart: 1}, opts)
+
+	_, err = ParseCodeInfo("go hl_lines=6-4")
+	assert.EqualError(t, err, "Bad line range: 6-4")
+	_, err = ParseCodeInfo("go wrap")
+	assert.EqualError(t, err, "Unknown code block option: wrap")
+}
+
+func TestHighlighting(t *testing.T) {
+	html := string(ToHTML("```go\nfunc main() {}\n```\n"))
+	assert.Contains(t, html, `<pre class="chroma"><code>`)
+	assert.Contains(t, html, `<span class="kd">func</span> <span class="nf">main</span>`)
+
+	html = string(ToHTML("```{go linenos hl_lines=2 linenostart=5}\npackage main\nfunc main() {}\n```\n"))
+	assert.Contains(t, html, `<span class="ln">5</span>`)
+	assert.Contains(t, html, `<span class="line hl"><span class="ln">6</span>`)
+
+	html = string(ToHTML("```{text linenos=table}\nfoo\n```\n"))
+	assert.Contains(t, html, `<table class="lntable">`)
+
+	// unknown languages are left as they are
+	html = string(ToHTML("```nosuchlanguage\nfoo\n```\n"))
+	assert.Contains(t, html, `<pre><code class="language-nosuchlanguage">foo`)
+
+	html = string(New(AllExtensions &^ Highlighting).HTML("```go\nfunc main() {}\n```\n"))
+	assert.Contains(t, html, `<pre><code class="language-go">func main() {}`)
+}
+
+func TestWriteStylesheet(t *testing.T) {
+	var css strings.Builder
+	assert.Nil(t, WriteStylesheet(&css, "monokai"))
+	assert.Contains(t, css.String(), ".chroma .kd {")
+	assert.Contains(t, Themes(), DefaultTheme)
+	assert.EqualError(t, WriteStylesheet(&css, "nope"), "Unknown theme: nope")
+}
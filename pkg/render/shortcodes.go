@@ -0,0 +1,281 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+/*
+Shortcodes embed things in posts that markdown can't, like
+
+	{{< youtube dQw4w9WgXcQ >}}
+	{{< figure src="/uploads/cat.jpg" caption="A cat" >}}
+	{{< toot https://mastodon.social/@someone/1234 >}}
+	{{< gist someone 0123abcd >}}
+
+They're expanded before the markdown is rendered, from templates named
+after them: the builtin ones below, or ones in the shortcodes directory
+of the templates, like shortcodes/youtube.html. Videos, toots and gists
+are click-to-load: nothing is fetched from their sites until the reader
+asks for it. Shortcodes in code aren't expanded, and neither are ones
+with their insides commented out (as in Hugo), which are written as
+they are.
+*/
+
+// Shortcode is what shortcode templates are run with
+type Shortcode struct {
+	Name   string
+	Args   []string
+	Params map[string]string
+}
+
+// Get is an argument by position, or a param by name
+func (s Shortcode) Get(key interface{}) string {
+	switch k := key.(type) {
+	case int:
+		if k >= 0 && k < len(s.Args) {
+			return s.Args[k]
+		}
+	case string:
+		return s.Params[k]
+	}
+	return ""
+}
+
+// Embed is a click-to-load iframe, see the "embed" template
+type Embed struct {
+	Kind   string
+	Src    string
+	Link   string
+	Title  string
+	Width  int
+	Height int
+}
+
+// Site is the host the embed loads from
+func (e Embed) Site() string {
+	if u, err := url.Parse(e.Src); err == nil {
+		return strings.TrimPrefix(u.Host, "www.")
+	}
+	return ""
+}
+
+// Placeholder is what the iframe shows until it's clicked
+func (e Embed) Placeholder() string {
+	// it's not escaped by the template like Src is
+	if !strings.HasPrefix(e.Src, "https://") && !strings.HasPrefix(e.Src, "http://") {
+		return ""
+	}
+	return fmt.Sprintf(`<style>
+html,body{height:100%%;margin:0}
+a{display:flex;height:100%%;align-items:center;justify-content:center;
+font:1.1em sans-serif;color:#fff;background:#333;text-decoration:none;text-align:center}
+</style><a href="%s">▶ Load %s from %s</a>`,
+		template.HTMLEscapeString(e.Src),
+		template.HTMLEscapeString(e.Title),
+		template.HTMLEscapeString(e.Site()))
+}
+
+func embed(kind, src, link, title string, size ...int) Embed {
+	e := Embed{Kind: kind, Src: src, Link: link, Title: title, Width: 560, Height: 315}
+	if len(size) == 2 {
+		e.Width, e.Height = size[0], size[1]
+	}
+	return e
+}
+
+const builtinShortcodes = `
+{{define "embed"}}<div class="embed embed-{{.Kind}}">` +
+	`<iframe src="{{.Src}}" srcdoc="{{.Placeholder}}" title="{{.Title}}" width="{{.Width}}" height="{{.Height}}" ` +
+	`loading="lazy" allow="autoplay; encrypted-media; picture-in-picture" allowfullscreen></iframe>` +
+	`<p class="embed-link"><a href="{{.Link}}">{{.Link}}</a></p></div>{{end}}
+
+{{define "youtube"}}{{$id := or (.Get "id") (.Get 0)}}{{template "embed" (embed "youtube"
+	(printf "https://www.youtube-nocookie.com/embed/%s?autoplay=1" $id)
+	(printf "https://www.youtube.com/watch?v=%s" $id)
+	(or (.Get "title") "YouTube video"))}}{{end}}
+
+{{define "toot"}}{{$url := or (.Get "url") (.Get 0)}}{{template "embed" (embed "toot"
+	(printf "%s/embed" $url) $url (or (.Get "title") "toot") 400 300)}}{{end}}
+
+{{define "gist"}}{{$url := or (.Get "url") (printf "https://gist.github.com/%s/%s" (.Get 0) (.Get 1))}}` +
+	`{{template "embed" (embed "gist" (printf "%s.pibb" $url) $url (or (.Get "title") "gist") 640 400)}}{{end}}
+
+{{define "figure"}}<figure>` +
+	`<img src="{{or (.Get "src") (.Get 0)}}" alt="{{or (.Get "alt") (.Get "caption") (.Get 1)}}" loading="lazy">` +
+	`{{with or (.Get "caption") (.Get 1)}}<figcaption>{{.}}</figcaption>{{end}}</figure>{{end}}
+`
+
+// Shortcodes are the templates shortcodes are expanded with
+type Shortcodes struct {
+	templates *template.Template
+}
+
+// NewShortcodes has the builtin shortcodes
+func NewShortcodes() *Shortcodes {
+	return &Shortcodes{
+		templates: template.Must(template.New("shortcodes").Funcs(template.FuncMap{
+			"embed": embed,
+		}).Parse(builtinShortcodes)),
+	}
+}
+
+/*
+Load adds the shortcodes in dir, each file a template named after it
+(youtube.html is the youtube shortcode), replacing builtin ones. It's
+not an error for dir not to exist.
+*/
+func (s *Shortcodes) Load(dir string) error {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.html"))
+	if err != nil {
+		return err
+	}
+	templates, err := s.templates.Clone()
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(filepath.Base(file), ".html")
+		_, err = templates.New(name).Parse(string(b))
+		if err != nil {
+			return fmt.Errorf("Could not load shortcode %s: %v", name, err)
+		}
+	}
+	s.templates = templates
+	return nil
+}
+
+// Names are the shortcodes there are templates for
+func (s *Shortcodes) Names() []string {
+	var names []string
+	for _, t := range s.templates.Templates() {
+		if name := t.Name(); name != "shortcodes" && name != "embed" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Render expands a shortcode
+func (s *Shortcodes) Render(sc Shortcode) (template.HTML, error) {
+	t := s.templates.Lookup(sc.Name)
+	if t == nil || sc.Name == "shortcodes" {
+		return "", fmt.Errorf("Unknown shortcode: %s", sc.Name)
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, sc); err != nil {
+		return "", err
+	}
+	return template.HTML(buf.String()), nil
+}
+
+var (
+	shortcodeRegex = regexp.MustCompile(`\{\{<\s*(/\*)?\s*([\w-]+)((?:[^>]|>[^}])*?)\s*(\*/)?\s*>\}\}`)
+	argRegex       = regexp.MustCompile(`([\w-]+)=(?:"([^"]*)"|(\S+))|"([^"]*)"|(\S+)`)
+	codeSpanRegex  = regexp.MustCompile("`+[^`]*`+")
+	fenceRegex     = regexp.MustCompile("^ {0,3}(```|~~~)")
+)
+
+// parseShortcode reads the name and arguments of a shortcode
+func parseShortcode(name string, args string) Shortcode {
+	sc := Shortcode{Name: name, Params: make(map[string]string)}
+	for _, m := range argRegex.FindAllStringSubmatch(args, -1) {
+		switch {
+		case m[1] != "":
+			sc.Params[m[1]] = m[2] + m[3]
+		case m[4] != "" || strings.HasPrefix(m[0], `"`):
+			sc.Args = append(sc.Args, m[4])
+		default:
+			sc.Args = append(sc.Args, m[5])
+		}
+	}
+	return sc
+}
+
+func placeholder(i int) string {
+	return fmt.Sprintf("GOLDFROGSHORTCODE%dX", i)
+}
+
+func rawPlaceholder(i int) string {
+	return fmt.Sprintf("GOLDFROGRAW%dX", i)
+}
+
+/*
+expand swaps the shortcodes in content for placeholders,
+returning their renderings to put in their place once the markdown is
+rendered (see fillPlaceholders).
+*/
+func (s *Shortcodes) expand(content string) (string, []template.HTML) {
+	var rendered []template.HTML
+	replace := func(text string) string {
+		return shortcodeRegex.ReplaceAllStringFunc(text, func(match string) string {
+			m := shortcodeRegex.FindStringSubmatch(match)
+			if m[1] != "" && m[4] != "" {
+				// escaped, written as it is
+				return "{{< " + strings.TrimSpace(m[2]+m[3]) + " >}}"
+			}
+			html, err := s.Render(parseShortcode(m[2], m[3]))
+			if err != nil {
+				logger.Warnf("Shortcode %s: %v", match, err)
+				return match
+			}
+			rendered = append(rendered, html)
+			return placeholder(len(rendered) - 1)
+		})
+	}
+
+	lines := strings.SplitAfter(content, "\n")
+	fence := ""
+	for i, line := range lines {
+		if m := fenceRegex.FindStringSubmatch(line); m != nil {
+			if fence == "" {
+				fence = m[1]
+			} else if m[1] == fence {
+				fence = ""
+			}
+			continue
+		}
+		if fence != "" {
+			continue
+		}
+		// not in code spans either
+		var out strings.Builder
+		last := 0
+		for _, loc := range codeSpanRegex.FindAllStringIndex(line, -1) {
+			out.WriteString(replace(line[last:loc[0]]))
+			out.WriteString(line[loc[0]:loc[1]])
+			last = loc[1]
+		}
+		out.WriteString(replace(line[last:]))
+		lines[i] = out.String()
+	}
+	return strings.Join(lines, ""), rendered
+}
+
+// fillPlaceholders puts rendered html (shortcodes, raw hooks) in place of its placeholders
+func fillPlaceholders(
+	html string, rendered []template.HTML, placeholder func(int) string) string {
+	for i, r := range rendered {
+		p := placeholder(i)
+		// one on its own makes a paragraph, which it replaces
+		html = strings.Replace(html, "<p>"+p+"</p>", string(r), -1)
+		html = strings.Replace(html, p, string(r), -1)
+	}
+	return html
+}
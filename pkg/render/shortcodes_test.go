@@ -0,0 +1,90 @@
+package render
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseShortcode(t *testing.T) {
+	sc := parseShortcode("figure", ` src="/a b.jpg" "A cat" width=300 plain`)
+	assert.Equal(t, "figure", sc.Name)
+	assert.Equal(t, []string{"A cat", "plain"}, sc.Args)
+	assert.Equal(t, map[string]string{"src": "/a b.jpg", "width": "300"}, sc.Params)
+	assert.Equal(t, "A cat", sc.Get(0))
+	assert.Equal(t, "/a b.jpg", sc.Get("src"))
+	assert.Equal(t, "", sc.Get(5))
+}
+
+func TestShortcodes(t *testing.T) {
+	html := string(ToHTML(`Watch:
+
+{{< youtube dQw4w9WgXcQ >}}
+
+A {{< figure src="/uploads/cat.jpg" caption="Cat & dog" >}} in a line, ` + "`{{< youtube code >}}`" + `
+and {{</* youtube escaped */>}} and {{< nosuch thing >}}
+
+` + "```" + `
+{{< youtube fenced >}}
+` + "```" + `
+`))
+	// the video isn't loaded until it's clicked
+	assert.Contains(t, html, `<p>Watch:</p>
+
+<div class="embed embed-youtube"><iframe src="https://www.youtube-nocookie.com/embed/dQw4w9WgXcQ?autoplay=1" srcdoc="`)
+	assert.Contains(t, html, `Load YouTube video from youtube-nocookie.com`)
+	assert.Contains(t, html, `<a href="https://www.youtube.com/watch?v=dQw4w9WgXcQ">`)
+	assert.NotContains(t, html, "<p><div")
+
+	assert.Contains(t, html, `<figure><img src="/uploads/cat.jpg" alt="Cat &amp; dog" loading="lazy">`+
+		`<figcaption>Cat &amp; dog</figcaption></figure>`)
+	assert.Contains(t, html, "<code>{{&lt; youtube code &gt;}}</code>")
+	assert.Contains(t, html, "and {{&lt; youtube escaped &gt;}} and {{&lt; nosuch thing &gt;}}")
+	assert.Contains(t, html, "<pre><code>{{&lt; youtube fenced &gt;}}")
+
+	html = string(ToHTML(`{{< toot https://mastodon.example/@me/1 >}}`))
+	assert.Contains(t, html, `<iframe src="https://mastodon.example/@me/1/embed"`)
+	html = string(ToHTML(`{{< gist someone abc123 >}}`))
+	assert.Contains(t, html, `<iframe src="https://gist.github.com/someone/abc123.pibb"`)
+	html = string(ToHTML(`{{< toot "javascript:alert(1)" >}}`))
+	assert.NotContains(t, html, `href="javascript:`)
+	assert.NotContains(t, html, `javascript:alert(1)/embed`)
+
+	text := ToText("See {{< youtube dQw4w9WgXcQ >}}")
+	assert.Contains(t, text, "See https://www.youtube.com/watch?v=dQw4w9WgXcQ")
+
+	r := New(DefaultExtensions)
+	r.Shortcodes = nil
+	assert.Contains(t, string(r.HTML("{{< youtube x >}}")), "{{&lt; youtube x &gt;}}")
+}
+
+func TestLoadShortcodes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goldfrog-shortcodes")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	s := NewShortcodes()
+	assert.Nil(t, s.Load(filepath.Join(dir, "missing")))
+
+	ioutil.WriteFile(filepath.Join(dir, "youtube.html"),
+		[]byte(`<a href="https://youtu.be/{{.Get 0}}">video</a>`), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "note.html"),
+		[]byte(`<aside class="note">{{.Get "text"}}</aside>`), 0644)
+	assert.Nil(t, s.Load(dir))
+	assert.Equal(t, []string{"figure", "gist", "note", "toot", "youtube"}, s.Names())
+
+	html, err := s.Render(Shortcode{Name: "youtube", Args: []string{"abc"}})
+	assert.Nil(t, err)
+	assert.Equal(t, `<a href="https://youtu.be/abc">video</a>`, string(html))
+	html, err = s.Render(parseShortcode("note", ` text="<b>hi</b>"`))
+	assert.Nil(t, err)
+	assert.Equal(t, `<aside class="note">&lt;b&gt;hi&lt;/b&gt;</aside>`, string(html))
+
+	ioutil.WriteFile(filepath.Join(dir, "broken.html"), []byte(`{{.Get`), 0644)
+	assert.NotNil(t, s.Load(dir))
+	// still has the ones loaded before
+	assert.Contains(t, s.Names(), "note")
+}
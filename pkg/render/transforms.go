@@ -0,0 +1,111 @@
+package render
+
+import (
+	"fmt"
+	"html/template"
+	"regexp"
+	"strings"
+
+	"github.com/gomarkdown/markdown/ast"
+)
+
+var (
+	hashtagRegex  = regexp.MustCompile(`(^|[^[:alnum:]_&#/])#([[:alnum:]]+)`)
+	taskRegex     = regexp.MustCompile(`^\[([ xX])\]\s`)
+	classRegex    = regexp.MustCompile(`^[\w\- ]+$`)
+	checkboxRegex = regexp.MustCompile(`^checkbox$`)
+	httpsRegex    = regexp.MustCompile(`^https://`)
+)
+
+/*
+LinkHashtags links the #hashtags in the text of a document to the page
+url gives for them.
+*/
+func LinkHashtags(url func(tag string) string) Transform {
+	return func(doc ast.Node) {
+		var texts []*ast.Text
+		walkText(doc, func(t *ast.Text) { texts = append(texts, t) })
+
+		for _, t := range texts {
+			literal := string(t.Literal)
+			matches := hashtagRegex.FindAllStringSubmatchIndex(literal, -1)
+			if len(matches) == 0 {
+				continue
+			}
+			var nodes []ast.Node
+			last := 0
+			for _, m := range matches {
+				// m[4]:m[5] is the tag, after the #
+				tag := literal[m[4]:m[5]]
+				start := m[4] - 1
+				if start > last {
+					nodes = append(nodes, text(literal[last:start]))
+				}
+				nodes = append(nodes, htmlSpan(fmt.Sprintf(
+					`<a class="hashtag" href="%s">#%s</a>`,
+					template.HTMLEscapeString(url(tag)), tag)))
+				last = m[5]
+			}
+			if last < len(literal) {
+				nodes = append(nodes, text(literal[last:]))
+			}
+			replaceNode(t, nodes)
+		}
+	}
+}
+
+// taskLists turns list items starting with [ ] or [x] into checkboxes
+func taskLists(doc ast.Node) {
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		item, ok := node.(*ast.ListItem)
+		if !ok || !entering || len(item.Children) == 0 {
+			return ast.GoToNext
+		}
+		para, ok := item.Children[0].(*ast.Paragraph)
+		if !ok || len(para.Children) == 0 {
+			return ast.GoToNext
+		}
+		t, ok := para.Children[0].(*ast.Text)
+		if !ok {
+			return ast.GoToNext
+		}
+		m := taskRegex.FindSubmatch(t.Literal)
+		if m == nil {
+			return ast.GoToNext
+		}
+		checkbox := `<input type="checkbox" disabled>`
+		if string(m[1]) != " " {
+			checkbox = `<input type="checkbox" checked disabled>`
+		}
+		replaceNode(t, []ast.Node{
+			htmlSpan(checkbox + " "),
+			text(string(t.Literal[len(m[0]):])),
+		})
+		return ast.GoToNext
+	})
+}
+
+/*
+headingAnchors links each heading to itself, making sure no two headings
+get the same id.
+*/
+func headingAnchors(doc ast.Node) {
+	seen := make(map[string]bool)
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		heading, ok := node.(*ast.Heading)
+		if !ok || !entering || heading.HeadingID == "" || heading.IsTitleblock {
+			return ast.GoToNext
+		}
+		id := heading.HeadingID
+		for i := 1; seen[id]; i++ {
+			id = fmt.Sprintf("%s-%d", heading.HeadingID, i)
+		}
+		seen[id] = true
+		heading.HeadingID = id
+
+		ast.AppendChild(heading, htmlSpan(fmt.Sprintf(
+			` <a class="heading-anchor" href="#%s">#</a>`,
+			template.HTMLEscapeString(strings.TrimSpace(id)))))
+		return ast.SkipChildren
+	})
+}
@@ -0,0 +1,99 @@
+package render
+
+import (
+	"fmt"
+	"html/template"
+	"regexp"
+	"strings"
+
+	"github.com/gomarkdown/markdown/ast"
+)
+
+/*
+Wiki links are links to other posts by slug, [[slug]] or [[slug|text]],
+so writing one needn't mean looking up the post's permalink. The text
+is plain, markdown in it isn't rendered.
+*/
+
+var wikiLinkRegex = regexp.MustCompile(`\[\[([^\[\]|\n]+)(?:\|([^\[\]\n]*))?\]\]`)
+
+// WikiLink is a [[slug]] or [[slug|text]] link in a post
+type WikiLink struct {
+	Slug string
+	Text string
+}
+
+func parseWikiLink(m []string) WikiLink {
+	return WikiLink{
+		Slug: strings.TrimSpace(m[1]),
+		Text: strings.TrimSpace(m[2]),
+	}
+}
+
+/*
+LinkWikiLinks links wiki links to the posts resolve finds by slug, with
+its title as the text of links without any. Links to posts it doesn't
+find are marked broken.
+*/
+func LinkWikiLinks(resolve func(slug string) (url string, title string, ok bool)) Transform {
+	return func(doc ast.Node) {
+		var texts []*ast.Text
+		walkText(doc, func(t *ast.Text) { texts = append(texts, t) })
+
+		for _, t := range texts {
+			literal := string(t.Literal)
+			matches := wikiLinkRegex.FindAllStringSubmatchIndex(literal, -1)
+			if len(matches) == 0 {
+				continue
+			}
+			var nodes []ast.Node
+			last := 0
+			for _, m := range matches {
+				if m[0] > last {
+					nodes = append(nodes, text(literal[last:m[0]]))
+				}
+				groups := []string{literal[m[0]:m[1]], literal[m[2]:m[3]], ""}
+				if m[4] >= 0 {
+					groups[2] = literal[m[4]:m[5]]
+				}
+				nodes = append(nodes, htmlSpan(renderWikiLink(parseWikiLink(groups), resolve)))
+				last = m[1]
+			}
+			if last < len(literal) {
+				nodes = append(nodes, text(literal[last:]))
+			}
+			replaceNode(t, nodes)
+		}
+	}
+}
+
+func renderWikiLink(
+	link WikiLink, resolve func(string) (string, string, bool)) string {
+	url, title, ok := resolve(link.Slug)
+	label := link.Text
+	if label == "" {
+		label = title
+	}
+	if label == "" {
+		label = link.Slug
+	}
+	if !ok {
+		return fmt.Sprintf(
+			`<span class="wikilink wikilink-broken" title="No post %s">%s</span>`,
+			template.HTMLEscapeString(link.Slug), template.HTMLEscapeString(label))
+	}
+	return fmt.Sprintf(`<a class="wikilink" href="%s">%s</a>`,
+		template.HTMLEscapeString(url), template.HTMLEscapeString(label))
+}
+
+// WikiLinks finds the wiki links in content's text, outside code and links
+func (r *Renderer) WikiLinks(content string) []WikiLink {
+	content, _ = r.expand(content)
+	var links []WikiLink
+	walkText(r.Parse(content), func(text *ast.Text) {
+		for _, m := range wikiLinkRegex.FindAllStringSubmatch(string(text.Literal), -1) {
+			links = append(links, parseWikiLink(m))
+		}
+	})
+	return links
+}
@@ -0,0 +1,30 @@
+package render
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func resolveSlug(slug string) (string, string, bool) {
+	if slug == "first" {
+		return "/2020/01/02/first", "The <first> post", true
+	}
+	return "", "", false
+}
+
+func TestWikiLinks(t *testing.T) {
+	r := New(DefaultExtensions).Transform(LinkWikiLinks(resolveSlug))
+
+	html := string(r.HTML("See [[first]], [[ first | this ]] and [[gone]].\n\n`[[first]]`"))
+	assert.Contains(t, html,
+		`See <a class="wikilink" href="/2020/01/02/first">The &lt;first&gt; post</a>, `+
+			`<a class="wikilink" href="/2020/01/02/first">this</a> and `+
+			`<span class="wikilink wikilink-broken" title="No post gone">gone</span>.`)
+	assert.Contains(t, html, "<code>[[first]]</code>")
+
+	links := r.WikiLinks("[[first]] and [[gone|text]]\n\n    [[code]]\n\n[[first]]")
+	assert.Equal(t, []WikiLink{
+		{Slug: "first"}, {Slug: "gone", Text: "text"}, {Slug: "first"},
+	}, links)
+}
@@ -0,0 +1,286 @@
+package syndication
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/sivy/goldfrog/pkg/render"
+)
+
+const (
+	blueskyMaxMessageLen int    = 300
+	blueskyDefaultServer string = "https://bsky.social"
+)
+
+func init() {
+	Register("bluesky", Registration{
+		Options: func() interface{} { return &BlueskyOpts{} },
+		New: func(name string, options interface{}) Hook {
+			return NewBlueskyPoster(*options.(*BlueskyOpts))
+		},
+	})
+}
+
+type BlueskyOpts struct {
+	// Service is the PDS to post through, defaults to https://bsky.social
+	Service     string `yaml:"service"`
+	Handle      string `yaml:"handle"`
+	AppPassword string `yaml:"apppassword"`
+	LinkFormat  string `yaml:"linkformat"`
+	// Template is a text/template for the post, see MessageData
+	Template string `yaml:"template"`
+}
+
+/*
+BlueskyPoster posts to Bluesky through the AT Protocol's XRPC api: it
+creates a session with the handle and an app password, uploads the
+post's image (if any) as a blob and creates an app.bsky.feed.post
+record.
+*/
+type BlueskyPoster struct {
+	Service     string
+	Handle      string
+	AppPassword string
+	Message     MessageFormat
+
+	client *http.Client
+}
+
+type blueskySession struct {
+	AccessJwt string `json:"accessJwt"`
+	Did       string `json:"did"`
+	Handle    string `json:"handle"`
+}
+
+type blueskyFacet struct {
+	Index struct {
+		ByteStart int `json:"byteStart"`
+		ByteEnd   int `json:"byteEnd"`
+	} `json:"index"`
+	Features []map[string]string `json:"features"`
+}
+
+func (bp *BlueskyPoster) FormatMessage(postData PostData) string {
+	return bp.Message.formatOrDefault(postData)
+}
+
+func (bp *BlueskyPoster) Preview(postData PostData) Preview {
+	return previewMessage(bp.Message, postData)
+}
+
+var facetTagRegex = regexp.MustCompile(`(^|\s)#([[:alnum:]_]+)`)
+
+/*
+messageFacets marks up the links and hashtags in text. Bluesky wants
+the positions as byte offsets into the UTF-8 text.
+*/
+func messageFacets(text string) []blueskyFacet {
+	var facets []blueskyFacet
+
+	for _, loc := range linkRegex.FindAllStringIndex(text, -1) {
+		link := strings.TrimRight(text[loc[0]:loc[1]], ".,;:!?'\"")
+		var facet blueskyFacet
+		facet.Index.ByteStart = loc[0]
+		facet.Index.ByteEnd = loc[0] + len(link)
+		facet.Features = []map[string]string{{
+			"$type": "app.bsky.richtext.facet#link",
+			"uri":   link,
+		}}
+		facets = append(facets, facet)
+	}
+
+	for _, loc := range facetTagRegex.FindAllStringSubmatchIndex(text, -1) {
+		// loc[4]:loc[5] is the tag, the # is just before it
+		var facet blueskyFacet
+		facet.Index.ByteStart = loc[4] - 1
+		facet.Index.ByteEnd = loc[5]
+		facet.Features = []map[string]string{{
+			"$type": "app.bsky.richtext.facet#tag",
+			"tag":   text[loc[4]:loc[5]],
+		}}
+		facets = append(facets, facet)
+	}
+
+	return facets
+}
+
+// xrpc calls an XRPC procedure with a JSON (or raw, with contentType) body
+func (bp *BlueskyPoster) xrpc(
+	ctx context.Context, method string, token string, body interface{}, contentType string,
+	result interface{}) error {
+
+	var reqBody []byte
+	if raw, ok := body.([]byte); ok {
+		reqBody = raw
+	} else {
+		var err error
+		reqBody, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		contentType = "application/json"
+	}
+
+	url := strings.TrimRight(bp.Service, "/") + "/xrpc/" + method
+	req, err := http.NewRequest("POST", url, bytes.NewReader(reqBody))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", contentType)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := bp.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s returned %d: %s", method, resp.StatusCode, respBody)
+	}
+	return json.Unmarshal(respBody, result)
+}
+
+func (bp *BlueskyPoster) createSession(ctx context.Context) (blueskySession, error) {
+	var session blueskySession
+	err := bp.xrpc(ctx, "com.atproto.server.createSession", "", map[string]string{
+		"identifier": bp.Handle,
+		"password":   bp.AppPassword,
+	}, "", &session)
+	return session, err
+}
+
+func (bp *BlueskyPoster) uploadBlob(ctx context.Context, session blueskySession, content []byte) (json.RawMessage, error) {
+	var result struct {
+		Blob json.RawMessage `json:"blob"`
+	}
+	err := bp.xrpc(
+		ctx, "com.atproto.repo.uploadBlob", session.AccessJwt, content,
+		http.DetectContentType(content), &result)
+	return result.Blob, err
+}
+
+/*
+postEmbed attaches the post's images (up to four, with their alt text)
+if there are any, else a link card for articles.
+*/
+func (bp *BlueskyPoster) postEmbed(ctx context.Context, session blueskySession, postData PostData) map[string]interface{} {
+	var images []map[string]interface{}
+	for _, media := range firstMedia(postData.Media) {
+		blob, err := bp.uploadBlob(ctx, session, media.Content)
+		if err != nil {
+			logger.Errorf("Could not upload media: %s", err)
+			continue
+		}
+		images = append(images, map[string]interface{}{
+			"alt":   media.description(),
+			"image": blob,
+		})
+	}
+	if len(images) > 0 {
+		return map[string]interface{}{
+			"$type":  "app.bsky.embed.images",
+			"images": images,
+		}
+	}
+
+	if postData.Title != "" && postData.PermaLink != "" {
+		description := strings.Split(render.ToText(postData.Body), "\n\n")[0]
+		return map[string]interface{}{
+			"$type": "app.bsky.embed.external",
+			"external": map[string]string{
+				"uri":         postData.PermaLink,
+				"title":       postData.Title,
+				"description": truncate(description, 300, graphemeLen),
+			},
+		}
+	}
+	return nil
+}
+
+func (bp *BlueskyPoster) HandlePost(ctx context.Context, postData PostData) Result {
+	logger.Infof("Handling Bluesky crosspost...")
+	session, err := bp.createSession(ctx)
+	if err != nil {
+		return failed(fmt.Errorf("Could not create Bluesky session: %v", err))
+	}
+
+	text := bp.FormatMessage(postData)
+	record := map[string]interface{}{
+		"$type":     "app.bsky.feed.post",
+		"text":      text,
+		"createdAt": time.Now().UTC().Format(time.RFC3339),
+	}
+	if facets := messageFacets(text); len(facets) > 0 {
+		record["facets"] = facets
+	}
+	if embed := bp.postEmbed(ctx, session, postData); embed != nil {
+		record["embed"] = embed
+	}
+
+	var result struct {
+		URI string `json:"uri"`
+		CID string `json:"cid"`
+	}
+	logger.Debugf("Sending Bluesky post..")
+	err = bp.xrpc(ctx, "com.atproto.repo.createRecord", session.AccessJwt, map[string]interface{}{
+		"repo":       session.Did,
+		"collection": "app.bsky.feed.post",
+		"record":     record,
+	}, "", &result)
+	if err != nil {
+		return failed(err)
+	}
+
+	res := Result{
+		ID:   result.URI,
+		URL:  bp.LinkForID(result.URI),
+		Meta: map[string]string{"uri": result.URI},
+	}
+	logger.Debugf("Posted results: %v", res)
+	return res
+}
+
+/*
+LinkForID turns a post's at:// uri (at://{did}/app.bsky.feed.post/{rkey})
+into its bsky.app url.
+*/
+func (bp *BlueskyPoster) LinkForID(id string) string {
+	parts := strings.Split(strings.TrimPrefix(id, "at://"), "/")
+	if len(parts) != 3 {
+		return ""
+	}
+	return fmt.Sprintf("https://bsky.app/profile/%s/post/%s", parts[0], parts[2])
+}
+
+func NewBlueskyPoster(opts BlueskyOpts) *BlueskyPoster {
+	service := opts.Service
+	if service == "" {
+		service = blueskyDefaultServer
+	}
+	return &BlueskyPoster{
+		Service:     service,
+		Handle:      opts.Handle,
+		AppPassword: opts.AppPassword,
+		Message: MessageFormat{
+			Template:   opts.Template,
+			LinkFormat: opts.LinkFormat,
+			MaxLen:     blueskyMaxMessageLen,
+		},
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
@@ -0,0 +1,151 @@
+package syndication
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// a stand-in PDS that records the post it is sent
+func blueskyTestServer(t *testing.T, record *map[string]interface{}, blobs *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.URL.Path {
+		case "/xrpc/com.atproto.server.createSession":
+			var creds map[string]string
+			json.NewDecoder(r.Body).Decode(&creds)
+			if creds["identifier"] != "me.example" || creds["password"] != "app-pw" {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"error": "AuthenticationRequired"}`))
+				return
+			}
+			w.Write([]byte(`{"accessJwt": "jwt", "did": "did:plc:abc", "handle": "me.example"}`))
+
+		case "/xrpc/com.atproto.repo.uploadBlob":
+			assert.Equal(t, "Bearer jwt", r.Header.Get("Authorization"))
+			assert.Equal(t, "image/png", r.Header.Get("Content-Type"))
+			*blobs++
+			w.Write([]byte(`{"blob": {"$type": "blob", "ref": {"$link": "bafy"}, "mimeType": "image/png", "size": 8}}`))
+
+		case "/xrpc/com.atproto.repo.createRecord":
+			assert.Equal(t, "Bearer jwt", r.Header.Get("Authorization"))
+			body, _ := ioutil.ReadAll(r.Body)
+			var req struct {
+				Repo   string                 `json:"repo"`
+				Record map[string]interface{} `json:"record"`
+			}
+			assert.Nil(t, json.Unmarshal(body, &req))
+			assert.Equal(t, "did:plc:abc", req.Repo)
+			*record = req.Record
+			w.Write([]byte(`{"uri": "at://did:plc:abc/app.bsky.feed.post/3k2a", "cid": "bafyrei"}`))
+
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestBlueskyHandlePost(t *testing.T) {
+	var record map[string]interface{}
+	var blobs int
+	server := blueskyTestServer(t, &record, &blobs)
+	defer server.Close()
+
+	poster := NewBlueskyPoster(BlueskyOpts{
+		Service:     server.URL,
+		Handle:      "me.example",
+		AppPassword: "app-pw",
+	})
+
+	result := poster.HandlePost(context.Background(), PostData{
+		Title:     "Café ☕ time",
+		Slug:      "cafe-time",
+		Body:      "Ünïcode first #coffee",
+		Tags:      []string{"coffee", "morning"},
+		PermaLink: "https://blog.example/2020/01/01/cafe-time",
+	})
+
+	assert.Equal(t, "at://did:plc:abc/app.bsky.feed.post/3k2a", result.Meta["uri"])
+	assert.Equal(t, "https://bsky.app/profile/did:plc:abc/post/3k2a", result.URL)
+
+	text := record["text"].(string)
+	facets := record["facets"].([]interface{})
+	var found []string
+	for _, f := range facets {
+		index := f.(map[string]interface{})["index"].(map[string]interface{})
+		start := int(index["byteStart"].(float64))
+		end := int(index["byteEnd"].(float64))
+		found = append(found, text[start:end])
+	}
+	assert.ElementsMatch(t, []string{
+		"https://blog.example/2020/01/01/cafe-time", "#coffee", "#morning",
+	}, found)
+
+	embed := record["embed"].(map[string]interface{})
+	assert.Equal(t, "app.bsky.embed.external", embed["$type"])
+	assert.Equal(t, 0, blobs)
+
+	// with images, up to four are attached instead
+	png := []byte("\x89PNG\r\n\x1a\n")
+	var media []Media
+	for i := 0; i < 5; i++ {
+		media = append(media, Media{Content: png, Alt: fmt.Sprintf("image %d", i)})
+	}
+	poster.HandlePost(context.Background(), PostData{
+		Slug:  "txt-123",
+		Body:  "look",
+		Media: media,
+	})
+	assert.Equal(t, 4, blobs)
+	embed = record["embed"].(map[string]interface{})
+	assert.Equal(t, "app.bsky.embed.images", embed["$type"])
+	images := embed["images"].([]interface{})
+	assert.Equal(t, 4, len(images))
+	assert.Equal(t, "image 0", images[0].(map[string]interface{})["alt"])
+
+	poster.AppPassword = "wrong"
+	result = poster.HandlePost(context.Background(), PostData{Body: "nope"})
+	assert.Equal(t, StatusFailed, result.Status)
+	assert.Contains(t, result.Error, "AuthenticationRequired")
+}
+
+func TestBlueskyFormatMessageLength(t *testing.T) {
+	poster := NewBlueskyPoster(BlueskyOpts{})
+
+	// 250 graphemes but 1000 bytes: fits Bluesky's limit
+	body := strings.Repeat("👍🏽", 250)
+	message := poster.FormatMessage(PostData{Slug: "txt-1", Body: body})
+	assert.Contains(t, message, body)
+	assert.True(t, graphemeLen(message) <= blueskyMaxMessageLen)
+
+	long := strings.Repeat("é", 400)
+	message = poster.FormatMessage(PostData{Slug: "txt-1", Body: long})
+	assert.True(t, strings.HasSuffix(strings.Split(message, "\n\n")[0], "…"))
+	assert.True(t, graphemeLen(message) <= blueskyMaxMessageLen)
+}
+
+func TestGraphemeLen(t *testing.T) {
+	assert.Equal(t, 5, graphemeLen("hello"))
+	assert.Equal(t, 4, graphemeLen("café"))
+	assert.Equal(t, 1, graphemeLen("👩‍👩‍👧"))
+	assert.Equal(t, 2, graphemeLen("🇺🇸🇫🇷"))
+	assert.Equal(t, 1, graphemeLen("👍🏽"))
+	// spacing marks: the vowel signs in किताब stay with their consonants
+	assert.Equal(t, 3, graphemeLen("किताब"))
+	assert.Equal(t, 1, graphemeLen("कि"))
+	// Hangul syllables spelled out in conjoining jamo
+	assert.Equal(t, 2, graphemeLen("\u1112\u1161\u11ab\u1100\u1173\u11af"))
+	// a prepended concatenation mark joins what follows it
+	assert.Equal(t, 1, graphemeLen("\u0600\u0661"))
+	// subdivision flags are emoji tag sequences
+	assert.Equal(t, 1, graphemeLen("🏴\U000e0067\U000e0062\U000e0073\U000e0063\U000e0074\U000e007f"))
+	assert.Equal(t, []string{"a", "\r\n", "b"}, graphemes("a\r\nb"))
+}
@@ -3,8 +3,15 @@ package syndication
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
-	"regexp"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
 	"strings"
 
 	mastodon "github.com/mattn/go-mastodon"
@@ -12,145 +19,426 @@ import (
 
 const (
 	mastodonMaxMessageLen int = 500
+	// Mastodon counts every link as 23 characters, whatever its length
+	mastodonURLLen int = 23
+	// long posts are split into threads of at most this many statuses
+	mastodonMaxThread int = 10
+
+	mastodonDefaultVisibility string = "unlisted"
+)
+
+/*
+Front matter a post can set to change how it goes to a Mastodon
+target, as {name}_{option} like the {name}_id it gets back (so
+mastodon_cw for a target called mastodon); the defaults come from the
+target's options.
+
+	visibility  public, unlisted or private
+	cw          the content warning: "auto" for the title and tags,
+	            "none" for none, or the text to use
+	language    the ISO 639 code of the post's language
+	thread      "true" to post long posts as a thread of replies
+	            rather than cut them short
+*/
+const (
+	MastodonVisibilityOption     string = "visibility"
+	MastodonContentWarningOption string = "cw"
+	MastodonLanguageOption       string = "language"
+	MastodonThreadOption         string = "thread"
 )
 
+// MastodonOptions are the front matter options of a Mastodon target
+var MastodonOptions = []string{
+	MastodonVisibilityOption,
+	MastodonContentWarningOption,
+	MastodonLanguageOption,
+	MastodonThreadOption,
+}
+
+// MastodonVisibilities are the visibilities a post can have
+var MastodonVisibilities = []string{"public", "unlisted", "private"}
+
+func init() {
+	Register("mastodon", Registration{
+		Options: func() interface{} { return &MastodonOpts{} },
+		New: func(name string, options interface{}) Hook {
+			xp := NewMastodonPoster(*options.(*MastodonOpts))
+			xp.Name = name
+			return xp
+		},
+	})
+}
+
 type MastodonPoster struct {
+	// Name is the target's, its results are recorded under it
+	Name         string
 	Site         string
 	ClientID     string
 	ClientSecret string
 	AccessToken  string
-	LinkFormat   string
-	MaxLen       int
+	Message      MessageFormat
+
+	Visibility     string
+	ContentWarning string
+	Language       string
+	Thread         bool
 }
 
-func (xp *MastodonPoster) FormatMessage(postData PostData) string {
+// mastodonToot is a status to post, with the fields go-mastodon's Toot lacks
+type mastodonToot struct {
+	Status      string
+	SpoilerText string
+	Sensitive   bool
+	Visibility  string
+	Language    string
+	InReplyToID string
+	MediaIDs    []string
+}
 
-	source := stripHTML(markDowner(postData.Body))
-	var title string
-	var link string
-
-	if postData.Title != "" {
-		title = postData.Title
-		link = fmt.Sprintf("(%s)", postData.PermaLink)
-	}
-	if postData.Title == "" {
-		link = fmt.Sprintf("(monkinetic %s)", postData.Slug)
-	}
-
-	var fmtTagStr string
-	if len(postData.Tags) != 0 {
-		var fmtTags []string
-		for _, t := range postData.Tags {
-			if t == "" {
-				continue
-			}
-			if regexp.MustCompile("#" + t).MatchString(source) {
-				continue
-			}
-			fmtTags = append(fmtTags, fmt.Sprintf("#%s", t))
-		}
-		fmtTagStr = strings.Join(fmtTags, " ")
+func (toot mastodonToot) form() url.Values {
+	form := url.Values{}
+	form.Set("status", toot.Status)
+	form.Set("spoiler_text", toot.SpoilerText)
+	form.Set("sensitive", strconv.FormatBool(toot.Sensitive))
+	if toot.Visibility != "" {
+		form.Set("visibility", toot.Visibility)
 	}
-
-	var messageParts []string
-	availableChars := xp.MaxLen
-	if title != "" {
-		availableChars -= len(title) + 2 // len(\n\n)
+	if toot.Language != "" {
+		form.Set("language", toot.Language)
 	}
-	if link != "" {
-		availableChars -= len(link) + 2 // len(\n\n)
+	if toot.InReplyToID != "" {
+		form.Set("in_reply_to_id", toot.InReplyToID)
 	}
-	if fmtTagStr != "" {
-		availableChars -= len(fmtTagStr) + 2 // len(\n\n)
+	for _, id := range toot.MediaIDs {
+		form.Add("media_ids[]", id)
 	}
+	return form
+}
 
-	// split paras
-	sourceParas := strings.Split(source, "\n\n")
-	var messageBody string
+// tootOptions are the account's defaults overridden by the post's front matter
+type tootOptions struct {
+	Visibility     string
+	ContentWarning string
+	Language       string
+	Thread         bool
+}
 
-	if len(source) < availableChars {
-		// if message fits, do it all
-		messageBody = source
-	} else {
-		// if it doesn't, only do first para
-		messageBody = sourceParas[0]
+func (xp *MastodonPoster) tootOptions(postData PostData) tootOptions {
+	opts := tootOptions{
+		Visibility:     xp.Visibility,
+		ContentWarning: xp.ContentWarning,
+		Language:       xp.Language,
+		Thread:         xp.Thread,
 	}
 
-	// find closes para that fits in available length
-
-	if title != "" {
-		messageParts = append(messageParts, title)
+	fm := postData.FrontMatter
+	prefix := xp.Name + "_"
+	if v := fm[prefix+MastodonVisibilityOption]; v != "" {
+		opts.Visibility = v
+	}
+	if v := fm[prefix+MastodonContentWarningOption]; v != "" {
+		opts.ContentWarning = v
+	}
+	if v := fm[prefix+MastodonLanguageOption]; v != "" {
+		opts.Language = v
+	}
+	if v := fm[prefix+MastodonThreadOption]; v != "" {
+		opts.Thread, _ = strconv.ParseBool(v)
 	}
-	messageParts = append(messageParts, messageBody)
 
-	if link != "" {
-		messageParts = append(messageParts, link)
+	valid := false
+	for _, visibility := range MastodonVisibilities {
+		valid = valid || opts.Visibility == visibility
 	}
-	if fmtTagStr != "" {
-		messageParts = append(messageParts, fmtTagStr)
+	if !valid {
+		if opts.Visibility != "" {
+			logger.Warnf("Unknown Mastodon visibility %q, using %s",
+				opts.Visibility, mastodonDefaultVisibility)
+		}
+		opts.Visibility = mastodonDefaultVisibility
 	}
+	return opts
+}
 
-	microMessage := strings.Join(messageParts, "\n\n")
-	logger.Debugf("microMessage: %s", microMessage)
-	return microMessage
+func (xp *MastodonPoster) FormatMessage(postData PostData) string {
+	return xp.makeToots(postData)[0].Status
 }
 
-func (xp *MastodonPoster) HandlePost(postData PostData) map[string]string {
-	logger.Infof("Handling Mastodon crosspost...")
-	c := mastodon.NewClient(&mastodon.Config{
+/*
+Preview shows the status (and any replies) with its content warning,
+which Mastodon counts too.
+*/
+func (xp *MastodonPoster) Preview(postData PostData) Preview {
+	opts := xp.tootOptions(postData)
+	spoiler := spoilerText(postData, opts.ContentWarning)
+	mf := xp.Message
+	mf.MaxLen -= graphemeLen(spoiler)
+
+	preview := previewMessage(mf, postData)
+	if opts.Thread && preview.Error == "" {
+		thread, _ := mf.FormatThread(postData, mastodonMaxThread)
+		preview.Text = thread[0]
+		preview.Length = mf.Length(thread[0])
+		preview.Replies = thread[1:]
+	}
+	preview.ContentWarning = spoiler
+	preview.Length += graphemeLen(spoiler)
+	preview.MaxLen = xp.Message.MaxLen
+	return preview
+}
+
+func (xp *MastodonPoster) client() *mastodon.Client {
+	return mastodon.NewClient(&mastodon.Config{
 		Server:       xp.Site,
 		ClientID:     xp.ClientID,
 		ClientSecret: xp.ClientSecret,
 		AccessToken:  xp.AccessToken,
 	})
+}
 
-	if postData.Title == "" {
-		postData.ShortID = postData.Slug
+/*
+spoilerText is the content warning for a post: with cw "auto" (or
+empty) its title and tags, with "none" nothing, else cw itself.
+*/
+func spoilerText(postData PostData, cw string) string {
+	switch strings.ToLower(cw) {
+	case "", "auto":
+	case "none":
+		return ""
+	default:
+		return cw
 	}
 
-	var content = xp.FormatMessage(postData)
-
-	toot := mastodon.Toot{
-		Status:     content,
-		Visibility: "unlisted",
-		Sensitive:  true,
+	spoiler := postData.Title
+	tagStr := strings.Join(postData.Tags, ", ")
+	if tagStr != "" {
+		if spoiler != "" {
+			spoiler += fmt.Sprintf(" (%s)", tagStr)
+		} else {
+			spoiler = tagStr
+		}
 	}
+	return spoiler
+}
 
-	tagStr := strings.Join(postData.Tags, ", ")
-	if postData.Title != "" {
-		toot.SpoilerText = postData.Title
-		if tagStr != "" {
-			toot.SpoilerText += fmt.Sprintf(" (%s)", tagStr)
+/*
+makeToots builds the statuses for a post, minus any media: one, or a
+thread if the post asks for it and is too long for one. The content
+warning counts against the limit, so the message gets what's left.
+*/
+func (xp *MastodonPoster) makeToots(postData PostData) []mastodonToot {
+	opts := xp.tootOptions(postData)
+	spoiler := spoilerText(postData, opts.ContentWarning)
+	mf := xp.Message
+	mf.MaxLen -= graphemeLen(spoiler)
+
+	messages := []string{mf.formatOrDefault(postData)}
+	if opts.Thread {
+		thread, err := mf.FormatThread(postData, mastodonMaxThread)
+		if err != nil {
+			logger.Errorf("Could not format thread: %v", err)
+		} else {
+			messages = thread
 		}
-	} else {
-		if tagStr != "" {
-			toot.SpoilerText = tagStr
+	}
+
+	toots := make([]mastodonToot, len(messages))
+	for i, message := range messages {
+		toots[i] = mastodonToot{
+			Status:      message,
+			SpoilerText: spoiler,
+			Sensitive:   spoiler != "",
+			Visibility:  opts.Visibility,
+			Language:    opts.Language,
 		}
 	}
+	return toots
+}
+
+/*
+statusRequest sends a status to the statuses api. go-mastodon can't
+set a status's language or edit one, so this does the request itself.
+*/
+func (xp *MastodonPoster) statusRequest(
+	ctx context.Context, method string, path string,
+	form url.Values) (mastodon.Status, error) {
+
+	var status mastodon.Status
+	err := xp.apiRequest(
+		ctx, method, "/api/v1/statuses"+path,
+		"application/x-www-form-urlencoded", strings.NewReader(form.Encode()),
+		&status)
+	return status, err
+}
+
+// apiRequest calls the Mastodon api and decodes its JSON response into result
+func (xp *MastodonPoster) apiRequest(
+	ctx context.Context, method string, path string,
+	contentType string, body io.Reader, result interface{}) error {
 
-	if len(postData.MediaContent) > 0 {
-		r := bytes.NewReader(postData.MediaContent)
-		att, err := c.UploadMediaFromReader(context.Background(), r)
+	req, err := http.NewRequest(
+		method, strings.TrimRight(xp.Site, "/")+path, body)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Authorization", "Bearer "+xp.AccessToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf(
+			"Mastodon returned %d: %s", resp.StatusCode, respBody)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+/*
+uploadMedia uploads an image with its description (which go-mastodon
+can't send) and returns its id.
+*/
+func (xp *MastodonPoster) uploadMedia(ctx context.Context, media Media) (string, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", `form-data; name="file"; filename="image"`)
+	contentType := media.Type
+	if contentType == "" {
+		contentType = http.DetectContentType(media.Content)
+	}
+	header.Set("Content-Type", contentType)
+	part, err := mw.CreatePart(header)
+	if err != nil {
+		return "", err
+	}
+	part.Write(media.Content)
+	if description := media.description(); description != "" {
+		mw.WriteField("description", description)
+	}
+	mw.Close()
+
+	var attachment mastodon.Attachment
+	err = xp.apiRequest(
+		ctx, "POST", "/api/v1/media", mw.FormDataContentType(), &body,
+		&attachment)
+	return string(attachment.ID), err
+}
+
+/*
+HandlePost posts a post's status, and for a thread the rest as
+replies, each to the one before. The result is the first status, with
+the ids of the replies as thread_ids; if a reply fails, the error is
+kept as thread_error.
+*/
+func (xp *MastodonPoster) HandlePost(ctx context.Context, postData PostData) Result {
+	logger.Infof("Handling Mastodon crosspost...")
+
+	toots := xp.makeToots(postData)
+
+	for _, media := range firstMedia(postData.Media) {
+		id, err := xp.uploadMedia(ctx, media)
 		if err != nil {
 			logger.Errorf("Could not upload media: %s", err)
-		} else {
-			toot.MediaIDs = []mastodon.ID{att.ID}
+			continue
 		}
+		toots[0].MediaIDs = append(toots[0].MediaIDs, id)
 	}
 
-	var resultData = make(map[string]string)
-
 	logger.Debugf("Sending Mastodon post..")
-	status, err := c.PostStatus(context.Background(), &toot)
+	status, err := xp.statusRequest(ctx, "POST", "", toots[0].form())
 	if err != nil {
-		logger.Error(err)
-		return resultData
+		return failed(err)
 	}
-	resultData["mastodon_id"] = string(status.ID)
-	resultData["mastodon_url"] = string(status.URL)
+	result := Result{ID: string(status.ID), URL: status.URL}
 
-	logger.Debugf("Posted results: %v", resultData)
-	return resultData
+	replyTo := string(status.ID)
+	var replies []string
+	for _, toot := range toots[1:] {
+		toot.InReplyToID = replyTo
+		reply, err := xp.statusRequest(ctx, "POST", "", toot.form())
+		if err != nil {
+			logger.Errorf("Could not post the rest of the thread: %v", err)
+			result.Meta = map[string]string{"thread_error": err.Error()}
+			break
+		}
+		replyTo = string(reply.ID)
+		replies = append(replies, replyTo)
+	}
+	if len(replies) > 0 {
+		if result.Meta == nil {
+			result.Meta = make(map[string]string)
+		}
+		result.Meta["thread_ids"] = strings.Join(replies, ",")
+	}
+
+	logger.Debugf("Posted results: %v", result)
+	return result
+}
+
+/*
+UpdatePost edits the status id to match the post. The status keeps
+the media it already has; replies in a thread are left as they are.
+*/
+func (xp *MastodonPoster) UpdatePost(ctx context.Context, id string, postData PostData) Result {
+	logger.Infof("Updating Mastodon status %s...", id)
+
+	current, err := xp.client().GetStatus(ctx, mastodon.ID(id))
+	if err != nil {
+		return failed(err)
+	}
+
+	toot := xp.makeToots(postData)[0]
+	for _, media := range current.MediaAttachments {
+		toot.MediaIDs = append(toot.MediaIDs, string(media.ID))
+	}
+	form := toot.form()
+	// visibility can't be changed by an edit
+	form.Del("visibility")
+
+	status, err := xp.statusRequest(ctx, "PUT", "/"+url.PathEscape(id), form)
+	if err != nil {
+		return failed(err)
+	}
+
+	result := Result{
+		Status: StatusUpdated,
+		ID:     string(status.ID),
+		URL:    status.URL,
+	}
+	logger.Debugf("Updated results: %v", result)
+	return result
+}
+
+/*
+DeletePost deletes the status id, and the replies we posted with it as
+a thread (its {name}_thread_ids), last first. Other replies, ours
+included, are left alone.
+*/
+func (xp *MastodonPoster) DeletePost(
+	ctx context.Context, id string, frontMatter map[string]string) error {
+	logger.Infof("Deleting Mastodon status %s...", id)
+	c := xp.client()
+
+	replies := strings.Split(frontMatter[xp.Name+"_thread_ids"], ",")
+	for i := len(replies) - 1; i >= 0; i-- {
+		reply := strings.TrimSpace(replies[i])
+		if reply == "" {
+			continue
+		}
+		err := c.DeleteStatus(ctx, mastodon.ID(reply))
+		if err != nil {
+			logger.Errorf("Could not delete reply %s: %v", reply, err)
+		}
+	}
+	return c.DeleteStatus(ctx, mastodon.ID(id))
 }
 
 func (xp *MastodonPoster) LinkForID(id string) string {
@@ -159,10 +447,20 @@ func (xp *MastodonPoster) LinkForID(id string) string {
 
 func NewMastodonPoster(opts MastodonOpts) *MastodonPoster {
 	return &MastodonPoster{
+		Name:         "mastodon",
 		Site:         opts.Site,
 		ClientID:     opts.ClientID,
 		ClientSecret: opts.ClientSecret,
 		AccessToken:  opts.AccessToken,
-		MaxLen:       500,
+		Message: MessageFormat{
+			Template:   opts.Template,
+			LinkFormat: opts.LinkFormat,
+			MaxLen:     mastodonMaxMessageLen,
+			URLLen:     mastodonURLLen,
+		},
+		Visibility:     opts.Visibility,
+		ContentWarning: opts.ContentWarning,
+		Language:       opts.Language,
+		Thread:         opts.Thread,
 	}
 }
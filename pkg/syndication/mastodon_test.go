@@ -0,0 +1,245 @@
+package syndication
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// a stand-in Mastodon server with a single status, 1, that has an image
+func mastodonTestServer(t *testing.T, edited *http.Request, deleted *bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path != "/api/v1/statuses/1" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": "Record not found"}`))
+			return
+		}
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+
+		switch r.Method {
+		case "GET":
+			w.Write([]byte(`{"id": "1", "url": "https://m.example/@me/1",
+				"media_attachments": [{"id": "7", "type": "image"}]}`))
+		case "PUT":
+			r.ParseForm()
+			*edited = *r
+			w.Write([]byte(`{"id": "1", "url": "https://m.example/@me/1"}`))
+		case "DELETE":
+			*deleted = true
+			w.Write([]byte(`{}`))
+		}
+	}))
+}
+
+func TestMastodonUpdatePost(t *testing.T) {
+	var edited http.Request
+	var deleted bool
+	server := mastodonTestServer(t, &edited, &deleted)
+	defer server.Close()
+
+	xp := NewMastodonPoster(MastodonOpts{Site: server.URL, AccessToken: "token"})
+	result := xp.UpdatePost(context.Background(), "1", PostData{
+		Title:     "Edited",
+		Body:      "Now with fewer typos",
+		PermaLink: "https://example.com/edited",
+	})
+
+	assert.Equal(t, StatusUpdated, result.Status)
+	assert.Equal(t, "1", result.ID)
+	assert.Equal(t, "https://m.example/@me/1", result.URL)
+	assert.Contains(t, edited.PostForm.Get("status"), "Now with fewer typos")
+	assert.Equal(t, []string{"7"}, edited.PostForm["media_ids[]"])
+
+	result = xp.UpdatePost(context.Background(), "2", PostData{Body: "gone"})
+	assert.Equal(t, StatusFailed, result.Status)
+}
+
+func TestUpdateAndDeleteSyndicated(t *testing.T) {
+	var edited http.Request
+	var deleted bool
+	server := mastodonTestServer(t, &edited, &deleted)
+	defer server.Close()
+
+	targets := []Target{
+		{Name: "mastodon", Type: "mastodon", Options: map[string]interface{}{
+			"site":        server.URL,
+			"accesstoken": "token",
+		}},
+		// twitter can't edit, so is left alone
+		{Name: "twitter", Type: "twitter"},
+		// never sent here
+		{Name: "other", Type: "mastodon"},
+	}
+	ids := map[string]string{"mastodon": "1", "twitter": "99"}
+
+	results := UpdateSyndicated(
+		context.Background(), targets, ids, PostData{Body: "edited"})
+	if assert.Len(t, results, 1) {
+		assert.Equal(t, "mastodon", results[0].Target)
+		assert.Equal(t, StatusUpdated, results[0].Status)
+	}
+
+	results = DeleteSyndicated(context.Background(), targets, ids, nil)
+	if assert.Len(t, results, 1) {
+		assert.Equal(t, "mastodon", results[0].Target)
+		assert.Equal(t, StatusDeleted, results[0].Status)
+	}
+	assert.True(t, deleted)
+
+	assert.True(t, CanUpdate(targets[0]))
+	assert.False(t, CanUpdate(targets[1]))
+}
+
+func TestMastodonThread(t *testing.T) {
+	var posted []url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "/api/v1/statuses", r.URL.Path)
+		r.ParseForm()
+		posted = append(posted, r.PostForm)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"id": "%d", "url": "https://m.example/@me/%d"}`,
+			len(posted), len(posted))
+	}))
+	defer server.Close()
+
+	xp := NewMastodonPoster(MastodonOpts{
+		Site:        server.URL,
+		AccessToken: "token",
+		Visibility:  "public",
+		Language:    "en",
+	})
+	body := strings.Repeat("This sentence goes on for a while. ", 30)
+	postData := PostData{
+		Body: body,
+		FrontMatter: map[string]string{
+			"mastodon_thread":   "true",
+			"mastodon_cw":       "none",
+			"mastodon_language": "de",
+		},
+	}
+
+	result := xp.HandlePost(context.Background(), postData)
+	assert.Equal(t, "", result.Error)
+	assert.Equal(t, "1", result.ID)
+	assert.Equal(t, "2,3", result.Meta["thread_ids"])
+
+	if assert.Equal(t, 3, len(posted)) {
+		assert.Equal(t, "public", posted[0].Get("visibility"))
+		assert.Equal(t, "de", posted[0].Get("language"))
+		assert.Equal(t, "", posted[0].Get("spoiler_text"))
+		assert.Equal(t, "false", posted[0].Get("sensitive"))
+		assert.Equal(t, "", posted[0].Get("in_reply_to_id"))
+		assert.True(t, strings.HasSuffix(posted[0].Get("status"), " (1/3)"))
+		assert.Equal(t, "1", posted[1].Get("in_reply_to_id"))
+		assert.Equal(t, "2", posted[2].Get("in_reply_to_id"))
+		assert.True(t, strings.HasSuffix(posted[2].Get("status"), " (3/3)"))
+	}
+	for _, form := range posted {
+		assert.True(t, graphemeLen(form.Get("status")) <= mastodonMaxMessageLen)
+	}
+
+	preview := xp.Preview(postData)
+	assert.Equal(t, 2, len(preview.Replies))
+}
+
+func TestMastodonTootOptions(t *testing.T) {
+	xp := NewMastodonPoster(MastodonOpts{ContentWarning: "Long post"})
+
+	toots := xp.makeToots(PostData{Title: "Title", Tags: []string{"go"}})
+	assert.Equal(t, 1, len(toots))
+	assert.Equal(t, "unlisted", toots[0].Visibility)
+	assert.Equal(t, "Long post", toots[0].SpoilerText)
+	assert.True(t, toots[0].Sensitive)
+
+	toots = xp.makeToots(PostData{
+		Title: "Title",
+		Tags:  []string{"go"},
+		FrontMatter: map[string]string{
+			"mastodon_visibility": "direct",
+			"mastodon_cw":         "auto",
+		},
+	})
+	// direct isn't one of the choices
+	assert.Equal(t, "unlisted", toots[0].Visibility)
+	assert.Equal(t, "Title (go)", toots[0].SpoilerText)
+
+	// each target has its own options
+	xp.Name = "work"
+	toots = xp.makeToots(PostData{
+		Title: "Title",
+		FrontMatter: map[string]string{
+			"mastodon_visibility": "public",
+			"work_visibility":     "private",
+			"work_cw":             "none",
+		},
+	})
+	assert.Equal(t, "private", toots[0].Visibility)
+	assert.Equal(t, "", toots[0].SpoilerText)
+}
+
+func TestMastodonMedia(t *testing.T) {
+	var descriptions []string
+	var posted url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/v1/media":
+			assert.Nil(t, r.ParseMultipartForm(1<<20))
+			_, header, err := r.FormFile("file")
+			assert.Nil(t, err)
+			assert.Equal(t, "image/png", header.Header.Get("Content-Type"))
+			descriptions = append(descriptions, r.FormValue("description"))
+			fmt.Fprintf(w, `{"id": "m%d"}`, len(descriptions))
+		case "/api/v1/statuses":
+			r.ParseForm()
+			posted = r.PostForm
+			w.Write([]byte(`{"id": "1"}`))
+		}
+	}))
+	defer server.Close()
+
+	xp := NewMastodonPoster(MastodonOpts{Site: server.URL, AccessToken: "token"})
+	png := []byte("\x89PNG\r\n\x1a\n")
+	xp.HandlePost(context.Background(), PostData{
+		Body: "pictures",
+		Media: []Media{
+			{Content: png, Alt: "one"},
+			{Content: png, Caption: "two"},
+			{Content: png},
+			{Content: png},
+			{Content: png, Alt: "five"},
+		},
+	})
+
+	assert.Equal(t, []string{"one", "two", "", ""}, descriptions)
+	assert.Equal(t, []string{"m1", "m2", "m3", "m4"}, posted["media_ids[]"])
+}
+
+func TestMastodonDeleteThread(t *testing.T) {
+	var deleted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "DELETE", r.Method)
+		deleted = append(deleted, strings.TrimPrefix(r.URL.Path, "/api/v1/statuses/"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	xp := NewMastodonPoster(MastodonOpts{Site: server.URL, AccessToken: "token"})
+	xp.Name = "toots"
+	err := xp.DeletePost(context.Background(), "1", map[string]string{
+		"toots_thread_ids":    "2,3",
+		"mastodon_thread_ids": "8,9",
+	})
+	assert.Nil(t, err)
+	// only the replies we posted, nothing else in the thread
+	assert.Equal(t, []string{"3", "2", "1"}, deleted)
+}
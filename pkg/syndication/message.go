@@ -0,0 +1,352 @@
+package syndication
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"github.com/rivo/uniseg"
+	"github.com/sivy/goldfrog/pkg/render"
+)
+
+/*
+Crosspost messages are rendered from a text/template per target (the
+`template` option) executed with a MessageData. The body the template
+sees has already been cut down to whatever room the rest of the
+message leaves under the network's limit.
+*/
+
+// DefaultMessageTemplate is used for targets without a template
+const DefaultMessageTemplate = "{{if .Title}}{{.Title}}\n\n{{end}}{{.Body}}" +
+	"{{if .Link}}\n\n{{.Link}}{{end}}{{if .Tags}}\n\n{{.Tags}}{{end}}"
+
+// defaultLinkFormat is used for targets without a `linkformat`
+const defaultLinkFormat = "(%s)"
+
+// MessageData is what message templates are executed with
+type MessageData struct {
+	Title string
+	Body  string
+	// Link is the permalink, written with the target's link format
+	Link      string
+	PermaLink string
+	Slug      string
+	ShortID   string
+	SiteName  string
+	// Tags are hashtags for the tags not already in the body
+	Tags    string
+	TagList []string
+	IsNote  bool
+}
+
+/*
+MessageFormat is how a network wants crossposts: MaxLen is its limit
+in graphemes, with every link counted as URLLen characters (Twitter
+and Mastodon count any link as 23; an URLLen of 0 counts links as
+written, like Bluesky).
+*/
+type MessageFormat struct {
+	Template   string
+	LinkFormat string
+	MaxLen     int
+	URLLen     int
+}
+
+// Length measures s the way the network does
+func (mf MessageFormat) Length(s string) int {
+	if mf.URLLen == 0 {
+		return graphemeLen(s)
+	}
+	var n, last int
+	for _, loc := range linkRegex.FindAllStringIndex(s, -1) {
+		n += graphemeLen(s[last:loc[0]]) + mf.URLLen
+		last = loc[1]
+	}
+	return n + graphemeLen(s[last:])
+}
+
+/*
+Format renders the message for a post, cutting the body short (see
+truncateText) so the whole message fits in MaxLen.
+*/
+func (mf MessageFormat) Format(postData PostData) (string, error) {
+	tmpl, err := mf.parse()
+	if err != nil {
+		return "", err
+	}
+
+	data := newMessageData(postData, mf.LinkFormat)
+	room, err := mf.bodyRoom(tmpl, data)
+	if err != nil {
+		return "", err
+	}
+
+	data.Body = truncateText(data.Body, room, mf.Length)
+	message, err := executeMessage(tmpl, data)
+	logger.Debugf("microMessage: %s", message)
+	return message, err
+}
+
+/*
+FormatThread renders a post that doesn't fit in one message as a
+thread: the first message is the template with as much of the body as
+fits, the others carry on with the rest of the body, and each ends
+with its number, like " (1/3)". Threads stop at maxParts messages, the
+last one cut short.
+*/
+func (mf MessageFormat) FormatThread(postData PostData, maxParts int) ([]string, error) {
+	tmpl, err := mf.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	data := newMessageData(postData, mf.LinkFormat)
+	room, err := mf.bodyRoom(tmpl, data)
+	if err != nil {
+		return nil, err
+	}
+	if mf.Length(data.Body) <= room || maxParts < 2 {
+		message, err := mf.Format(postData)
+		return []string{message}, err
+	}
+
+	counterLen := len(fmt.Sprintf(" (%d/%d)", maxParts, maxParts))
+	head, _ := cutText(data.Body, room-counterLen, mf.Length)
+	tail := strings.TrimSpace(data.Body[len(head):])
+	chunks := []string{head}
+	for tail != "" {
+		if len(chunks) == maxParts-1 {
+			chunks = append(chunks, truncateText(tail, mf.MaxLen-counterLen, mf.Length))
+			break
+		}
+		head, _ = cutText(tail, mf.MaxLen-counterLen, mf.Length)
+		if head == "" {
+			break
+		}
+		tail = strings.TrimSpace(tail[len(head):])
+		chunks = append(chunks, head)
+	}
+
+	messages := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		counter := fmt.Sprintf(" (%d/%d)", i+1, len(chunks))
+		if i > 0 {
+			messages[i] = chunk + counter
+			continue
+		}
+		data.Body = chunk
+		message, err := executeMessage(tmpl, data)
+		if err != nil {
+			return nil, err
+		}
+		messages[i] = message + counter
+	}
+	logger.Debugf("thread: %v", messages)
+	return messages, nil
+}
+
+func (mf MessageFormat) parse() (*template.Template, error) {
+	text := mf.Template
+	if text == "" {
+		text = DefaultMessageTemplate
+	}
+	return template.New("message").Parse(text)
+}
+
+// bodyRoom measures everything but the body to see how much of it fits
+func (mf MessageFormat) bodyRoom(tmpl *template.Template, data MessageData) (int, error) {
+	data.Body = ""
+	rest, err := executeMessage(tmpl, data)
+	return mf.MaxLen - mf.Length(rest), err
+}
+
+/*
+formatOrDefault formats with the default template if the target's
+doesn't work, so a broken template doesn't stop crossposting.
+*/
+func (mf MessageFormat) formatOrDefault(postData PostData) string {
+	message, err := mf.Format(postData)
+	if err != nil {
+		logger.Errorf("Could not format message, using the default template: %v", err)
+		mf.Template = ""
+		message, _ = mf.Format(postData)
+	}
+	return message
+}
+
+func executeMessage(tmpl *template.Template, data MessageData) (string, error) {
+	var buf bytes.Buffer
+	err := tmpl.Execute(&buf, data)
+	return strings.TrimSpace(buf.String()), err
+}
+
+func newMessageData(postData PostData, linkFormat string) MessageData {
+	body := strings.TrimSpace(render.ToText(postData.Body))
+
+	var hashtags []string
+	for _, t := range postData.Tags {
+		tag := strings.Replace(t, " ", "", -1)
+		if tag == "" || strings.Contains(body, "#"+tag) {
+			continue
+		}
+		hashtags = append(hashtags, "#"+tag)
+	}
+
+	shortID := postData.ShortID
+	if shortID == "" {
+		shortID = postData.Slug
+	}
+
+	return MessageData{
+		Title:     postData.Title,
+		Body:      body,
+		Link:      formatLink(linkFormat, postData.PermaLink),
+		PermaLink: postData.PermaLink,
+		Slug:      postData.Slug,
+		ShortID:   shortID,
+		SiteName:  postData.SiteName,
+		Tags:      strings.Join(hashtags, " "),
+		TagList:   postData.Tags,
+		IsNote:    postData.Title == "",
+	}
+}
+
+// formatLink writes permalink with linkFormat, a format with a single %s
+func formatLink(linkFormat string, permalink string) string {
+	if permalink == "" {
+		return ""
+	}
+	if linkFormat == "" {
+		linkFormat = defaultLinkFormat
+	}
+	if strings.Count(linkFormat, "%") != 1 || !strings.Contains(linkFormat, "%s") {
+		logger.Warnf("Ignoring link format %q, it needs a single %%s", linkFormat)
+		linkFormat = defaultLinkFormat
+	}
+	return fmt.Sprintf(linkFormat, permalink)
+}
+
+// Preview is the message a target would receive for a post
+type Preview struct {
+	Target         string `json:"target"`
+	Text           string `json:"text"`
+	ContentWarning string `json:"content_warning,omitempty"`
+	// Replies follow Text when a post goes out as a thread
+	Replies []string `json:"replies,omitempty"`
+	Length  int      `json:"length"`
+	MaxLen  int      `json:"max_length"`
+	Error   string   `json:"error,omitempty"`
+}
+
+func previewMessage(mf MessageFormat, postData PostData) Preview {
+	preview := Preview{MaxLen: mf.MaxLen}
+	text, err := mf.Format(postData)
+	if err != nil {
+		// show what will actually be sent, and why
+		preview.Error = err.Error()
+		text = mf.formatOrDefault(postData)
+	}
+	preview.Text = text
+	preview.Length = mf.Length(text)
+	return preview
+}
+
+var (
+	linkRegex     = regexp.MustCompile(`https?://[^\s()<>]+`)
+	sentenceRegex = regexp.MustCompile(`[.!?…]+["'”’)\]]*\s|\n\s*\n`)
+)
+
+/*
+truncateText cuts s to fit in n, as measured by strLen (see cutText).
+Text cut mid-sentence ends with an ellipsis.
+*/
+func truncateText(s string, n int, strLen func(string) int) string {
+	head, midSentence := cutText(s, n, strLen)
+	if midSentence {
+		return head + "…"
+	}
+	return head
+}
+
+/*
+cutText finds the start of s that fits in n. It cuts after the last
+paragraph or sentence that fits, unless that loses more than half the
+room, then after the last word that fits, and only then in the middle
+of a word; those leave room for an ellipsis. The head it returns is
+always a prefix of s.
+*/
+func cutText(s string, n int, strLen func(string) int) (string, bool) {
+	if strLen(s) <= n {
+		return s, false
+	}
+	if n <= 0 {
+		return "", false
+	}
+
+	var sentences string
+	for _, loc := range sentenceRegex.FindAllStringIndex(s, -1) {
+		prefix := strings.TrimRightFunc(s[:loc[1]], unicode.IsSpace)
+		if strLen(prefix) > n {
+			break
+		}
+		sentences = prefix
+	}
+	if sentences != "" && strLen(sentences) >= n/2 {
+		return sentences, false
+	}
+
+	var words string
+	for i, r := range s {
+		if !unicode.IsSpace(r) {
+			continue
+		}
+		prefix := strings.TrimRightFunc(s[:i], unicode.IsSpace)
+		if strLen(prefix+"…") > n {
+			break
+		}
+		words = prefix
+	}
+	if words != "" {
+		return words, true
+	}
+	if sentences != "" {
+		return sentences, false
+	}
+
+	return truncate(s, n-1, strLen), true
+}
+
+// truncate cuts s to at most n, as measured by strLen, on a grapheme boundary
+func truncate(s string, n int, strLen func(string) int) string {
+	if n <= 0 {
+		return ""
+	}
+	var end int
+	for _, g := range graphemes(s) {
+		if strLen(s[:end+len(g)]) > n {
+			break
+		}
+		end += len(g)
+	}
+	return s[:end]
+}
+
+// graphemeLen counts user-perceived characters, which is how Bluesky measures posts
+func graphemeLen(s string) int {
+	return uniseg.GraphemeClusterCount(s)
+}
+
+// graphemes splits s into grapheme clusters (UAX #29)
+func graphemes(s string) []string {
+	var clusters []string
+	state := -1
+	for s != "" {
+		var cluster string
+		cluster, s, _, state = uniseg.FirstGraphemeClusterInString(s, state)
+		clusters = append(clusters, cluster)
+	}
+	return clusters
+}
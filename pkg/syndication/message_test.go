@@ -0,0 +1,122 @@
+package syndication
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageFormatDefault(t *testing.T) {
+	mf := MessageFormat{MaxLen: 500}
+
+	message, err := mf.Format(PostData{
+		Title:     "A Post",
+		Body:      "Some *text* about #go",
+		Tags:      []string{"go", "blogging"},
+		PermaLink: "https://example.com/a-post",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t,
+		"A Post\n\nSome text about #go\n\n(https://example.com/a-post)\n\n#blogging",
+		message)
+
+	message, err = mf.Format(PostData{Slug: "txt-1", Body: "Just a note"})
+	assert.Nil(t, err)
+	assert.Equal(t, "Just a note", message)
+}
+
+func TestMessageFormatTemplate(t *testing.T) {
+	mf := MessageFormat{
+		Template:   "{{.Body}}{{if .IsNote}} — {{.SiteName}} {{.ShortID}}{{end}} {{.Link}}",
+		LinkFormat: "→ %s",
+		MaxLen:     500,
+	}
+	message, err := mf.Format(PostData{
+		Slug:      "txt-1",
+		Body:      "A note",
+		SiteName:  "example",
+		PermaLink: "https://example.com/txt-1",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "A note — example txt-1 → https://example.com/txt-1", message)
+
+	// old style link formats are ignored
+	mf.LinkFormat = "%s/%s"
+	message, _ = mf.Format(PostData{Title: "T", PermaLink: "https://example.com/t"})
+	assert.Contains(t, message, "(https://example.com/t)")
+
+	mf.Template = "{{.Nope"
+	_, err = mf.Format(PostData{Body: "body"})
+	assert.NotNil(t, err)
+	assert.Equal(t, "body", mf.formatOrDefault(PostData{Body: "body"}))
+
+	preview := previewMessage(mf, PostData{Body: "body"})
+	assert.NotEqual(t, "", preview.Error)
+	assert.Equal(t, "body", preview.Text)
+}
+
+func TestMessageFormatLength(t *testing.T) {
+	mf := MessageFormat{MaxLen: 280, URLLen: 23}
+	link := "https://example.com/" + strings.Repeat("long/", 20)
+	assert.Equal(t, 4+23+3, mf.Length("see "+link+" ok"))
+
+	// the link counts as 23 however long it is, so the body keeps its room
+	body := strings.Repeat("word ", 50)
+	message, _ := mf.Format(PostData{Title: "T", Body: body, PermaLink: link})
+	assert.Contains(t, message, strings.TrimSpace(body))
+	assert.True(t, mf.Length(message) <= 280)
+	assert.True(t, graphemeLen(message) > 280)
+
+	mf.URLLen = 0
+	message, _ = mf.Format(PostData{Title: "T", Body: body, PermaLink: link})
+	assert.True(t, graphemeLen(message) <= 280)
+}
+
+func TestTruncateText(t *testing.T) {
+	text := "First sentence here. Second one is a bit longer! Third."
+
+	// cut after the last sentence that fits
+	assert.Equal(t,
+		"First sentence here. Second one is a bit longer!",
+		truncateText(text, 50, graphemeLen))
+
+	// too little of it left on a sentence boundary, so cut on a word
+	assert.Equal(t,
+		"First sentence here. Second one is a bit…",
+		truncateText(text, 45, graphemeLen))
+
+	assert.Equal(t, "Fir…", truncateText("Firstsentence", 4, graphemeLen))
+	assert.Equal(t, text, truncateText(text, 100, graphemeLen))
+	assert.Equal(t, "", truncateText(text, 0, graphemeLen))
+
+	paras := "One para\n\nAnother para that goes on"
+	assert.Equal(t, "One para", truncateText(paras, 12, graphemeLen))
+}
+
+func TestPreviewPost(t *testing.T) {
+	targets := []Target{
+		{Name: "toots", Type: "mastodon"},
+		{Name: "sky", Type: "bluesky"},
+		{Name: "webmention", Type: "webmention"},
+	}
+	previews := PreviewPost(targets, PostData{
+		Title:     "A Post",
+		Body:      "Some text",
+		Tags:      []string{"go"},
+		PermaLink: "https://example.com/a-post",
+	})
+	assert.Equal(t, 2, len(previews))
+
+	assert.Equal(t, "toots", previews[0].Target)
+	assert.Equal(t, "A Post (go)", previews[0].ContentWarning)
+	assert.Equal(t, 500, previews[0].MaxLen)
+	assert.Equal(t,
+		graphemeLen("A Post (go)")+graphemeLen("A Post\n\nSome text\n\n(")+23+1+
+			graphemeLen("\n\n#go"),
+		previews[0].Length)
+
+	assert.Equal(t, "sky", previews[1].Target)
+	assert.Equal(t, 300, previews[1].MaxLen)
+	assert.Equal(t, graphemeLen(previews[1].Text), previews[1].Length)
+}
@@ -0,0 +1,95 @@
+package syndication
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+/*
+Target is one configured syndication destination, e.g. a Mastodon
+account. Name identifies it (in the post form, in Micropub's
+syndicate-to and as the prefix of the front matter keys it writes,
+like `{name}_id`), Type picks the registered syndicator and Options
+holds that syndicator's settings.
+*/
+type Target struct {
+	Name    string                 `yaml:"name"`
+	Type    string                 `yaml:"type"`
+	Label   string                 `yaml:"label"`
+	Options map[string]interface{} `yaml:"options"`
+}
+
+/*
+Registration describes a type of syndicator. Options returns a pointer
+to an empty options struct, which a target's options are decoded into
+(keys match field names, case-insensitively, like the rest of the
+config); New builds the syndicator for a named target from it.
+*/
+type Registration struct {
+	Options func() interface{}
+	New     func(name string, options interface{}) Hook
+}
+
+var registry = struct {
+	sync.RWMutex
+	types map[string]Registration
+}{types: make(map[string]Registration)}
+
+// Register makes a syndicator type available to the config
+func Register(typeName string, reg Registration) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	if _, ok := registry.types[typeName]; ok {
+		panic(fmt.Sprintf("syndicator type %q registered twice", typeName))
+	}
+	registry.types[typeName] = reg
+}
+
+// Types lists the registered syndicator types
+func Types() []string {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	var types []string
+	for t := range registry.types {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+func lookup(typeName string) (Registration, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	reg, ok := registry.types[typeName]
+	return reg, ok
+}
+
+// TargetLabel is the name to show for target in forms
+func TargetLabel(target Target) string {
+	if target.Label != "" {
+		return target.Label
+	}
+	return target.Name
+}
+
+// NewHook builds the syndicator for target
+func NewHook(target Target) (Hook, error) {
+	reg, ok := lookup(target.Type)
+	if !ok {
+		return nil, fmt.Errorf(
+			"%s: unknown syndicator type %q", target.Name, target.Type)
+	}
+
+	options := reg.Options()
+	err := mapstructure.WeakDecode(target.Options, options)
+	if err != nil {
+		return nil, fmt.Errorf("%s: bad options: %v", target.Name, err)
+	}
+	return reg.New(target.Name, options), nil
+}
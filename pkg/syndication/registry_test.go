@@ -0,0 +1,68 @@
+package syndication
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testOpts struct {
+	Account string
+	Retries int
+}
+
+type testPoster struct {
+	name string
+	opts testOpts
+}
+
+func (tp *testPoster) HandlePost(ctx context.Context, postData PostData) Result {
+	return Result{ID: tp.opts.Account + ":" + postData.Slug}
+}
+
+func init() {
+	Register("test", Registration{
+		Options: func() interface{} { return &testOpts{} },
+		New: func(name string, options interface{}) Hook {
+			return &testPoster{name: name, opts: *options.(*testOpts)}
+		},
+	})
+}
+
+func TestNewHookDecodesOptions(t *testing.T) {
+	hook, err := NewHook(Target{
+		Name: "work",
+		Type: "test",
+		Options: map[string]interface{}{
+			"account": "me@work",
+			"retries": "3",
+		},
+	})
+	assert.Nil(t, err)
+	poster := hook.(*testPoster)
+	assert.Equal(t, "work", poster.name)
+	assert.Equal(t, "me@work", poster.opts.Account)
+	assert.Equal(t, 3, poster.opts.Retries)
+
+	_, err = NewHook(Target{Name: "nope", Type: "carrier-pigeon"})
+	assert.NotNil(t, err)
+
+	assert.Contains(t, Types(), "mastodon")
+	assert.Contains(t, Types(), "twitter")
+	assert.Contains(t, Types(), "webmention")
+}
+
+func TestSyndicateIncludedTargets(t *testing.T) {
+	targets := []Target{
+		{Name: "home", Type: "test", Options: map[string]interface{}{"account": "a"}},
+		{Name: "work", Type: "test", Options: map[string]interface{}{"account": "b"}},
+	}
+
+	results := Syndicate(
+		context.Background(), targets, map[string]bool{"work": true},
+		PostData{Slug: "hello"})
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, "work", results[0].Target)
+	assert.Equal(t, "b:hello", results[0].ID)
+}
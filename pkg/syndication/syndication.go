@@ -1,12 +1,10 @@
 package syndication
 
 import (
-	"fmt"
-	"html/template"
+	"context"
 	"sync"
+	"time"
 
-	"github.com/gomarkdown/markdown"
-	"github.com/gomarkdown/markdown/parser"
 	"github.com/sirupsen/logrus"
 )
 
@@ -16,81 +14,188 @@ func init() {
 	logger.SetLevel(logrus.DebugLevel)
 }
 
-type SyndicateConfig struct {
-	Twitter    TwitterOpts
-	Mastodon   MastodonOpts
-	WebMention WebmentionOpts
-}
-
-func Syndicate(config SyndicateConfig, includeSyndicators map[string]bool, postData PostData) map[string]string {
-
-	syndicators := MakeSyndicators(config)
-
-	var hooks = make([]Hook, 0)
-
-	for _, label := range []string{"twitter", "mastodon"} {
-		if _, ok := includeSyndicators[label]; ok {
-			if includeSyndicators[label] {
-				hooks = append(hooks, syndicators[label])
-			}
+// SyndicateTimeout bounds how long a single target may take
+var SyndicateTimeout = 60 * time.Second
+
+/*
+Syndicate sends postData to each of targets named in
+includeSyndicators, all at once, and returns a Result per target in
+the order of targets. A target that doesn't answer within
+SyndicateTimeout, or before ctx is done, is reported as unknown.
+*/
+func Syndicate(
+	ctx context.Context, targets []Target,
+	includeSyndicators map[string]bool, postData PostData) []Result {
+
+	var included []Target
+	for _, target := range targets {
+		if includeSyndicators[target.Name] {
+			included = append(included, target)
 		}
 	}
 
-	// always do webmentions
-	if syndicators["webmention"] != nil {
-		hooks = append(hooks, syndicators["webmention"])
-	}
-
-	// var resultQueue = make(chan map[string]string) // len(hooks)
+	// each worker writes only its own slot
+	results := make([]Result, len(included))
 
 	var wg sync.WaitGroup
-	var meta = make(map[string]string)
-	for _, hook := range hooks {
-		logger.Debugf("Adding worker for hook %v", hook)
+	for i, target := range included {
+		hook, err := NewHook(target)
+		if err != nil {
+			logger.Error(err)
+			results[i] = Result{Target: target.Name, Status: StatusFailed, Error: err.Error()}
+			continue
+		}
+
+		logger.Debugf("Adding worker for %s", target.Name)
 		wg.Add(1)
-		// go func(results chan map[string]string) {
-		go func(h Hook) {
+		go func(i int, name string, h Hook) {
 			defer wg.Done()
-			res := h.HandlePost(postData)
-			logger.Debugf("worker handlePost result: %v", res)
-			for k, v := range res {
-				meta[k] = v
-			}
-			logger.Debugf("meta after hook %v", meta)
-		}(hook)
+			results[i] = runHook(ctx, name, h, postData)
+			logger.Debugf("%s result: %v", name, results[i])
+		}(i, target.Name, hook)
 	}
-	// close(resultQueue)
 	logger.Debug("Waiting...")
 	wg.Wait()
 
-	// for i := 0; i < len(hooks); i++ {
-	// 	res := <-resultQueue
-	// 	logger.Debugf("result from channel: %v", res)
-	// 	for k, v := range res {
-	// 		meta[k] = v
-	// 	}
-	// }
-	// for res := range resultQueue {
-	// 	logger.Debugf("result from channel: %v", res)
-	// 	for k, v := range res {
-	// 		meta[k] = v
-	// 	}
-	// }
-	return meta
+	return results
 }
 
-// func worker(results chan map[string]string, hook Hook, postData PostData) {
-// 	res := hook.HandlePost(postData)
-// 	logger.Debugf("worker handlePost result: %v", res)
-// 	results <- res
-// }
+func runHook(ctx context.Context, name string, h Hook, postData PostData) Result {
+	return runWithTimeout(ctx, name, func(ctx context.Context) Result {
+		return h.HandlePost(ctx, postData)
+	})
+}
 
-func markDowner(args ...interface{}) template.HTML {
-	extensions := parser.CommonExtensions | parser.HeadingIDs
-	parser := parser.NewWithExtensions(extensions)
-	content := fmt.Sprintf("%s", args...)
-	s := markdown.ToHTML(
-		[]byte(content), parser, nil)
+func runWithTimeout(ctx context.Context, name string, f func(context.Context) Result) Result {
+	ctx, cancel := context.WithTimeout(ctx, SyndicateTimeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan Result, 1)
+	go func() {
+		done <- f(ctx)
+	}()
+
+	var result Result
+	select {
+	case result = <-done:
+	case <-ctx.Done():
+		result = Result{Status: StatusUnknown, Error: ctx.Err().Error()}
+	}
+
+	result.Target = name
+	result.Duration = time.Since(start)
+	if result.Status == "" {
+		result.Status = StatusPosted
+		if result.Error != "" {
+			result.Status = StatusFailed
+		}
+	}
+	return result
+}
 
-	return template.HTML(s)
+/*
+PreviewPost shows what each target would receive for a post, for the
+targets that can tell.
+*/
+func PreviewPost(targets []Target, postData PostData) []Preview {
+	var previews []Preview
+	for _, target := range targets {
+		hook, err := NewHook(target)
+		if err != nil {
+			logger.Error(err)
+			continue
+		}
+		previewer, ok := hook.(Previewer)
+		if !ok {
+			continue
+		}
+		preview := previewer.Preview(postData)
+		preview.Target = target.Name
+		previews = append(previews, preview)
+	}
+	return previews
+}
+
+// CanUpdate tells whether the syndicator for target can edit its posts
+func CanUpdate(target Target) bool {
+	hook, err := NewHook(target)
+	if err != nil {
+		return false
+	}
+	_, ok := hook.(Updater)
+	return ok
+}
+
+/*
+UpdateSyndicated edits the copies of a post already sent to targets;
+ids maps target names to the id of the copy there. Targets whose
+syndicator can't edit are left alone.
+*/
+func UpdateSyndicated(
+	ctx context.Context, targets []Target, ids map[string]string,
+	postData PostData) []Result {
+
+	return eachSyndicated(ctx, targets, ids, func(hook Hook, id string) func(context.Context) Result {
+		updater, ok := hook.(Updater)
+		if !ok {
+			return nil
+		}
+		return func(ctx context.Context) Result {
+			return updater.UpdatePost(ctx, id, postData)
+		}
+	})
+}
+
+/*
+DeleteSyndicated deletes the copies of a post sent to targets; ids
+maps target names to the id of the copy there, frontMatter is the
+post's. Targets whose syndicator can't delete are left alone.
+*/
+func DeleteSyndicated(
+	ctx context.Context, targets []Target, ids map[string]string,
+	frontMatter map[string]string) []Result {
+	return eachSyndicated(ctx, targets, ids, func(hook Hook, id string) func(context.Context) Result {
+		deleter, ok := hook.(Deleter)
+		if !ok {
+			return nil
+		}
+		return func(ctx context.Context) Result {
+			err := deleter.DeletePost(ctx, id, frontMatter)
+			if err != nil {
+				return failed(err)
+			}
+			return Result{Status: StatusDeleted}
+		}
+	})
+}
+
+/*
+eachSyndicated runs the action for each target with an id, one at a
+time; action returns nil for hooks that can't do it, which are left
+out of the results.
+*/
+func eachSyndicated(
+	ctx context.Context, targets []Target, ids map[string]string,
+	action func(Hook, string) func(context.Context) Result) []Result {
+
+	var results []Result
+	for _, target := range targets {
+		id := ids[target.Name]
+		if id == "" {
+			continue
+		}
+		hook, err := NewHook(target)
+		if err != nil {
+			logger.Error(err)
+			continue
+		}
+
+		run := action(hook, id)
+		if run == nil {
+			continue
+		}
+		results = append(results, runWithTimeout(ctx, target.Name, run))
+	}
+	return results
 }
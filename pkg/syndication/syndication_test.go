@@ -0,0 +1,95 @@
+package syndication
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type funcHook func(ctx context.Context, postData PostData) Result
+
+func (f funcHook) HandlePost(ctx context.Context, postData PostData) Result {
+	return f(ctx, postData)
+}
+
+func TestRunHookTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { SyndicateTimeout = timeout }(SyndicateTimeout)
+	SyndicateTimeout = 10 * time.Millisecond
+
+	slow := funcHook(func(ctx context.Context, postData PostData) Result {
+		time.Sleep(time.Second)
+		return Result{ID: "late"}
+	})
+	result := runHook(context.Background(), "slow", slow, PostData{})
+	assert.Equal(t, StatusUnknown, result.Status)
+	assert.Equal(t, "slow", result.Target)
+	assert.Contains(t, result.Error, "deadline")
+	assert.True(t, result.Duration < time.Second)
+
+	ok := funcHook(func(ctx context.Context, postData PostData) Result {
+		return Result{ID: "1", URL: "https://example.com/1"}
+	})
+	result = runHook(context.Background(), "ok", ok, PostData{})
+	assert.Equal(t, StatusPosted, result.Status)
+}
+
+// slowUpdater takes a second to edit anything
+type slowUpdater struct{}
+
+func (slowUpdater) HandlePost(ctx context.Context, postData PostData) Result {
+	return Result{}
+}
+
+func (slowUpdater) UpdatePost(ctx context.Context, id string, postData PostData) Result {
+	time.Sleep(time.Second)
+	return Result{Status: StatusUpdated, ID: id}
+}
+
+func TestUpdateSyndicatedTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { SyndicateTimeout = timeout }(SyndicateTimeout)
+	SyndicateTimeout = 10 * time.Millisecond
+
+	Register("test-slow", Registration{
+		Options: func() interface{} { return &struct{}{} },
+		New: func(name string, options interface{}) Hook {
+			return slowUpdater{}
+		},
+	})
+	targets := []Target{{Name: "slow", Type: "test-slow"}}
+
+	results := UpdateSyndicated(
+		context.Background(), targets, map[string]string{"slow": "1"}, PostData{})
+	if assert.Len(t, results, 1) {
+		assert.Equal(t, StatusUnknown, results[0].Status)
+		assert.Contains(t, results[0].Error, "deadline")
+	}
+
+	// it can't delete, so it's left out
+	results = DeleteSyndicated(
+		context.Background(), targets, map[string]string{"slow": "1"}, nil)
+	assert.Len(t, results, 0)
+}
+
+func TestResultsMeta(t *testing.T) {
+	meta := ResultsMeta([]Result{
+		{Target: "mastodon", Status: StatusPosted, ID: "1", URL: "https://m.example/1"},
+		failedResult("bluesky", errors.New("down")),
+		{Target: "webmention", Status: StatusPosted},
+	})
+	assert.Equal(t, map[string]string{
+		"mastodon_status": "posted",
+		"mastodon_id":     "1",
+		"mastodon_url":    "https://m.example/1",
+		"bluesky_status":  "failed",
+		"bluesky_error":   "down",
+	}, meta)
+}
+
+func failedResult(target string, err error) Result {
+	r := failed(err)
+	r.Target = target
+	return r
+}
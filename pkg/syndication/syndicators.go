@@ -1,43 +1,32 @@
 package syndication
 
 import (
-	"fmt"
-
-	"github.com/gomarkdown/markdown"
-	"github.com/gomarkdown/markdown/parser"
-	"github.com/microcosm-cc/bluemonday"
+	"context"
 )
 
 type Hook interface {
-	HandlePost(postData PostData) map[string]string
+	HandlePost(ctx context.Context, postData PostData) Result
 }
 
-type Syndicator interface {
-	Hook
-	LinkForID(id string) string
+// Updater is a Hook that can edit the copy of a post it sent
+type Updater interface {
+	UpdatePost(ctx context.Context, id string, postData PostData) Result
 }
 
-func MakeSyndicators(config SyndicateConfig) map[string]Hook {
-	var posters = make(map[string]Hook)
-
-	posters["twitter"] = NewTwitterPoster(config.Twitter)
-
-	posters["mastodon"] = NewMastodonPoster(config.Mastodon)
-
-	posters["webmention"] = NewWebMentionPoster(config.WebMention)
-
-	return posters
+/*
+Deleter is a Hook that can delete the copy of a post it sent; it gets
+the post's front matter for anything else it recorded when posting.
+*/
+type Deleter interface {
+	DeletePost(ctx context.Context, id string, frontMatter map[string]string) error
 }
 
-func stripHTML(args ...interface{}) string {
-	content := fmt.Sprintf("%s", args...)
-	extensions := parser.CommonExtensions
-	parser := parser.NewWithExtensions(extensions)
-
-	s := markdown.ToHTML([]byte(content), parser, nil)
-
-	p := bluemonday.StrictPolicy()
-	plaintext := p.Sanitize(string(s))
+// Previewer is a Hook that can show the message it would send
+type Previewer interface {
+	Preview(postData PostData) Preview
+}
 
-	return plaintext
+type Syndicator interface {
+	Hook
+	LinkForID(id string) string
 }
@@ -3,13 +3,21 @@ package syndication
 import (
 	"testing"
 
+	"github.com/sivy/goldfrog/pkg/render"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestStripHTML(t *testing.T) {
 	content := "<p>foo</p>"
 
-	content = stripHTML(content)
+	content = render.ToText(content)
 
 	assert.NotContains(t, content, "<p>")
 }
+
+func TestMentionLinks(t *testing.T) {
+	links := mentionLinks(PostData{
+		FrontMatter: map[string]string{"in-reply-to": "https://a.example/post"},
+	}, []string{"https://b.example/", "https://a.example/post"})
+	assert.Equal(t, []string{"https://a.example/post", "https://b.example/"}, links)
+}
@@ -1,18 +1,48 @@
 package syndication
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 type PostData struct {
-	Title        string
-	Slug         string
-	PostDate     time.Time
-	Tags         []string
-	Body         string
-	FrontMatter  map[string]string
-	PermaLink    string
-	ShortID      string
-	MediaContent []byte
-	MediaType    string
+	Title       string
+	Slug        string
+	PostDate    time.Time
+	Tags        []string
+	Body        string
+	FrontMatter map[string]string
+	PermaLink   string
+	ShortID     string
+	SiteName    string
+	Media       []Media
+}
+
+// Media is an image to attach to a crosspost
+type Media struct {
+	Content []byte
+	// Type is the content type, like image/png
+	Type    string
+	Alt     string
+	Caption string
+}
+
+// description is the text for readers who can't see the image
+func (m Media) description() string {
+	if m.Alt != "" {
+		return m.Alt
+	}
+	return m.Caption
+}
+
+// maxMedia is how many images a crosspost can have
+const maxMedia int = 4
+
+func firstMedia(media []Media) []Media {
+	if len(media) > maxMedia {
+		return media[:maxMedia]
+	}
+	return media
 }
 
 type TwitterOpts struct {
@@ -22,6 +52,8 @@ type TwitterOpts struct {
 	AccessSecret string `yaml:"accessecret"`
 	UserID       string `yaml:"userid"`
 	LinkFormat   string `yaml:"linkformat"`
+	// Template is a text/template for the tweet, see MessageData
+	Template string `yaml:"template"`
 }
 
 type MastodonOpts struct {
@@ -30,7 +62,84 @@ type MastodonOpts struct {
 	ClientSecret string `yaml:"clientsecret"`
 	AccessToken  string `yaml:"accesstoken"`
 	LinkFormat   string `yaml:"linkformat"`
+	// Template is a text/template for the status, see MessageData
+	Template string `yaml:"template"`
+
+	// defaults for the mastodon_* front matter of posts
+	Visibility     string `yaml:"visibility"`
+	ContentWarning string `yaml:"contentwarning"`
+	Language       string `yaml:"language"`
+	Thread         bool   `yaml:"thread"`
 }
 
 type WebmentionOpts struct {
 }
+
+const (
+	StatusPosted string = "posted"
+	StatusFailed string = "failed"
+	// the target didn't answer in time: the post may have gone out
+	// anyway, so it isn't sent again without the author checking
+	StatusUnknown string = "unknown"
+	// the copy elsewhere was edited, or deleted, along with the post
+	StatusUpdated string = "updated"
+	StatusDeleted string = "deleted"
+)
+
+/*
+Result is what became of sending a post to one target. Hooks fill in
+ID, URL, Meta (extra front matter entries) and Error; Syndicate fills
+in the rest.
+*/
+type Result struct {
+	Target   string
+	Status   string
+	ID       string
+	URL      string
+	Meta     map[string]string
+	Error    string
+	Duration time.Duration
+}
+
+func (r Result) String() string {
+	if r.Status == StatusFailed || r.Status == StatusUnknown {
+		return fmt.Sprintf("%s: %s (%s)", r.Target, r.Status, r.Error)
+	}
+	return fmt.Sprintf("%s: %s %s", r.Target, r.Status, r.URL)
+}
+
+// failed makes the Result for a hook that could not post
+func failed(err error) Result {
+	logger.Error(err)
+	return Result{Status: StatusFailed, Error: err.Error()}
+}
+
+/*
+ResultsMeta turns results into front matter entries, keyed by target
+name: `{name}_id`, `{name}_url`, `{name}_status`, `{name}_error` and
+any extra entries the hook returned. Results with nothing to record
+(like webmentions) are left out.
+*/
+func ResultsMeta(results []Result) map[string]string {
+	meta := make(map[string]string)
+
+	for _, r := range results {
+		if r.ID == "" && r.URL == "" && r.Error == "" && len(r.Meta) == 0 {
+			continue
+		}
+		meta[r.Target+"_status"] = r.Status
+		if r.Error != "" {
+			meta[r.Target+"_error"] = r.Error
+		}
+		if r.ID != "" {
+			meta[r.Target+"_id"] = r.ID
+		}
+		if r.URL != "" {
+			meta[r.Target+"_url"] = r.URL
+		}
+		for k, v := range r.Meta {
+			meta[r.Target+"_"+k] = v
+		}
+	}
+	return meta
+}
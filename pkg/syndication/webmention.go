@@ -1,27 +1,67 @@
 package syndication
 
 import (
+	"context"
+	"strings"
+
+	"github.com/sivy/goldfrog/pkg/render"
 	"github.com/sivy/goldfrog/pkg/webmention"
 )
 
+func init() {
+	Register("webmention", Registration{
+		Options: func() interface{} { return &WebmentionOpts{} },
+		New: func(name string, options interface{}) Hook {
+			return NewWebMentionPoster(*options.(*WebmentionOpts))
+		},
+	})
+}
+
 type WebMentionPoster struct {
 }
 
-func (wp *WebMentionPoster) HandlePost(postData PostData) map[string]string {
+func (wp *WebMentionPoster) HandlePost(ctx context.Context, postData PostData) Result {
 	logger.Infof("Handling WebMentions...")
 	client := webmention.NewWebMentionClient()
-	htmlText := string(markDowner(postData.Body))
+	htmlText := string(render.ToHTML(postData.Body))
 
 	sourceLink := postData.PermaLink
 	links, err := client.FindLinks(htmlText)
 	if err != nil {
 		logger.Errorf("Could not get post links: %s", err)
 	}
+	links = mentionLinks(postData, links)
 	logger.Debugf("Found links: %v", links)
 	logger.Info("Sending WebMentions...")
 	client.SendWebMentions(sourceLink, links)
 
-	return make(map[string]string)
+	return Result{}
+}
+
+/*
+responseProperties are the front matter keys naming the page a post
+replies to, likes, reposts or bookmarks, which are mentioned too.
+*/
+var responseProperties = []string{"in-reply-to", "repost-of", "like-of", "bookmark-of"}
+
+// mentionLinks adds the pages the post responds to to its links, once each
+func mentionLinks(postData PostData, links []string) []string {
+	var targets []string
+	for _, property := range responseProperties {
+		if target := strings.TrimSpace(postData.FrontMatter[property]); target != "" {
+			targets = append(targets, target)
+		}
+	}
+
+	seen := make(map[string]bool)
+	var mentions []string
+	for _, link := range append(targets, links...) {
+		if !seen[link] {
+			seen[link] = true
+			mentions = append(mentions, link)
+		}
+	}
+	return mentions
 }
 
 func NewWebMentionPoster(WebmentionOpts) *WebMentionPoster {
@@ -0,0 +1,373 @@
+package webmention
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/araddon/dateparse"
+)
+
+const (
+	contextSummaryLen     int           = 280
+	defaultContextMaxAge  time.Duration = 7 * 24 * time.Hour
+	defaultContextRetryIn time.Duration = time.Hour
+)
+
+/*
+Context is what a reply (or like, repost...) shows of the page it
+responds to. It's read from the page's h-entry, or from its OpenGraph
+and Twitter card metadata when it doesn't have one.
+*/
+type Context struct {
+	URL         string
+	Name        string
+	Summary     string
+	AuthorName  string
+	AuthorURL   string
+	AuthorPhoto string
+	SiteName    string
+	Image       string
+	Published   time.Time
+
+	// Fetched is when the page was last fetched, with Error set if
+	// that didn't work
+	Fetched time.Time
+	Error   string
+}
+
+// FetchContext fetches the page at target and reads its Context
+func (c *Client) FetchContext(target string) (*Context, error) {
+	resp, err := c.Fetch(target)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	doc, err := goquery.NewDocumentFromReader(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	context := ParseContext(doc, target)
+	return &context, nil
+}
+
+// ParseContext reads the Context of the page target from its html
+func ParseContext(doc *goquery.Document, target string) Context {
+	context := Context{URL: target}
+	base, _ := url.Parse(target)
+	resolve := func(ref string) string {
+		if ref == "" || base == nil {
+			return ref
+		}
+		u, err := base.Parse(ref)
+		if err != nil {
+			return ref
+		}
+		return u.String()
+	}
+
+	entry := doc.Find(".h-entry").First()
+	if entry.Length() > 0 {
+		name := text(property(entry, "p-name"))
+		content := text(property(entry, "e-content"))
+		if content == "" {
+			content = text(property(entry, "p-summary"))
+		}
+		// notes have no name of their own, it's implied from the content
+		if name != "" && !strings.HasPrefix(content, name) {
+			context.Name = name
+		}
+		context.Summary = excerpt(content, contextSummaryLen)
+		context.Published = parseTime(property(entry, "dt-published"))
+
+		author := entry.Find(".p-author").First()
+		if author.Length() > 0 {
+			if author.HasClass("h-card") {
+				context.AuthorName = text(property(author, "p-name"))
+				context.AuthorURL = resolve(attr(property(author, "u-url"), "href"))
+				context.AuthorPhoto = resolve(attr(property(author, "u-photo"), "src"))
+			}
+			if context.AuthorName == "" {
+				context.AuthorName = text(author)
+			}
+			if context.AuthorURL == "" {
+				context.AuthorURL = resolve(attr(author, "href"))
+			}
+		}
+	}
+
+	meta := func(names ...string) string {
+		for _, name := range names {
+			selector := `meta[property="` + name + `"],meta[name="` + name + `"]`
+			if content := strings.TrimSpace(doc.Find(selector).First().AttrOr("content", "")); content != "" {
+				return content
+			}
+		}
+		return ""
+	}
+	if context.Name == "" && context.Summary == "" {
+		context.Name = meta("og:title", "twitter:title")
+		if context.Name == "" {
+			context.Name = text(doc.Find("title").First())
+		}
+		context.Summary = excerpt(
+			meta("og:description", "twitter:description", "description"),
+			contextSummaryLen)
+	}
+	if context.AuthorName == "" {
+		context.AuthorName = meta("article:author", "author", "twitter:creator")
+	}
+	if context.Published.IsZero() {
+		if published, err := dateparse.ParseAny(meta("article:published_time")); err == nil {
+			context.Published = published
+		}
+	}
+	context.SiteName = meta("og:site_name")
+	context.Image = resolve(meta("og:image", "twitter:image"))
+	if context.Image == "" && entry.Length() > 0 {
+		context.Image = resolve(attr(property(entry, "u-photo"), "src"))
+	}
+
+	return context
+}
+
+/*
+property finds the first element in root with the class, skipping the
+ones that belong to microformats nested in root.
+*/
+func property(root *goquery.Selection, class string) *goquery.Selection {
+	var found *goquery.Selection
+	root.Find("." + class).EachWithBreak(func(i int, s *goquery.Selection) bool {
+		nested := false
+		s.ParentsUntilSelection(root).Each(func(i int, parent *goquery.Selection) {
+			for _, c := range strings.Fields(parent.AttrOr("class", "")) {
+				if strings.HasPrefix(c, "h-") {
+					nested = true
+				}
+			}
+		})
+		if !nested {
+			found = s
+		}
+		return nested
+	})
+	if found == nil {
+		return root.Slice(0, 0)
+	}
+	return found
+}
+
+func text(s *goquery.Selection) string {
+	return strings.Join(strings.Fields(s.Text()), " ")
+}
+
+func attr(s *goquery.Selection, name string) string {
+	return strings.TrimSpace(s.AttrOr(name, ""))
+}
+
+// parseTime reads a dt- property from its datetime attribute or text
+func parseTime(s *goquery.Selection) time.Time {
+	value := attr(s, "datetime")
+	if value == "" {
+		value = text(s)
+	}
+	t, err := dateparse.ParseAny(value)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// excerpt cuts s to at most n characters, on a word if it can
+func excerpt(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	runes := []rune(s)[:n-1]
+	cut := string(runes)
+	if i := strings.LastIndex(cut, " "); i > len(cut)/2 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, " ,.;:") + "…"
+}
+
+/*
+ContextCache keeps the contexts of the pages posts respond to (or link
+to) in the blog db, so pages can show them without waiting on the
+network. A context is fetched again once it's older than MaxAge, or
+RetryIn after fetching it failed.
+*/
+type ContextCache struct {
+	MaxAge  time.Duration
+	RetryIn time.Duration
+
+	db     *sql.DB
+	table  string
+	client Client
+
+	mu       sync.Mutex
+	fetching map[string]bool
+}
+
+// NewContextCache keeps reply contexts in the reply_contexts table
+func NewContextCache(db *sql.DB) (*ContextCache, error) {
+	return newContextCache(db, "reply_contexts")
+}
+
+// NewPreviewCache keeps link previews in the link_previews table
+func NewPreviewCache(db *sql.DB) (*ContextCache, error) {
+	return newContextCache(db, "link_previews")
+}
+
+func newContextCache(db *sql.DB, table string) (*ContextCache, error) {
+	cache := &ContextCache{
+		MaxAge:   defaultContextMaxAge,
+		RetryIn:  defaultContextRetryIn,
+		db:       db,
+		table:    table,
+		client:   NewWebMentionClient(),
+		fetching: make(map[string]bool),
+	}
+	_, err := db.Exec(fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %s (
+		url varchar(1024) primary key,
+		name text default "",
+		summary text default "",
+		author_name varchar(256) default "",
+		author_url varchar(1024) default "",
+		author_photo varchar(1024) default "",
+		site_name varchar(256) default "",
+		image varchar(1024) default "",
+		published varchar(25) default "",
+		fetched varchar(25),
+		error text default "");
+	`, table))
+	if err != nil {
+		logger.Errorf("Could not create %s table: %v", table, err)
+	}
+	return cache, err
+}
+
+// Get returns the cached context of target, without fetching it
+func (cache *ContextCache) Get(target string) (*Context, bool) {
+	var c Context
+	var published, fetched string
+	err := cache.db.QueryRow(fmt.Sprintf(`
+		SELECT url, name, summary, author_name, author_url, author_photo,
+			site_name, image, published, fetched, error
+		FROM %s WHERE url = ?`, cache.table), target).Scan(
+		&c.URL, &c.Name, &c.Summary, &c.AuthorName, &c.AuthorURL,
+		&c.AuthorPhoto, &c.SiteName, &c.Image, &published, &fetched, &c.Error)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			logger.Errorf("Could not load context of %s: %v", target, err)
+		}
+		return nil, false
+	}
+	c.Published, _ = time.Parse(time.RFC3339, published)
+	c.Fetched, _ = time.Parse(time.RFC3339, fetched)
+	return &c, true
+}
+
+// Stale tells whether the context should be fetched again
+func (cache *ContextCache) Stale(c *Context) bool {
+	if c == nil {
+		return true
+	}
+	age := time.Since(c.Fetched)
+	if c.Error != "" {
+		return age > cache.RetryIn
+	}
+	return age > cache.MaxAge
+}
+
+/*
+Refresh fetches the context of target and stores it. If that fails, a
+context fetched before is kept, with the error.
+*/
+func (cache *ContextCache) Refresh(target string) (*Context, error) {
+	context, err := cache.client.FetchContext(target)
+	if err != nil {
+		logger.Warnf("Could not fetch context of %s: %v", target, err)
+		old, ok := cache.Get(target)
+		if !ok {
+			old = &Context{URL: target}
+		}
+		old.Fetched = time.Now()
+		old.Error = err.Error()
+		cache.store(old)
+		return old, err
+	}
+	context.Fetched = time.Now()
+	return context, cache.store(context)
+}
+
+// Lookup returns the context of target, fetching it if it's stale
+func (cache *ContextCache) Lookup(target string) (*Context, error) {
+	context, _ := cache.Get(target)
+	if !cache.Stale(context) {
+		return context, nil
+	}
+	return cache.Refresh(target)
+}
+
+/*
+RefreshLater fetches target in the background if its context is stale,
+once at a time.
+*/
+func (cache *ContextCache) RefreshLater(target string) {
+	if context, _ := cache.Get(target); !cache.Stale(context) {
+		return
+	}
+	cache.mu.Lock()
+	if cache.fetching[target] {
+		cache.mu.Unlock()
+		return
+	}
+	cache.fetching[target] = true
+	cache.mu.Unlock()
+
+	go func() {
+		cache.Refresh(target)
+		cache.mu.Lock()
+		delete(cache.fetching, target)
+		cache.mu.Unlock()
+	}()
+}
+
+func (cache *ContextCache) store(c *Context) error {
+	var published string
+	if !c.Published.IsZero() {
+		published = c.Published.UTC().Format(time.RFC3339)
+	}
+	_, err := cache.db.Exec(fmt.Sprintf(`
+		INSERT INTO %s (
+			url, name, summary, author_name, author_url, author_photo,
+			site_name, image, published, fetched, error
+		) VALUES (
+			?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
+		) ON CONFLICT(url) DO UPDATE
+		SET
+			name=excluded.name,
+			summary=excluded.summary,
+			author_name=excluded.author_name,
+			author_url=excluded.author_url,
+			author_photo=excluded.author_photo,
+			site_name=excluded.site_name,
+			image=excluded.image,
+			published=excluded.published,
+			fetched=excluded.fetched,
+			error=excluded.error
+	`, cache.table), c.URL, c.Name, c.Summary, c.AuthorName, c.AuthorURL, c.AuthorPhoto,
+		c.SiteName, c.Image, published, c.Fetched.UTC().Format(time.RFC3339), c.Error)
+	if err != nil {
+		logger.Errorf("Could not store context of %s: %v", c.URL, err)
+	}
+	return err
+}
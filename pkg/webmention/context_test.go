@@ -0,0 +1,121 @@
+package webmention
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+)
+
+const hEntryPage = `
+<html><head><title>Page title</title></head><body>
+<article class="h-entry">
+  <h1 class="p-name">On Frogs</h1>
+  <a class="p-author h-card" href="/about">
+    <img class="u-photo" src="/me.jpg"> <span class="p-name">Ann Example</span>
+  </a>
+  <time class="dt-published" datetime="2020-03-04T05:06:07Z">March 4</time>
+  <div class="e-content"><p>Frogs are   <b>great</b>.</p>
+    <div class="h-cite"><span class="p-name">Quoted</span></div></div>
+</article>
+</body></html>`
+
+func parse(t *testing.T, page string, target string) Context {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(page))
+	assert.Nil(t, err)
+	return ParseContext(doc, target)
+}
+
+func TestParseContext(t *testing.T) {
+	c := parse(t, hEntryPage, "https://ann.example/frogs")
+	assert.Equal(t, "On Frogs", c.Name)
+	assert.Equal(t, "Frogs are great. Quoted", c.Summary)
+	assert.Equal(t, "Ann Example", c.AuthorName)
+	assert.Equal(t, "https://ann.example/about", c.AuthorURL)
+	assert.Equal(t, "https://ann.example/me.jpg", c.AuthorPhoto)
+	assert.Equal(t, 2020, c.Published.Year())
+
+	// a note's name is its content
+	c = parse(t, `<div class="h-entry"><p class="p-name e-content">Just a note</p>
+		<span class="p-author">Bob</span></div>`, "https://bob.example/1")
+	assert.Equal(t, "", c.Name)
+	assert.Equal(t, "Just a note", c.Summary)
+	assert.Equal(t, "Bob", c.AuthorName)
+}
+
+func TestParseContextMetadata(t *testing.T) {
+	c := parse(t, `<html><head>
+		<meta property="og:title" content="A Page">
+		<meta property="og:description" content="`+strings.Repeat("words ", 100)+`">
+		<meta property="og:site_name" content="Example">
+		<meta property="og:image" content="/card.png">
+		<meta name="author" content="Cat">
+		<meta property="article:published_time" content="2021-01-02T03:04:05Z">
+		</head></html>`, "https://example.com/page")
+	assert.Equal(t, "A Page", c.Name)
+	assert.True(t, strings.HasSuffix(c.Summary, "words…"))
+	assert.True(t, len([]rune(c.Summary)) <= contextSummaryLen)
+	assert.Equal(t, "Cat", c.AuthorName)
+	assert.Equal(t, "Example", c.SiteName)
+	assert.Equal(t, "https://example.com/card.png", c.Image)
+	assert.Equal(t, 2021, c.Published.Year())
+
+	c = parse(t, `<html><head><title> Only a title </title></head></html>`, "https://example.com/")
+	assert.Equal(t, "Only a title", c.Name)
+}
+
+func TestContextCache(t *testing.T) {
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		if r.URL.Path == "/gone" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(hEntryPage))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "goldfrog-contexts")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "test.db"))
+	assert.Nil(t, err)
+
+	cache, err := NewContextCache(db)
+	assert.Nil(t, err)
+
+	target := server.URL + "/frogs"
+	_, ok := cache.Get(target)
+	assert.False(t, ok)
+
+	c, err := cache.Lookup(target)
+	assert.Nil(t, err)
+	assert.Equal(t, "On Frogs", c.Name)
+	c, err = cache.Lookup(target)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, fetches)
+	assert.Equal(t, 2020, c.Published.Year())
+
+	// fetched again once stale
+	cache.MaxAge = 0
+	cache.Lookup(target)
+	assert.Equal(t, 2, fetches)
+
+	c, err = cache.Lookup(server.URL + "/gone")
+	assert.NotNil(t, err)
+	assert.NotEqual(t, "", c.Error)
+	// failures wait RetryIn before trying again
+	cache.Lookup(server.URL + "/gone")
+	assert.Equal(t, 3, fetches)
+	assert.False(t, cache.Stale(&Context{Error: "failed", Fetched: time.Now()}))
+}
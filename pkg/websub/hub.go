@@ -0,0 +1,409 @@
+package websub
+
+import (
+	"bytes"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultLeaseSeconds int = 10 * 24 * 60 * 60
+	maxLeaseSeconds     int = 30 * 24 * 60 * 60
+	maxSecretLen        int = 200
+)
+
+/*
+Hub is a minimal WebSub hub. Subscribers register a callback for a
+topic (one of the blog's feeds or pages), which the hub verifies by
+echoing a challenge. When the blog pings the hub with a publish
+request, the hub fetches the topic and POSTs it to every subscriber,
+signed with the subscriber's secret if one was given. The blog itself
+publishes by calling Publish; publish requests over HTTP are only
+taken from whoever CanPublish lets through.
+
+Subscriptions are kept in the blog db.
+*/
+type Hub struct {
+	// URL is where the hub is reachable, advertised with rel="hub"
+	URL string
+	// TopicPrefix limits the topics the hub accepts, usually the blog url
+	TopicPrefix  string
+	LeaseSeconds int
+	// CanPublish authorizes publish requests; without it they're refused
+	CanPublish func(r *http.Request) bool
+
+	db     *sql.DB
+	client *http.Client
+	// verifications and distributions run in the background;
+	// tests swap this out to run them inline
+	async func(func())
+}
+
+type Subscription struct {
+	Callback     string
+	Topic        string
+	Secret       string
+	LeaseExpires time.Time
+}
+
+func NewHub(db *sql.DB, hubURL string, topicPrefix string) (*Hub, error) {
+	hub := &Hub{
+		URL:          hubURL,
+		TopicPrefix:  topicPrefix,
+		LeaseSeconds: defaultLeaseSeconds,
+		db:           db,
+		client:       httpClient,
+		async:        func(f func()) { go f() },
+	}
+	err := hub.initDb()
+	return hub, err
+}
+
+func (hub *Hub) initDb() error {
+	_, err := hub.db.Exec(`
+	CREATE TABLE IF NOT EXISTS websub_subscriptions (
+		id integer primary key,
+		callback varchar(1024),
+		topic varchar(1024),
+		secret varchar(256) default "",
+		lease_expires varchar(25),
+		created varchar(25),
+		unique(callback, topic));
+	`)
+	if err != nil {
+		logger.Errorf("Could not create subscriptions table: %v", err)
+	}
+	return err
+}
+
+// ServeHTTP handles subscribe, unsubscribe and publish requests
+func (hub *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "WebSub hub, POST only", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.ParseForm()
+	mode := r.PostFormValue("hub.mode")
+
+	switch mode {
+	case "subscribe", "unsubscribe":
+		hub.handleSubscription(w, r, mode)
+	case "publish":
+		hub.handlePublish(w, r)
+	default:
+		http.Error(w, fmt.Sprintf("Unknown hub.mode: %q", mode), http.StatusBadRequest)
+	}
+}
+
+func (hub *Hub) handleSubscription(w http.ResponseWriter, r *http.Request, mode string) {
+	callback := r.PostFormValue("hub.callback")
+	topic := r.PostFormValue("hub.topic")
+	secret := r.PostFormValue("hub.secret")
+
+	if !isHTTPURL(callback) {
+		http.Error(w, "hub.callback must be an http(s) url", http.StatusBadRequest)
+		return
+	}
+	if !hub.acceptsTopic(topic) {
+		http.Error(w, "hub.topic is not published through this hub", http.StatusBadRequest)
+		return
+	}
+	if len(secret) >= maxSecretLen {
+		http.Error(w, "hub.secret is too long", http.StatusBadRequest)
+		return
+	}
+
+	lease := hub.LeaseSeconds
+	if leaseStr := r.PostFormValue("hub.lease_seconds"); leaseStr != "" {
+		if requested, err := strconv.Atoi(leaseStr); err == nil && requested > 0 {
+			lease = requested
+		}
+	}
+	if lease > maxLeaseSeconds {
+		lease = maxLeaseSeconds
+	}
+
+	sub := Subscription{
+		Callback:     callback,
+		Topic:        topic,
+		Secret:       secret,
+		LeaseExpires: time.Now().Add(time.Duration(lease) * time.Second),
+	}
+
+	logger.Infof("%s request for %s from %s", mode, topic, callback)
+	w.WriteHeader(http.StatusAccepted)
+
+	hub.async(func() {
+		err := hub.verifyIntent(mode, sub, lease)
+		if err != nil {
+			logger.Warnf("Could not verify %s of %s: %v", mode, callback, err)
+			return
+		}
+		if mode == "subscribe" {
+			err = hub.saveSubscription(sub)
+		} else {
+			err = hub.deleteSubscription(sub.Callback, sub.Topic)
+		}
+		if err != nil {
+			logger.Error(err)
+		}
+	})
+}
+
+// verifyIntent asks the subscriber to confirm the (un)subscription
+func (hub *Hub) verifyIntent(mode string, sub Subscription, lease int) error {
+	challenge, err := randomString(16)
+	if err != nil {
+		return err
+	}
+
+	verifyURL, err := url.Parse(sub.Callback)
+	if err != nil {
+		return err
+	}
+	q := verifyURL.Query()
+	q.Set("hub.mode", mode)
+	q.Set("hub.topic", sub.Topic)
+	q.Set("hub.challenge", challenge)
+	if mode == "subscribe" {
+		q.Set("hub.lease_seconds", strconv.Itoa(lease))
+	}
+	verifyURL.RawQuery = q.Encode()
+
+	resp, err := hub.client.Get(verifyURL.String())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("callback returned %d", resp.StatusCode)
+	}
+	if strings.TrimSpace(string(body)) != challenge {
+		return errors.New("callback did not echo the challenge")
+	}
+	return nil
+}
+
+func (hub *Hub) handlePublish(w http.ResponseWriter, r *http.Request) {
+	if hub.CanPublish == nil || !hub.CanPublish(r) {
+		http.Error(w, "Not allowed to publish", http.StatusForbidden)
+		return
+	}
+
+	topics := r.PostForm["hub.url"]
+	topics = append(topics, r.PostForm["hub.topic"]...)
+
+	if len(topics) == 0 {
+		http.Error(w, "hub.url is required", http.StatusBadRequest)
+		return
+	}
+	err := hub.Publish(topics...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+/*
+Publish distributes topics to their subscribers in the background,
+once it has checked they're all published through this hub.
+*/
+func (hub *Hub) Publish(topics ...string) error {
+	for _, topic := range topics {
+		if !hub.acceptsTopic(topic) {
+			return fmt.Errorf("%s is not published through this hub", topic)
+		}
+	}
+
+	hub.async(func() {
+		for _, topic := range topics {
+			err := hub.Distribute(topic)
+			if err != nil {
+				logger.Errorf("Could not distribute %s: %v", topic, err)
+			}
+		}
+	})
+	return nil
+}
+
+/*
+Distribute fetches the current content of topic and delivers it to
+every subscriber with an active lease.
+*/
+func (hub *Hub) Distribute(topic string) error {
+	subs, err := hub.Subscriptions(topic)
+	if err != nil {
+		return err
+	}
+	if len(subs) == 0 {
+		logger.Debugf("No subscribers for %s", topic)
+		return nil
+	}
+
+	resp, err := hub.client.Get(topic)
+	if err != nil {
+		return err
+	}
+	content, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("topic returned %d", resp.StatusCode)
+	}
+	contentType := resp.Header.Get("Content-Type")
+
+	for _, sub := range subs {
+		err := hub.deliver(sub, content, contentType)
+		if err != nil {
+			logger.Warnf("Could not deliver %s to %s: %v", topic, sub.Callback, err)
+		}
+	}
+	return nil
+}
+
+func (hub *Hub) deliver(sub Subscription, content []byte, contentType string) error {
+	req, err := http.NewRequest("POST", sub.Callback, bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Add("Link", fmt.Sprintf(`<%s>; rel="hub"`, hub.URL))
+	req.Header.Add("Link", fmt.Sprintf(`<%s>; rel="self"`, sub.Topic))
+	if sub.Secret != "" {
+		req.Header.Set("X-Hub-Signature", Sign(sub.Secret, content))
+	}
+
+	resp, err := hub.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusGone {
+		// the subscriber is telling us to go away
+		logger.Infof("Callback %s is gone, removing subscription", sub.Callback)
+		return hub.deleteSubscription(sub.Callback, sub.Topic)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("callback returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Subscriptions lists the unexpired subscriptions to topic
+func (hub *Hub) Subscriptions(topic string) ([]Subscription, error) {
+	_, err := hub.db.Exec(`
+		DELETE FROM websub_subscriptions
+		WHERE datetime(lease_expires) < datetime(?)
+	`, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		logger.Errorf("Could not expire subscriptions: %v", err)
+	}
+
+	rows, err := hub.db.Query(`
+		SELECT callback, topic, secret, lease_expires
+		FROM websub_subscriptions
+		WHERE topic = ?
+	`, topic)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subs []Subscription
+	for rows.Next() {
+		var sub Subscription
+		var expires string
+		err := rows.Scan(&sub.Callback, &sub.Topic, &sub.Secret, &expires)
+		if err != nil {
+			logger.Error(err)
+			continue
+		}
+		sub.LeaseExpires, _ = time.Parse(time.RFC3339, expires)
+		subs = append(subs, sub)
+	}
+	return subs, rows.Err()
+}
+
+func (hub *Hub) saveSubscription(sub Subscription) error {
+	_, err := hub.db.Exec(`
+		INSERT INTO websub_subscriptions (
+			callback, topic, secret, lease_expires, created
+		) VALUES (
+			?, ?, ?, ?, ?
+		) ON CONFLICT(callback, topic) DO UPDATE
+		SET
+			secret=excluded.secret,
+			lease_expires=excluded.lease_expires
+	`, sub.Callback, sub.Topic, sub.Secret,
+		sub.LeaseExpires.UTC().Format(time.RFC3339),
+		time.Now().UTC().Format(time.RFC3339))
+	return err
+}
+
+func (hub *Hub) deleteSubscription(callback string, topic string) error {
+	_, err := hub.db.Exec(`
+		DELETE FROM websub_subscriptions
+		WHERE callback = ? AND topic = ?
+	`, callback, topic)
+	return err
+}
+
+/*
+acceptsTopic checks that topic is on the same scheme and host as
+TopicPrefix, and under its path.
+*/
+func (hub *Hub) acceptsTopic(topic string) bool {
+	if !isHTTPURL(topic) {
+		return false
+	}
+	if hub.TopicPrefix == "" {
+		return true
+	}
+	prefix, err := url.Parse(hub.TopicPrefix)
+	if err != nil {
+		return false
+	}
+	u, _ := url.Parse(topic)
+	if !strings.EqualFold(u.Scheme, prefix.Scheme) ||
+		!strings.EqualFold(u.Host, prefix.Host) {
+		return false
+	}
+	// a prefix of /blog covers /blog and /blog/..., not /blogroll
+	dir := strings.TrimRight(prefix.Path, "/")
+	return u.Path == dir || strings.HasPrefix(u.Path, dir+"/")
+}
+
+func isHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", b), nil
+}
@@ -0,0 +1,176 @@
+package websub
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testDb string = "../../tests/data/websub_test.db"
+)
+
+type delivery struct {
+	body      string
+	signature string
+}
+
+func TestHubSubscribeAndDistribute(t *testing.T) {
+	db, err := sql.Open("sqlite3", testDb)
+	assert.Nil(t, err)
+	defer os.Remove(testDb)
+
+	topicServer := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/rss+xml")
+			w.Write([]byte("<rss>new post</rss>"))
+		}))
+	defer topicServer.Close()
+	topic := topicServer.URL + "/feed.xml"
+
+	var deliveries []delivery
+	subscriber := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "GET" {
+				// verification of intent
+				assert.Equal(t, topic, r.URL.Query().Get("hub.topic"))
+				w.Write([]byte(r.URL.Query().Get("hub.challenge")))
+				return
+			}
+			body, _ := ioutil.ReadAll(r.Body)
+			deliveries = append(deliveries, delivery{
+				body:      string(body),
+				signature: r.Header.Get("X-Hub-Signature"),
+			})
+		}))
+	defer subscriber.Close()
+
+	hub, err := NewHub(db, "http://blog.example/websub", topicServer.URL)
+	assert.Nil(t, err)
+	hub.async = func(f func()) { f() }
+
+	hubServer := httptest.NewServer(hub)
+	defer hubServer.Close()
+
+	resp, err := http.PostForm(hubServer.URL, url.Values{
+		"hub.mode":     {"subscribe"},
+		"hub.callback": {subscriber.URL + "/callback"},
+		"hub.topic":    {topic},
+		"hub.secret":   {"s3cret"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	subs, err := hub.Subscriptions(topic)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(subs))
+
+	// strangers can't make the hub fetch and deliver
+	err = Publish(hubServer.URL, topic)
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, len(deliveries))
+
+	hub.CanPublish = func(r *http.Request) bool {
+		return r.Header.Get("Authorization") == "Bearer owner"
+	}
+	req, _ := http.NewRequest("POST", hubServer.URL, strings.NewReader(url.Values{
+		"hub.mode": {"publish"},
+		"hub.url":  {topic},
+	}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer owner")
+	resp, err = http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	assert.Equal(t, 1, len(deliveries))
+	assert.Equal(t, "<rss>new post</rss>", deliveries[0].body)
+	assert.True(t, VerifySignature(
+		"s3cret", deliveries[0].signature, []byte(deliveries[0].body)))
+
+	resp, err = http.PostForm(hubServer.URL, url.Values{
+		"hub.mode":     {"unsubscribe"},
+		"hub.callback": {subscriber.URL + "/callback"},
+		"hub.topic":    {topic},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	subs, _ = hub.Subscriptions(topic)
+	assert.Equal(t, 0, len(subs))
+
+	// the blog publishes in-process
+	assert.NotNil(t, hub.Publish("http://elsewhere.example/feed.xml"))
+	assert.Nil(t, hub.Publish(topic))
+}
+
+func TestHubRejectsUnverifiedAndForeignTopics(t *testing.T) {
+	db, err := sql.Open("sqlite3", testDb)
+	assert.Nil(t, err)
+	defer os.Remove(testDb)
+
+	// a callback that doesn't echo the challenge
+	subscriber := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("nope"))
+		}))
+	defer subscriber.Close()
+
+	hub, _ := NewHub(db, "http://blog.example/websub", "http://blog.example")
+	hub.async = func(f func()) { f() }
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/websub", strings.NewReader(url.Values{
+		"hub.mode":     {"subscribe"},
+		"hub.callback": {subscriber.URL},
+		"hub.topic":    {"http://elsewhere.example/feed.xml"},
+	}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	hub.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/websub", strings.NewReader(url.Values{
+		"hub.mode":     {"subscribe"},
+		"hub.callback": {subscriber.URL},
+		"hub.topic":    {"http://blog.example/feed.xml"},
+	}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	hub.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+
+	subs, _ := hub.Subscriptions("http://blog.example/feed.xml")
+	assert.Equal(t, 0, len(subs))
+}
+
+func TestHubAcceptsTopic(t *testing.T) {
+	hub := &Hub{TopicPrefix: "https://blog.example.com/blog/"}
+
+	assert.True(t, hub.acceptsTopic("https://blog.example.com/blog/feed.xml"))
+	assert.True(t, hub.acceptsTopic("https://BLOG.example.com/blog"))
+	assert.False(t, hub.acceptsTopic("https://blog.example.com.evil.net/blog/feed.xml"))
+	assert.False(t, hub.acceptsTopic("https://blog.example.com@evil.net/blog/feed.xml"))
+	assert.False(t, hub.acceptsTopic("http://blog.example.com/blog/feed.xml"))
+	assert.False(t, hub.acceptsTopic("https://blog.example.com/blogroll.xml"))
+	assert.False(t, hub.acceptsTopic("https://blog.example.com/feed.xml"))
+
+	hub.TopicPrefix = "https://blog.example.com"
+	assert.True(t, hub.acceptsTopic("https://blog.example.com"))
+	assert.True(t, hub.acceptsTopic("https://blog.example.com/feed.xml"))
+	assert.False(t, hub.acceptsTopic("https://blog.example.com:8080/feed.xml"))
+}
+
+func TestSign(t *testing.T) {
+	sig := Sign("key", []byte("content"))
+	assert.True(t, strings.HasPrefix(sig, "sha256="))
+	assert.True(t, VerifySignature("key", sig, []byte("content")))
+	assert.False(t, VerifySignature("other", sig, []byte("content")))
+}
@@ -0,0 +1,87 @@
+/*
+Package websub implements the publisher side of WebSub
+(https://www.w3.org/TR/websub/) and a minimal hub that can run inside
+goldfrogd for blogs without an external hub.
+*/
+package websub
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var logger = logrus.New()
+
+func init() {
+	logger.SetLevel(logrus.DebugLevel)
+}
+
+var httpClient = &http.Client{
+	Timeout: 30 * time.Second,
+}
+
+/*
+Publish notifies hubURL that the given topics have new content. The
+hub is sent one `hub.mode=publish` request per topic, which is the
+form most hubs accept.
+*/
+func Publish(hubURL string, topics ...string) error {
+	var errs []string
+
+	for _, topic := range topics {
+		logger.Infof("Pinging hub %s for %s", hubURL, topic)
+
+		form := url.Values{}
+		form.Set("hub.mode", "publish")
+		form.Set("hub.url", topic)
+
+		resp, err := httpClient.PostForm(hubURL, form)
+		if err != nil {
+			logger.Error(err)
+			errs = append(errs, err.Error())
+			continue
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			msg := fmt.Sprintf(
+				"hub returned %d for %s: %s", resp.StatusCode, topic, body)
+			logger.Error(msg)
+			errs = append(errs, msg)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+/*
+Sign returns the X-Hub-Signature header value for content
+distributed to a subscriber that registered with secret.
+*/
+func Sign(secret string, content []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(content)
+	return fmt.Sprintf("sha256=%x", mac.Sum(nil))
+}
+
+/*
+VerifySignature checks an X-Hub-Signature header against content, as
+a subscriber would.
+*/
+func VerifySignature(secret string, signature string, content []byte) bool {
+	expected := Sign(secret, content)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}